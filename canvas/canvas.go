@@ -2,6 +2,7 @@ package canvas
 
 import "fmt"
 
+// Canvas is a grid of straight, not premultiplied, 16-bit RGBA colors.
 type Canvas struct {
 	width  uint32
 	height uint32
@@ -40,6 +41,11 @@ func (c *Canvas) WriteAt(x, y uint32, color Color) {
 	c.grid[c.toIndex(x, y)] = color.Value()
 }
 
+// Copy of c not sharing its pixels.
+func (c *Canvas) Clone() Canvas {
+	return Canvas{c.width, c.height, append([]uint64(nil), c.grid...)}
+}
+
 // Convert x and y coordinates to index within c's grid.
 func (c *Canvas) toIndex(x, y uint32) int {
 	return int((y * c.width) + x)
@@ -36,3 +36,14 @@ func TestWriteAndAt(t *testing.T) {
 		t.Errorf("Expecting 0,0 to be %q, got %q", 4, p)
 	}
 }
+
+func TestClone(t *testing.T) {
+	canvas := MakeCanvas(2, 1)
+	canvas.WriteAt(0, 0, MakeColor(1))
+
+	clone := canvas.Clone()
+	canvas.WriteAt(0, 0, MakeColor(2))
+	if p := clone.At(0, 0).Value(); p != 1 {
+		t.Errorf("Expecting the clone to keep %d, got %d", 1, p)
+	}
+}
@@ -0,0 +1,45 @@
+package canvas
+
+import "math"
+
+// Lookup tables between 16-bit sRGB encoded and 16-bit linear light channel
+// values.
+var srgbToLinearLut = makeLut(SrgbToLinearFloat)
+var linearToSrgbLut = makeLut(LinearToSrgbFloat)
+
+// Convert an sRGB encoded value from 0 to 1 to linear light, with the sRGB
+// transfer function.
+func SrgbToLinearFloat(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Convert a linear light value from 0 to 1 to sRGB encoding, with the inverse
+// sRGB transfer function.
+func LinearToSrgbFloat(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Convert an sRGB encoded channel value to linear light.
+func SrgbToLinear(v uint16) uint16 {
+	return srgbToLinearLut[v]
+}
+
+// Convert a linear light channel value to sRGB encoding.
+func LinearToSrgb(v uint16) uint16 {
+	return linearToSrgbLut[v]
+}
+
+func makeLut(f func(float64) float64) *[1 << 16]uint16 {
+	var lut [1 << 16]uint16
+	for i := range lut {
+		lut[i] = uint16(math.Round(f(float64(i)/math.MaxUint16) * math.MaxUint16))
+	}
+
+	return &lut
+}
@@ -0,0 +1,33 @@
+package canvas
+
+import "testing"
+
+func TestSrgbLinearLuts(t *testing.T) {
+	for _, v := range []uint16{0, 0xFFFF} {
+		if l := SrgbToLinear(v); l != v {
+			t.Errorf("Expecting %X to stay %X, got %X", v, v, l)
+		}
+		if s := LinearToSrgb(v); s != v {
+			t.Errorf("Expecting %X to stay %X, got %X", v, v, s)
+		}
+	}
+
+	// sRGB 50% gray is about 21.4% linear light.
+	if l := SrgbToLinear(0x8000); l < 0x36A0 || l > 0x36F0 {
+		t.Errorf("Expecting about 0x36C8, got %X", l)
+	}
+
+	var previous uint16
+	for v := 0; v <= 0xFFFF; v += 0x101 {
+		l := SrgbToLinear(uint16(v))
+		if l < previous {
+			t.Fatalf("Expecting SrgbToLinear to be monotonic at %X", v)
+		}
+		previous = l
+
+		back := int(LinearToSrgb(l))
+		if v > 0x1000 && (back+0x20 < v || back > v+0x20) {
+			t.Errorf("Expecting %X to round-trip, got %X", v, back)
+		}
+	}
+}
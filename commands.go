@@ -0,0 +1,1421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"webpfex/encode"
+	"webpfex/webpfex"
+)
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:    "extract",
+			usage:   "extract [OPTIONS] AWEBP OUTDIR\nextract [OPTIONS] INPUT... OUTDIR",
+			summary: "extract the composited frames of an animated WEBP as PNGs",
+			help: `
+Extract every frame of AWEBP, composited like a browser would show it, as
+numbered PNGs in OUTDIR, along with their durations in the timing file
+OUTDIR/timing.txt that assemble reads.
+` + batchHelp + `
+Options:
+` + processFlagsHelp,
+			run: func(ctx context.Context, c *cli, args []string) error {
+				return runProcess(ctx, c, "extract", args)
+			},
+		},
+		{
+			name:    "convert",
+			usage:   "convert [OPTIONS] AWEBP OUTMP4\nconvert [OPTIONS] INPUT... OUTDIR",
+			summary: "convert an animated WEBP to MP4",
+			help: `
+Convert AWEBP to an MP4 video at OUTMP4 using ffmpeg, keeping the duration of
+every frame. Colors are tagged after a recognized ICC profile, and the EXIF
+description, artist and copyright kept. The XMP packet, and profiles that can't
+be tagged, are written next to OUTMP4 with an .xmp and .icc extension.
+` + batchHelp + `
+Options:
+` + convertFlagsHelp + retimeFlagsHelp + processFlagsHelp,
+			run: func(ctx context.Context, c *cli, args []string) error {
+				return runProcess(ctx, c, "convert", args)
+			},
+		},
+		{
+			name:    "transform",
+			usage:   "transform [OPTIONS] AWEBP OUT\ntransform [OPTIONS] INPUT... OUTDIR",
+			summary: "resize, crop, rotate or flip an animated WEBP",
+			help: `
+Transform every composited frame of AWEBP, in the order the options are given,
+and write the result to OUT: an animated WEBP if it ends in .webp, an MP4 if it
+ends in .mp4 and numbered PNGs in that directory otherwise. Frames are resized
+by webpfex itself, MP4s are only padded to even sizes.
+` + batchHelp + `
+Options:
+` + transformFlagsHelp + formatFlagHelp + retimeFlagsHelp + encodeFlagsHelp +
+				processFlagsHelp,
+			run: func(ctx context.Context, c *cli, args []string) error {
+				return runProcess(ctx, c, "transform", args)
+			},
+		},
+		{
+			name:    "retime",
+			usage:   "retime [OPTIONS] AWEBP OUT\nretime [OPTIONS] INPUT... OUTDIR",
+			summary: "change the speed, order or durations of frames of an animated WEBP",
+			help: `
+Change the timing of the composited frames of AWEBP and write the result to
+OUT: an animated WEBP if it ends in .webp, an MP4 if it ends in .mp4 and
+numbered PNGs in that directory otherwise. Durations are set first, then
+frames reversed, then sped up.
+` + batchHelp + `
+Options:
+` + retimeFlagsHelp + formatFlagHelp + encodeFlagsHelp + processFlagsHelp,
+			run: func(ctx context.Context, c *cli, args []string) error {
+				return runProcess(ctx, c, "retime", args)
+			},
+		},
+		{
+			name:    "assemble",
+			usage:   "assemble [OPTIONS] DIR OUT",
+			summary: "assemble the images in a directory into an animated WEBP",
+			help: `
+Encode the PNG, JPEG and WEBP images in DIR, in natural sort order so that
+frame2.png comes before frame10.png, as the frames of an animated WEBP at OUT.
+The images must all be the same size.
+
+A timing file gives the durations of frames, one per line, either in order or
+after a file name:
+  40ms
+  frame2.png 1.5s
+Durations without a unit are milliseconds. DIR/timing.txt, as extract writes
+it, is read unless --timing is given.
+
+Options:
+  --duration=D     duration of frames without one in the timing file, like
+                   40ms (default 100ms)
+  --timing=FILE    read the durations of frames from FILE instead of
+                   DIR/timing.txt
+  --loops=N        times the animation plays, 0 (default) for forever
+` + timingPolicyFlagHelp + encodeFlagsHelp + `  --progress=MODE  auto, bar, json or none
+  --ffmpeg=PATH    ffmpeg to run instead of $WEBPFEX_FFMPEG or the one in PATH
+` + commonFlagsHelp,
+			run: runAssemble,
+		},
+		{
+			name:    "import",
+			usage:   "import [OPTIONS] INPUT OUT",
+			summary: "convert a GIF, animated PNG or video to an animated WEBP",
+			help: `
+Encode INPUT as an animated WEBP at OUT, keeping the duration of every frame.
+GIFs and animated PNGs are decoded by webpfex, honoring how their frames are
+disposed of, and videos like MP4s by ffmpeg, timing frames by their timestamps.
+Videos are imported as stored, ignoring rotation metadata.
+
+Options:
+  --loops=N        times the animation plays, 0 for forever, instead of as
+                   many times as INPUT does
+` + timingPolicyFlagHelp + encodeFlagsHelp + `  --progress=MODE  auto, bar, json or none
+  --ffmpeg=PATH    ffmpeg to run instead of $WEBPFEX_FFMPEG or the one in PATH
+` + commonFlagsHelp,
+			run: runImport,
+		},
+		{
+			name:    "info",
+			usage:   "info [OPTIONS] AWEBP",
+			summary: "describe an animated WEBP and its frames",
+			help: `
+Print the canvas size, loop count, duration, metadata and frames of AWEBP.
+
+Options:
+  --json           print a JSON object instead
+  --trim           also report the box --trim would crop frames to, which
+                   composites every frame
+  --trim-padding=N pixels added around that box
+` + toolFlagsHelp,
+			run: runInfo,
+		},
+		{
+			name:    "lint",
+			usage:   "lint [OPTIONS] AWEBP",
+			summary: "check an animated WEBP against the container spec",
+			help: `
+Report the problems of AWEBP, each as an error, a warning or for information:
+frames outside the canvas, larger than it or of another size than their
+bitstream, durations of 0 or 10ms and less that browsers slow down, missing or
+stale VP8X flags, metadata decoders ignore, truncated, duplicate or misplaced
+chunks, ANIM chunks with different loop counts, and the canvas, loop count,
+frame count and frames webpmux reports differing from the chunks or at odd
+offsets. Exits with 1 if there are errors.
+
+Options:
+  --json           print a JSON object instead
+  --strict         also exit with 1 if there are warnings
+` + toolFlagsHelp,
+			run: runLint,
+		},
+		{
+			name: "chunks",
+			usage: "chunks [OPTIONS] WEBP\n" +
+				"chunks drop [OPTIONS] FOURCC WEBP OUT\n" +
+				"chunks replace [OPTIONS] FOURCC FILE WEBP OUT\n" +
+				"chunks extract [OPTIONS] FOURCC WEBP OUT",
+			summary: "list, drop, replace or extract the RIFF chunks of a WEBP",
+			help: `
+List every chunk of WEBP, frames' included, with its offset, size and decoded
+header fields.
+
+drop removes the chunks named FOURCC, like EXIF, and replace sets the payload
+of the first one to the content of FILE, like an ICC profile for ICCP, adding
+ICCP, EXIF and XMP chunks that aren't there. Both write the result to OUT,
+which may be WEBP given --overwrite, sizing chunks and the RIFF header anew and
+setting the metadata flags of the VP8X chunk. extract writes the payload of the
+first chunk named FOURCC to OUT.
+
+Options:
+  --json           print a JSON object instead of listing
+  --index=N        drop, replace or extract only the Nth chunk named FOURCC
+` + commonFlagsHelp,
+			run: runChunks,
+		},
+		{
+			name:    "repair",
+			usage:   "repair [OPTIONS] AWEBP [OUT]",
+			summary: "recover the complete frames of a truncated or corrupt animated WEBP",
+			help: `
+Recover every complete frame of AWEBP, even if webpmux can't read it: chunks cut
+off and frames whose header or bitstream is unreadable are dropped, then the
+RIFF size and the VP8X flags are fixed and the repaired WEBP written to OUT,
+which may be AWEBP given --overwrite. Prints what was dropped and fixed, and
+only that without OUT.
+
+Options:
+  --extract        extract the recovered frames as PNGs into OUT, a
+                   directory, instead, with webpmux like extract does
+  --json           print a JSON object instead
+` + toolFlagsHelp + "\n" + commonFlagsHelp,
+			run: runRepair,
+		},
+		{
+			name:    "doctor",
+			usage:   "doctor [OPTIONS]",
+			summary: "check that webpmux and ffmpeg are installed and usable",
+			help: `
+Report where webpmux and ffmpeg are found, their versions and whether ffmpeg
+has the encoders webpfex needs: libx264 for MP4s and libwebp for --lossy.
+Exits with 4 if anything is missing.
+
+Options:
+` + toolFlagsHelp,
+			run: runDoctor,
+		},
+	}
+}
+
+const batchHelp = `
+Given several inputs, directories or globs, every WEBP is processed into OUTDIR
+concurrently, mirroring the layout below each input directory. Files that
+aren't animated are skipped.
+`
+
+const processFlagsHelp = `  --color=MODE     passthrough (default) keeps colors and carries the ICC
+                   profile into the output, srgb converts them to sRGB
+  --blend=MODE     gamma (default) blends frames like browsers do, linear
+                   blends in linear light for higher quality masters
+  --fps=N          resample to N frames per second, like 30 or 30000/1001,
+                   repeating or dropping frames, exactly keeping the total
+                   duration to within half a frame
+  --cross-fade     with --fps, blend the frames each output frame spans
+                   rather than repeating or dropping them
+` + timingPolicyFlagHelp + `  --background=BG  flatten frames onto BG, for outputs without transparency:
+                   a color like #RRGGBB, black or white, file for the
+                   animation's own background color, checkerboard, or the
+                   path of a PNG or WEBP image stretched to the frames
+  --trim           crop frames to the box holding everything differing from
+                   the background, over the whole animation
+  --trim-padding=N pixels kept around that box
+  --progress=MODE  auto (a progress bar when stderr is a terminal), bar, json
+                   (one JSON object per line on stdout) or none
+  --recursive      walk input directories down to their subdirectories
+  --jobs=N         files processed at once in batch mode
+` + toolFlagsHelp + "\n" + commonFlagsHelp
+
+const convertFlagsHelp = `  --coalesce[=N]   merge runs of identical frames into one lasting as long;
+                   with N, frames whose channels differ by at most N out of
+                   255 count as identical
+  --loops=N        play the animation N times, by default as many times as
+                   its loop count says, or once if it loops forever
+  --min-duration=D repeat the animation until it lasts at least D, like 5s
+  --boomerang      play the animation forward then backward
+`
+
+const transformFlagsHelp = `  --resize=WxH     resize to W x H pixels, or to W wide or H high keeping the
+                   aspect ratio with Wx or xH
+  --filter=NAME    lanczos (default), catmullrom, bilinear or nearest, to
+                   resize with
+  --crop=WxH+X+Y   keep W x H pixels from X, Y
+  --rotate=DEGREES rotate clockwise by 90, 180 or 270 degrees
+  --flip=AXIS      flip horizontal or vertical
+`
+
+const formatFlagHelp = `  --format=FORMAT  webp, mp4 or png, instead of guessing from OUT, in batch
+                   mode png unless given
+`
+
+const retimeFlagsHelp = `  --speed=X        play X times faster, or slower below 1; frames sped up to
+                   10ms or less, which browsers would show for 100ms, are
+                   merged with the next ones instead
+  --reverse        play frames last to first
+  --durations=FILE set the durations of frames from FILE, one per line in
+                   order or after a frame number from 1, like "3 250ms"
+`
+
+const timingPolicyFlagHelp = `  --timing-policy=POLICY
+                   exact (default) keeps the durations of frames as stored,
+                   browser shows those of 10ms or less for 100ms like
+                   browsers do, min=D shows shorter ones for D, like 20ms
+`
+
+const encodeFlagsHelp = `  --optimize       store only what changes between frames in WEBPs
+  --lossy          encode WEBP frames lossily with ffmpeg's libwebp
+  --quality=N      quality of lossy frames, from 0 to 100 (default 75)
+`
+
+// Flags choosing how animated WEBPs are encoded.
+type encodeFlags struct {
+	optimize bool
+	lossy    bool
+	quality  int
+}
+
+func addEncodeFlags(flags *flag.FlagSet) *encodeFlags {
+	var f encodeFlags
+	flags.BoolVar(&f.optimize, "optimize", false, "")
+	flags.BoolVar(&f.lossy, "lossy", false, "")
+	flags.IntVar(&f.quality, "quality", 75, "")
+
+	return &f
+}
+
+func (f *encodeFlags) apply(opts *webpfex.Options) error {
+	if f.quality < 0 || f.quality > 100 {
+		return usageError{"--quality must be from 0 to 100, got " + strconv.Itoa(f.quality)}
+	}
+
+	opts.Optimize = f.optimize
+	opts.Lossy = f.lossy
+	opts.Quality = f.quality
+	return nil
+}
+
+// Records what --coalesce and --optimize saved, to print with --verbose.
+type savingsRecorder struct {
+	savings []webpfex.Savings
+}
+
+func (r *savingsRecorder) Record(s webpfex.Savings) {
+	r.savings = append(r.savings, s)
+}
+
+func (r *savingsRecorder) Print(w io.Writer) {
+	for _, s := range r.savings {
+		if s.Merged > 0 {
+			fmt.Fprintf(w, "  merged %d frames into previous ones\n", s.Merged)
+		}
+		if s.Area.Frames > 0 {
+			fmt.Fprintf(w, "  stored %d of %d pixels in %d frames, %.0f%% less\n",
+				s.Area.StoredPixels, s.Area.WholePixels, s.Area.Frames,
+				100*s.Area.AreaSavings())
+		}
+	}
+}
+
+// Output formats.
+const (
+	formatPng  = "png"
+	formatMp4  = "mp4"
+	formatWebp = "webp"
+)
+
+// Geometry flags of transform, which may be repeated and apply in order.
+type transformFlags struct {
+	transforms []webpfex.Transform
+	filter     string
+}
+
+func addTransformFlags(flags *flag.FlagSet) *transformFlags {
+	var f transformFlags
+	add := func(name string, parse func(s string) (webpfex.Transform, error)) {
+		flags.Var(transformFlag{&f.transforms, parse}, name, "")
+	}
+	add("resize", func(s string) (webpfex.Transform, error) {
+		return webpfex.ParseResize(s, webpfex.ResizeLanczos)
+	})
+	add("crop", func(s string) (webpfex.Transform, error) { return webpfex.ParseCrop(s) })
+	add("rotate", func(s string) (webpfex.Transform, error) { return webpfex.ParseRotate(s) })
+	add("flip", func(s string) (webpfex.Transform, error) { return webpfex.ParseFlip(s) })
+	flags.StringVar(&f.filter, "filter", "lanczos", "")
+
+	return &f
+}
+
+// The transforms given, resizing with the filter given.
+func (f *transformFlags) parse() ([]webpfex.Transform, error) {
+	kernel, err := webpfex.ParseResizeKernel(f.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	transforms := make([]webpfex.Transform, len(f.transforms))
+	for i, t := range f.transforms {
+		if resize, ok := t.(webpfex.Resize); ok {
+			resize.Kernel = kernel
+			t = resize
+		}
+		transforms[i] = t
+	}
+	return transforms, nil
+}
+
+// Value of a geometry flag, appended to the transforms given so far.
+type transformFlag struct {
+	transforms *[]webpfex.Transform
+	parse      func(s string) (webpfex.Transform, error)
+}
+
+func (f transformFlag) String() string {
+	return ""
+}
+
+func (f transformFlag) Set(s string) error {
+	t, err := f.parse(s)
+	if err != nil {
+		return err
+	}
+	*f.transforms = append(*f.transforms, t)
+	return nil
+}
+
+// Flags changing the timing of frames.
+type retimeFlags struct {
+	speed     float64
+	reverse   bool
+	durations string
+}
+
+func addRetimeFlags(flags *flag.FlagSet) *retimeFlags {
+	var f retimeFlags
+	flags.Float64Var(&f.speed, "speed", 1, "")
+	flags.BoolVar(&f.reverse, "reverse", false, "")
+	flags.StringVar(&f.durations, "durations", "", "")
+
+	return &f
+}
+
+func (f *retimeFlags) apply(opts *webpfex.Options) error {
+	if !(f.speed > 0) || math.IsInf(f.speed, 0) {
+		return usageError{"--speed must be over 0, got " + strconv.FormatFloat(f.speed, 'g', -1, 64)}
+	}
+	opts.Speed = f.speed
+	opts.Reverse = f.reverse
+
+	if f.durations != "" {
+		file, err := os.Open(f.durations)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		timing, err := webpfex.ParseTiming(file, 0)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.durations, err)
+		}
+		opts.Durations = &timing
+	}
+	return nil
+}
+
+// Value of --coalesce, which may be given a threshold.
+type coalesceFlag struct {
+	enabled   bool
+	threshold uint8
+}
+
+func (f *coalesceFlag) String() string {
+	return strconv.Itoa(int(f.threshold))
+}
+
+func (f *coalesceFlag) Set(s string) error {
+	if threshold, err := strconv.ParseUint(s, 10, 8); err == nil {
+		f.enabled = true
+		f.threshold = uint8(threshold)
+		return nil
+	}
+
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return errors.New("expecting a threshold of 0 to 255")
+	}
+	f.enabled = enabled
+	return nil
+}
+
+func (f *coalesceFlag) IsBoolFlag() bool {
+	return true
+}
+
+// Run the extract, convert, transform or retime command, according to name.
+func runProcess(ctx context.Context, c *cli, name string, args []string) error {
+	flags := newFlagSet(name)
+	common := addCommonFlags(flags)
+	tools := addToolFlags(flags)
+	progressMode := flags.String("progress", "auto", "")
+	colorMode := flags.String("color", "passthrough", "")
+	blendMode := flags.String("blend", "gamma", "")
+	recursive := flags.Bool("recursive", false, "")
+	jobs := flags.Int("jobs", runtime.NumCPU(), "")
+	background := flags.String("background", "", "")
+	trim := flags.Bool("trim", false, "")
+	trimPadding := flags.Uint("trim-padding", 0, "")
+	fps := flags.String("fps", "", "")
+	crossFade := flags.Bool("cross-fade", false, "")
+	timingPolicy := flags.String("timing-policy", "exact", "")
+	var transform *transformFlags
+	if name == "transform" {
+		transform = addTransformFlags(flags)
+	}
+	var retime *retimeFlags
+	if name != "extract" {
+		retime = addRetimeFlags(flags)
+	}
+	var outFormat string
+	var encoding *encodeFlags
+	if name == "transform" || name == "retime" {
+		flags.StringVar(&outFormat, "format", "", "")
+		encoding = addEncodeFlags(flags)
+	}
+	var coalesce coalesceFlag
+	var loops int
+	var minDuration time.Duration
+	var boomerang bool
+	if name == "convert" {
+		flags.Var(&coalesce, "coalesce", "")
+		flags.IntVar(&loops, "loops", 0, "")
+		flags.DurationVar(&minDuration, "min-duration", 0, "")
+		flags.BoolVar(&boomerang, "boomerang", false, "")
+	}
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := common.validate(); err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		return usageError{"expecting an input and an output"}
+	}
+	if *jobs < 1 {
+		return usageError{"--jobs must be at least 1, got " + strconv.Itoa(*jobs)}
+	}
+	if loops < 0 {
+		return usageError{"--loops must be at least 0 (0 plays as many times as the loop count says), got " + strconv.Itoa(loops)}
+	}
+	if minDuration < 0 {
+		return usageError{"--min-duration can't be negative, got " + minDuration.String()}
+	}
+
+	inputs := positional[:len(positional)-1]
+	out := positional[len(positional)-1]
+	batch := *recursive || len(inputs) > 1 || isDir(inputs[0])
+	format := formatPng
+	if name == "convert" {
+		format = formatMp4
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Overwrite = common.overwrite
+	opts.Tools = *tools
+	if opts.Color, err = webpfex.ParseColorMode(*colorMode); err != nil {
+		return usageError{err.Error()}
+	}
+	if opts.Blend, err = webpfex.ParseBlendMode(*blendMode); err != nil {
+		return usageError{err.Error()}
+	}
+	opts.Coalesce = coalesce.enabled
+	opts.CoalesceThreshold = coalesce.threshold
+	if *fps != "" {
+		if opts.FrameRate, err = encode.ParseFrameRate(*fps); err != nil {
+			return usageError{err.Error()}
+		}
+	}
+	opts.CrossFade = *crossFade
+	if opts.TimingPolicy, err = webpfex.ParseTimingPolicy(*timingPolicy); err != nil {
+		return usageError{err.Error()}
+	}
+	if opts.CrossFade && opts.FrameRate.IsZero() {
+		return usageError{"--cross-fade needs --fps"}
+	}
+	if opts.Coalesce && !opts.FrameRate.IsZero() {
+		return usageError{"--coalesce and --fps can't be combined"}
+	}
+	opts.Loops = loops
+	opts.MinDuration = minDuration
+	opts.Boomerang = boomerang
+	if *background != "" {
+		if opts.Background, err = webpfex.ParseBackground(*background); err != nil {
+			return usageError{err.Error()}
+		}
+	}
+	opts.Trim = *trim
+	if opts.TrimPadding, err = trimPaddingValue(*trimPadding); err != nil {
+		return err
+	}
+	if transform != nil {
+		if opts.Transforms, err = transform.parse(); err != nil {
+			return usageError{err.Error()}
+		}
+	}
+	if retime != nil {
+		if err := retime.apply(&opts); err != nil {
+			return err
+		}
+	}
+	if encoding != nil {
+		if format, err = outputFormat(outFormat, out, batch); err != nil {
+			return usageError{err.Error()}
+		}
+		if err := encoding.apply(&opts); err != nil {
+			return err
+		}
+	}
+
+	if batch {
+		return runBatch(ctx, c, format, inputs, out, *recursive, *jobs, opts, common)
+	}
+
+	if common.quiet {
+		*progressMode = "none"
+	}
+	reporter, err := makeProgressReporter(*progressMode, format, c.stdout, c.stderr)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	opts.Progress = reporter.Report
+	var savings savingsRecorder
+	opts.Savings = savings.Record
+
+	err = process(ctx, format, inputs[0], out, opts)
+	reporter.Finish()
+	if err == nil && common.verbose {
+		fmt.Fprintf(c.stderr, "%s -> %s\n", inputs[0], out)
+		savings.Print(c.stderr)
+	}
+
+	return err
+}
+
+// Format transform and retime write to out, given as format or else guessed
+// from its extension.
+func outputFormat(format string, out string, batch bool) (string, error) {
+	switch format {
+	case formatPng, formatMp4, formatWebp:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+
+	if !batch {
+		switch strings.ToLower(filepath.Ext(out)) {
+		case ".mp4":
+			return formatMp4, nil
+		case ".webp":
+			return formatWebp, nil
+		}
+	}
+	return formatPng, nil
+}
+
+// Write webp into out as format: numbered PNGs, an MP4 or an animated WEBP.
+func process(
+	ctx context.Context,
+	format string,
+	webp string,
+	out string,
+	opts webpfex.Options,
+) error {
+	switch format {
+	case formatPng:
+		return webpfex.ExtractWebpFramesAsPngContext(ctx, webp, out, opts)
+	case formatWebp:
+		return webpfex.ConvertWebpToAWebpContext(ctx, webp, out, opts)
+	default:
+		return webpfex.ConvertWebpToMp4Context(ctx, webp, out, opts)
+	}
+}
+
+// Process every WEBP among inputs into outdir, reporting failures, and every
+// file if verbose, then a summary on stderr.
+func runBatch(
+	ctx context.Context,
+	c *cli,
+	format string,
+	inputs []string,
+	outdir string,
+	recursive bool,
+	workers int,
+	opts webpfex.Options,
+	common *commonFlags,
+) error {
+	outExt := "." + format
+	if format == formatPng {
+		outExt = ""
+	}
+
+	jobs, err := webpfex.CollectBatchJobs(inputs, outdir, recursive, outExt)
+	if err != nil {
+		return err
+	}
+
+	results := webpfex.RunBatch(ctx, jobs, workers,
+		func(ctx context.Context, job webpfex.BatchJob) error {
+			return process(ctx, format, job.Input, job.Output, opts)
+		},
+		func(r webpfex.BatchResult) {
+			switch {
+			case r.Skipped:
+				if common.verbose {
+					fmt.Fprintf(c.stderr, "skipped %s: not animated\n", r.Job.Input)
+				}
+			case r.Err != nil:
+				fmt.Fprintf(c.stderr, "failed  %s: %v\n", r.Job.Input, r.Err)
+			default:
+				if common.verbose {
+					fmt.Fprintf(c.stderr, "done    %s -> %s\n", r.Job.Input, r.Job.Output)
+				}
+			}
+		})
+
+	succeeded, skipped, failed := webpfex.SummarizeBatch(results)
+	if !common.quiet {
+		fmt.Fprintf(c.stderr, "%d succeeded, %d skipped, %d failed\n",
+			succeeded, skipped, failed)
+	}
+
+	if failed > 0 {
+		return errReported
+	}
+	return nil
+}
+
+func trimPaddingValue(padding uint) (uint32, error) {
+	if padding > math.MaxUint32 {
+		return 0, usageError{"--trim-padding is too large"}
+	}
+	return uint32(padding), nil
+}
+
+func runAssemble(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("assemble")
+	common := addCommonFlags(flags)
+	encoding := addEncodeFlags(flags)
+	progressMode := flags.String("progress", "auto", "")
+	ffmpeg := flags.String("ffmpeg", "", "")
+	duration := flags.String("duration", "100ms", "")
+	timingFile := flags.String("timing", "", "")
+	loops := flags.Uint("loops", 0, "")
+	timingPolicy := flags.String("timing-policy", "exact", "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := common.validate(); err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError{"expecting a directory and an output"}
+	}
+	if *loops > math.MaxUint16 {
+		return usageError{"--loops can't be over " + strconv.Itoa(math.MaxUint16)}
+	}
+	defaultDuration, err := webpfex.ParseFrameDuration(*duration)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Overwrite = common.overwrite
+	opts.Tools.Ffmpeg = *ffmpeg
+	if opts.TimingPolicy, err = webpfex.ParseTimingPolicy(*timingPolicy); err != nil {
+		return usageError{err.Error()}
+	}
+	if err := encoding.apply(&opts); err != nil {
+		return err
+	}
+
+	dir, out := positional[0], positional[1]
+	timing := webpfex.Timing{Default: defaultDuration}
+	if *timingFile == "" {
+		sidecar := filepath.Join(dir, webpfex.TimingSidecar)
+		if _, err := os.Stat(sidecar); err == nil {
+			*timingFile = sidecar
+		}
+	}
+	if *timingFile != "" {
+		file, err := os.Open(*timingFile)
+		if err != nil {
+			return err
+		}
+		timing, err = webpfex.ParseTiming(file, defaultDuration)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *timingFile, err)
+		}
+	}
+
+	if common.quiet {
+		*progressMode = "none"
+	}
+	reporter, err := makeProgressReporter(*progressMode, formatWebp, c.stdout, c.stderr)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	opts.Progress = reporter.Report
+	var savings savingsRecorder
+	opts.Savings = savings.Record
+
+	err = webpfex.AssembleAWebpContext(ctx, dir, out, timing, uint16(*loops), opts)
+	reporter.Finish()
+	if err == nil && common.verbose {
+		fmt.Fprintf(c.stderr, "%s -> %s\n", dir, out)
+		savings.Print(c.stderr)
+	}
+
+	return err
+}
+
+func runImport(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("import")
+	common := addCommonFlags(flags)
+	encoding := addEncodeFlags(flags)
+	progressMode := flags.String("progress", "auto", "")
+	ffmpeg := flags.String("ffmpeg", "", "")
+	loops := flags.Int("loops", -1, "")
+	timingPolicy := flags.String("timing-policy", "exact", "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := common.validate(); err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError{"expecting an input and an output"}
+	}
+	if *loops > math.MaxUint16 {
+		return usageError{"--loops can't be over " + strconv.Itoa(math.MaxUint16)}
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Overwrite = common.overwrite
+	opts.Tools.Ffmpeg = *ffmpeg
+	if opts.TimingPolicy, err = webpfex.ParseTimingPolicy(*timingPolicy); err != nil {
+		return usageError{err.Error()}
+	}
+	if err := encoding.apply(&opts); err != nil {
+		return err
+	}
+
+	if common.quiet {
+		*progressMode = "none"
+	}
+	reporter, err := makeProgressReporter(*progressMode, formatWebp, c.stdout, c.stderr)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	opts.Progress = reporter.Report
+	var savings savingsRecorder
+	opts.Savings = savings.Record
+
+	in, out := positional[0], positional[1]
+	err = webpfex.ImportAWebpContext(ctx, in, out, *loops, opts)
+	reporter.Finish()
+	if err == nil && common.verbose {
+		fmt.Fprintf(c.stderr, "%s -> %s\n", in, out)
+		savings.Print(c.stderr)
+	}
+
+	return err
+}
+
+func runInfo(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("info")
+	tools := addToolFlags(flags)
+	asJson := flags.Bool("json", false, "")
+	trim := flags.Bool("trim", false, "")
+	trimPadding := flags.Uint("trim-padding", 0, "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"expecting a single input"}
+	}
+	padding, err := trimPaddingValue(*trimPadding)
+	if err != nil {
+		return err
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Tools = *tools
+	info, err := webpfex.LoadAWebpInfo(ctx, positional[0], opts)
+	if err != nil {
+		return err
+	}
+	var trimBox *image.Rectangle
+	if *trim {
+		box, err := webpfex.TrimBox(ctx, positional[0], info, opts, padding)
+		if err != nil {
+			return err
+		}
+		trimBox = &box
+	}
+
+	if *asJson {
+		return printInfoJson(c.stdout, info, trimBox)
+	}
+	printInfo(c.stdout, info, trimBox)
+	return nil
+}
+
+func printInfo(w io.Writer, info webpfex.AWebpInfo, trimBox *image.Rectangle) {
+	loop := "forever"
+	if info.LoopCount != 0 {
+		loop = fmt.Sprintf("%d times", info.LoopCount)
+	}
+	var metadata []string
+	for _, m := range []struct {
+		name    string
+		present bool
+	}{
+		{"ICC", info.Metadata.ICC != nil},
+		{"EXIF", info.Metadata.EXIF != nil},
+		{"XMP", info.Metadata.XMP != nil},
+	} {
+		if m.present {
+			metadata = append(metadata, m.name)
+		}
+	}
+	if metadata == nil {
+		metadata = []string{"none"}
+	}
+
+	fmt.Fprintf(w, "canvas    %dx%d\n", info.Width, info.Height)
+	fmt.Fprintf(w, "loops     %s\n", loop)
+	fmt.Fprintf(w, "bgcolor   %s\n", webpfex.FormatColor(info.BackgroundColor))
+	fmt.Fprintf(w, "duration  %s\n", webpfex.TotalDuration(info))
+	fmt.Fprintf(w, "metadata  %s\n", strings.Join(metadata, " "))
+	if trimBox != nil {
+		fmt.Fprintf(w, "trim      %dx%d+%d+%d\n",
+			trimBox.Dx(), trimBox.Dy(), trimBox.Min.X, trimBox.Min.Y)
+	}
+	fmt.Fprintf(w, "frames    %d\n", info.FrameCount)
+	fmt.Fprintln(w, "  no.  width height  x    y    duration alpha blend dispose")
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	for _, f := range info.FrameInfos {
+		fmt.Fprintf(w, "  %-4d %-5d %-6d %-4d %-4d %-8s %-5s %-5s %s\n",
+			f.Number, f.Width, f.Height, f.XOffset, f.YOffset, f.Duration,
+			yesNo[f.Alpha], yesNo[f.Blend], yesNo[f.Dispose])
+	}
+}
+
+type jsonRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type jsonFrameInfo struct {
+	Number     uint32 `json:"number"`
+	Width      uint32 `json:"width"`
+	Height     uint32 `json:"height"`
+	XOffset    uint32 `json:"x_offset"`
+	YOffset    uint32 `json:"y_offset"`
+	DurationMs int64  `json:"duration_ms"`
+	Alpha      bool   `json:"alpha"`
+	Blend      bool   `json:"blend"`
+	Dispose    bool   `json:"dispose"`
+}
+
+func printInfoJson(w io.Writer, info webpfex.AWebpInfo, trimBox *image.Rectangle) error {
+	out := struct {
+		Width      uint32          `json:"width"`
+		Height     uint32          `json:"height"`
+		LoopCount  uint16          `json:"loop_count"`
+		Background string          `json:"background_color"`
+		DurationMs int64           `json:"duration_ms"`
+		FrameCount uint32          `json:"frame_count"`
+		ICC        bool            `json:"icc"`
+		EXIF       bool            `json:"exif"`
+		XMP        bool            `json:"xmp"`
+		Trim       *jsonRect       `json:"trim,omitempty"`
+		Frames     []jsonFrameInfo `json:"frames"`
+	}{
+		Width:      info.Width,
+		Height:     info.Height,
+		LoopCount:  info.LoopCount,
+		Background: webpfex.FormatColor(info.BackgroundColor),
+		DurationMs: webpfex.TotalDuration(info).Milliseconds(),
+		FrameCount: info.FrameCount,
+		ICC:        info.Metadata.ICC != nil,
+		EXIF:       info.Metadata.EXIF != nil,
+		XMP:        info.Metadata.XMP != nil,
+		Frames:     []jsonFrameInfo{},
+	}
+	if trimBox != nil {
+		out.Trim = &jsonRect{trimBox.Min.X, trimBox.Min.Y, trimBox.Dx(), trimBox.Dy()}
+	}
+	for _, f := range info.FrameInfos {
+		out.Frames = append(out.Frames, jsonFrameInfo{
+			Number:     f.Number,
+			Width:      f.Width,
+			Height:     f.Height,
+			XOffset:    f.XOffset,
+			YOffset:    f.YOffset,
+			DurationMs: f.Duration.Milliseconds(),
+			Alpha:      f.Alpha,
+			Blend:      f.Blend,
+			Dispose:    f.Dispose,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func runLint(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("lint")
+	tools := addToolFlags(flags)
+	asJson := flags.Bool("json", false, "")
+	strict := flags.Bool("strict", false, "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{"expecting a single input"}
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Tools = *tools
+	findings, err := webpfex.LintAWebpFileContext(ctx, positional[0], opts)
+	if err != nil {
+		return err
+	}
+
+	errorCount := webpfex.CountFindings(findings, webpfex.SeverityError)
+	warningCount := webpfex.CountFindings(findings, webpfex.SeverityWarning)
+	if *asJson {
+		if err := printLintJson(c.stdout, findings, errorCount, warningCount); err != nil {
+			return err
+		}
+	} else {
+		printLint(c.stdout, findings, errorCount, warningCount)
+	}
+
+	if errorCount > 0 || *strict && warningCount > 0 {
+		return errReported
+	}
+	return nil
+}
+
+func printLint(w io.Writer, findings []webpfex.LintFinding, errorCount, warningCount int) {
+	for _, f := range findings {
+		var where []string
+		if f.Frame != 0 {
+			where = append(where, fmt.Sprintf("frame %d", f.Frame))
+		}
+		if f.Offset >= 0 {
+			where = append(where, fmt.Sprintf("offset %d", f.Offset))
+		}
+		location := ""
+		if where != nil {
+			location = strings.Join(where, ", ") + ": "
+		}
+		fmt.Fprintf(w, "%s: %s%s [%s]\n", f.Severity, location, f.Message, f.Check)
+	}
+
+	plural := func(n int, noun string) string {
+		if n == 1 {
+			return "1 " + noun
+		}
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+	fmt.Fprintf(w, "%s, %s\n", plural(errorCount, "error"), plural(warningCount, "warning"))
+}
+
+type jsonLintFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Frame    uint32 `json:"frame,omitempty"`
+	Offset   *int64 `json:"offset,omitempty"`
+	Message  string `json:"message"`
+}
+
+func printLintJson(w io.Writer, findings []webpfex.LintFinding, errorCount, warningCount int) error {
+	out := struct {
+		Errors   int               `json:"errors"`
+		Warnings int               `json:"warnings"`
+		Findings []jsonLintFinding `json:"findings"`
+	}{
+		Errors:   errorCount,
+		Warnings: warningCount,
+		Findings: []jsonLintFinding{},
+	}
+	for _, f := range findings {
+		finding := jsonLintFinding{
+			Severity: f.Severity.String(),
+			Check:    f.Check,
+			Frame:    f.Frame,
+			Message:  f.Message,
+		}
+		if f.Offset >= 0 {
+			offset := f.Offset
+			finding.Offset = &offset
+		}
+		out.Findings = append(out.Findings, finding)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func runChunks(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("chunks")
+	common := addCommonFlags(flags)
+	asJson := flags.Bool("json", false, "")
+	index := flags.Uint("index", 0, "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := common.validate(); err != nil {
+		return err
+	}
+	action := "list"
+	if len(positional) > 0 {
+		switch positional[0] {
+		case "list", "drop", "replace", "extract":
+			action = positional[0]
+			positional = positional[1:]
+		}
+	}
+
+	expected := map[string]int{"list": 1, "drop": 3, "replace": 4, "extract": 3}[action]
+	if len(positional) != expected {
+		return usageError{fmt.Sprintf("expecting %d arguments to %s", expected, action)}
+	}
+	if action == "list" {
+		if *index != 0 {
+			return usageError{"--index is for drop, replace and extract"}
+		}
+		chunks, err := webpfex.ReadWebpChunks(positional[0])
+		if err != nil {
+			return err
+		}
+		if *asJson {
+			return printChunksJson(c.stdout, chunks)
+		}
+		printChunks(c.stdout, chunks)
+		return nil
+	}
+	if *asJson {
+		return usageError{"--json is for listing chunks"}
+	}
+
+	fourCC, err := webpfex.ParseFourCC(positional[0])
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	selector := webpfex.ChunkSelector{FourCC: fourCC, Index: int(*index)}
+	in, out := positional[len(positional)-2], positional[len(positional)-1]
+	opts := webpfex.DefaultOptions()
+	opts.Overwrite = common.overwrite
+	chunks, err := webpfex.ReadWebpChunks(in)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "drop":
+		var dropped int
+		if chunks, dropped = webpfex.DropChunks(chunks, selector); dropped == 0 {
+			return fmt.Errorf("%s: no %s chunk to drop", in, selector)
+		}
+		if common.verbose {
+			fmt.Fprintf(c.stderr, "%s: dropped %d %s chunks\n", in, dropped, selector)
+		}
+	case "replace":
+		payload, err := os.ReadFile(positional[1])
+		if err != nil {
+			return err
+		}
+		if chunks, err = webpfex.ReplaceChunk(chunks, selector, payload); err != nil {
+			return fmt.Errorf("%s: %w", in, err)
+		}
+	case "extract":
+		chunk, ok := webpfex.SelectChunk(chunks, selector)
+		if !ok {
+			return fmt.Errorf("%s: no %s chunk to extract", in, selector)
+		}
+		if err := webpfex.WriteChunkPayload(out, chunk, opts); err != nil {
+			return err
+		}
+		if common.verbose {
+			fmt.Fprintf(c.stderr, "%s -> %s\n", in, out)
+		}
+		return nil
+	}
+
+	if err := webpfex.WriteWebpChunks(out, chunks, opts); err != nil {
+		return err
+	}
+	if common.verbose {
+		fmt.Fprintf(c.stderr, "%s -> %s\n", in, out)
+	}
+	return nil
+}
+
+func printChunks(w io.Writer, chunks []webpfex.Chunk) {
+	fmt.Fprintln(w, "offset     size       chunk  fields")
+	var list func(chunks []webpfex.Chunk, indent string)
+	list = func(chunks []webpfex.Chunk, indent string) {
+		for _, chunk := range chunks {
+			var fields []string
+			for _, f := range webpfex.ChunkFields(chunk) {
+				fields = append(fields, f.Name+"="+f.Value)
+			}
+			fmt.Fprintf(w, "%-10d %-10d %-6s %s\n", chunk.Offset, chunk.Size,
+				indent+strings.TrimRight(chunk.FourCC, " "), strings.Join(fields, " "))
+
+			if chunk.FourCC == "ANMF" {
+				frameChunks, err := webpfex.FrameChunks(chunk)
+				list(frameChunks, indent+"  ")
+				if err != nil {
+					fmt.Fprintf(w, "%s  %v\n", strings.Repeat(" ", 22)+indent, err)
+				}
+			}
+		}
+	}
+	list(chunks, "")
+}
+
+type jsonChunk struct {
+	FourCC string            `json:"fourcc"`
+	Offset int64             `json:"offset"`
+	Size   uint32            `json:"size"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Chunks []jsonChunk       `json:"chunks,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func printChunksJson(w io.Writer, chunks []webpfex.Chunk) error {
+	var convert func(chunks []webpfex.Chunk) []jsonChunk
+	convert = func(chunks []webpfex.Chunk) []jsonChunk {
+		converted := []jsonChunk{}
+		for _, chunk := range chunks {
+			j := jsonChunk{FourCC: chunk.FourCC, Offset: chunk.Offset, Size: chunk.Size}
+			for _, f := range webpfex.ChunkFields(chunk) {
+				if j.Fields == nil {
+					j.Fields = map[string]string{}
+				}
+				j.Fields[f.Name] = f.Value
+			}
+			if chunk.FourCC == "ANMF" {
+				frameChunks, err := webpfex.FrameChunks(chunk)
+				j.Chunks = convert(frameChunks)
+				if err != nil {
+					j.Error = err.Error()
+				}
+			}
+			converted = append(converted, j)
+		}
+		return converted
+	}
+
+	out := struct {
+		Chunks []jsonChunk `json:"chunks"`
+	}{convert(chunks)}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func runRepair(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("repair")
+	common := addCommonFlags(flags)
+	tools := addToolFlags(flags)
+	extract := flags.Bool("extract", false, "")
+	asJson := flags.Bool("json", false, "")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := common.validate(); err != nil {
+		return err
+	}
+	if len(positional) != 1 && len(positional) != 2 {
+		return usageError{"expecting an input and optionally an output"}
+	}
+	if *extract && len(positional) != 2 {
+		return usageError{"--extract needs an output directory"}
+	}
+
+	opts := webpfex.DefaultOptions()
+	opts.Overwrite = common.overwrite
+	opts.Tools = *tools
+	in := positional[0]
+	var recovery webpfex.Recovery
+	switch {
+	case len(positional) == 1:
+		data, err := os.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		recovery, err = webpfex.RecoverAWebp(data)
+		if err != nil {
+			return err
+		}
+	case *extract:
+		recovery, err = webpfex.ExtractRepairedAWebpContext(ctx, in, positional[1], opts)
+	default:
+		recovery, err = webpfex.RepairAWebp(in, positional[1], opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		return printRecoveryJson(c.stdout, recovery)
+	}
+	if !common.quiet {
+		printRecovery(c.stdout, recovery)
+	}
+	if common.verbose && len(positional) == 2 {
+		fmt.Fprintf(c.stderr, "%s -> %s\n", in, positional[1])
+	}
+	return nil
+}
+
+func printRecovery(w io.Writer, recovery webpfex.Recovery) {
+	for _, d := range recovery.Dropped {
+		chunk := "chunk"
+		if d.FourCC != "" {
+			chunk = strings.TrimRight(d.FourCC, " ")
+		}
+		frame := ""
+		if d.Frame != 0 {
+			frame = fmt.Sprintf(", frame %d", d.Frame)
+		}
+		fmt.Fprintf(w, "dropped %s at offset %d%s: %s\n", chunk, d.Offset, frame, d.Reason)
+	}
+	for _, fix := range recovery.Fixes {
+		fmt.Fprintln(w, fix)
+	}
+	fmt.Fprintf(w, "recovered %d frames\n", recovery.FrameCount)
+}
+
+type jsonDroppedChunk struct {
+	FourCC string `json:"fourcc,omitempty"`
+	Offset int64  `json:"offset"`
+	Frame  uint32 `json:"frame,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func printRecoveryJson(w io.Writer, recovery webpfex.Recovery) error {
+	out := struct {
+		FrameCount uint32             `json:"frame_count"`
+		Dropped    []jsonDroppedChunk `json:"dropped"`
+		Fixes      []string           `json:"fixes"`
+	}{
+		FrameCount: recovery.FrameCount,
+		Dropped:    []jsonDroppedChunk{},
+		Fixes:      append([]string{}, recovery.Fixes...),
+	}
+	for _, d := range recovery.Dropped {
+		out.Dropped = append(out.Dropped, jsonDroppedChunk(d))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+func isDir(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
+
+func runDoctor(ctx context.Context, c *cli, args []string) error {
+	flags := newFlagSet("doctor")
+	tools := addToolFlags(flags)
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError{"unexpected arguments"}
+	}
+
+	ok := true
+	for _, r := range webpfex.CheckTools(ctx, *tools) {
+		if r.Err != nil {
+			ok = false
+			fmt.Fprintf(c.stdout, "%-8s missing  %v\n", r.Name, r.Err)
+			continue
+		}
+
+		fmt.Fprintf(c.stdout, "%-8s ok       %s (%s) version %s\n",
+			r.Name, r.Path, r.Source, r.Version)
+		for _, encoder := range webpfex.RequiredFfmpegEncoders {
+			present, checked := r.Encoders[encoder]
+			if !checked {
+				continue
+			}
+
+			status := "ok"
+			if !present {
+				status = "missing"
+				ok = false
+			}
+			fmt.Fprintf(c.stdout, "  encoder %-11s %s\n", encoder, status)
+		}
+	}
+
+	if !ok {
+		return errToolsMissing
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Package encode writes animated WEBP files without relying on libwebp.
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+	"webpfex/canvas"
+)
+
+const (
+	maxUint24 = 1<<24 - 1
+
+	// VP8X flags.
+	flagIcc       = 0x20
+	flagAlpha     = 0x10
+	flagExif      = 0x08
+	flagXmp       = 0x04
+	flagAnimation = 0x02
+
+	// ANMF flags.
+	flagNoBlend = 0x02
+	flagDispose = 0x01
+)
+
+// Frame is a frame of an animation, drawn on the canvas at its offset.
+type Frame struct {
+	Canvas   canvas.Canvas
+	XOffset  uint32 // Even, WEBP stores offsets halved.
+	YOffset  uint32 // Even, WEBP stores offsets halved.
+	Duration time.Duration
+	Blend    bool // Alpha blend with the canvas rather than replace it.
+	Dispose  bool // Clear the frame's area to transparent after displaying it.
+}
+
+// AnimationOptions describe an animation as a whole.
+type AnimationOptions struct {
+	Width           uint32
+	Height          uint32
+	BackgroundColor canvas.Color
+	LoopCount       uint16 // 0 loops forever.
+	// Raw metadata chunks, nil when absent.
+	ICC  []byte
+	EXIF []byte
+	XMP  []byte
+	// Encodes frames, losslessly with EncodeVP8LChunk when nil.
+	Encoder FrameEncoder
+}
+
+// FrameEncoder encodes the canvas of a frame as the image chunks of an ANMF
+// chunk, headers included: VP8L, or ALPH then VP8. It also tells whether the
+// image uses alpha.
+type FrameEncoder func(cv canvas.Canvas) (chunks []byte, alpha bool, err error)
+
+// Encode cv as a VP8L chunk.
+func EncodeVP8LChunk(cv canvas.Canvas) ([]byte, bool, error) {
+	payload, err := EncodeVP8L(cv)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var chunk bytes.Buffer
+	writeChunk(&chunk, "VP8L", payload)
+	// The alpha_is_used bit of the VP8L header.
+	return chunk.Bytes(), payload[4]&0x10 != 0, nil
+}
+
+// AnimationWriter writes an animated WEBP frame by frame. Encoded frames are
+// held in memory until Close, as the RIFF header needs the file size.
+type AnimationWriter struct {
+	w       io.Writer
+	options AnimationOptions
+	frames  bytes.Buffer
+	count   int
+	alpha   bool
+	closed  bool
+}
+
+func MakeAnimationWriter(w io.Writer, options AnimationOptions) *AnimationWriter {
+	return &AnimationWriter{w: w, options: options}
+}
+
+// Encode frame. The canvas may be reused once this returns.
+func (a *AnimationWriter) WriteFrame(frame Frame) error {
+	if a.closed {
+		return errors.New("write to closed AnimationWriter")
+	}
+
+	width, height := frame.Canvas.Width(), frame.Canvas.Height()
+	if frame.XOffset%2 != 0 || frame.YOffset%2 != 0 {
+		return fmt.Errorf("frame %d offset %d,%d is odd", a.count+1, frame.XOffset, frame.YOffset)
+	}
+	if uint64(frame.XOffset)+uint64(width) > uint64(a.options.Width) ||
+		uint64(frame.YOffset)+uint64(height) > uint64(a.options.Height) {
+		return fmt.Errorf("frame %d of %dx%d at %d,%d exceeds the %dx%d canvas",
+			a.count+1, width, height, frame.XOffset, frame.YOffset,
+			a.options.Width, a.options.Height)
+	}
+	duration := frame.Duration.Round(time.Millisecond).Milliseconds()
+	if duration < 0 || duration > maxUint24 {
+		return fmt.Errorf("frame %d duration %s is out of range", a.count+1, frame.Duration)
+	}
+
+	encoder := a.options.Encoder
+	if encoder == nil {
+		encoder = EncodeVP8LChunk
+	}
+	chunks, alpha, err := encoder(frame.Canvas)
+	if err != nil {
+		return err
+	}
+	a.alpha = a.alpha || alpha
+
+	var anmf bytes.Buffer
+	putUint24(&anmf, frame.XOffset/2)
+	putUint24(&anmf, frame.YOffset/2)
+	putUint24(&anmf, width-1)
+	putUint24(&anmf, height-1)
+	putUint24(&anmf, uint32(duration))
+	flags := byte(0)
+	if !frame.Blend {
+		flags |= flagNoBlend
+	}
+	if frame.Dispose {
+		flags |= flagDispose
+	}
+	anmf.WriteByte(flags)
+	anmf.Write(chunks)
+
+	writeChunk(&a.frames, "ANMF", anmf.Bytes())
+	a.count++
+	return nil
+}
+
+// Write the animation. Doesn't close the underlying writer.
+func (a *AnimationWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.count == 0 {
+		return errors.New("an animation needs at least one frame")
+	}
+
+	o := a.options
+	if o.Width == 0 || o.Height == 0 || o.Width > maxUint24+1 || o.Height > maxUint24+1 {
+		return fmt.Errorf("canvas of %dx%d is out of range", o.Width, o.Height)
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	var vp8x bytes.Buffer
+	flags := byte(flagAnimation)
+	if a.alpha {
+		flags |= flagAlpha
+	}
+	if o.ICC != nil {
+		flags |= flagIcc
+	}
+	if o.EXIF != nil {
+		flags |= flagExif
+	}
+	if o.XMP != nil {
+		flags |= flagXmp
+	}
+	vp8x.Write([]byte{flags, 0, 0, 0})
+	putUint24(&vp8x, o.Width-1)
+	putUint24(&vp8x, o.Height-1)
+	writeChunk(&body, "VP8X", vp8x.Bytes())
+
+	if o.ICC != nil {
+		writeChunk(&body, "ICCP", o.ICC)
+	}
+
+	r, g, b, alpha := o.BackgroundColor.Rgba()
+	anim := []byte{byte(b >> 8), byte(g >> 8), byte(r >> 8), byte(alpha >> 8), 0, 0}
+	binary.LittleEndian.PutUint16(anim[4:], o.LoopCount)
+	writeChunk(&body, "ANIM", anim)
+
+	body.Write(a.frames.Bytes())
+
+	if o.EXIF != nil {
+		writeChunk(&body, "EXIF", o.EXIF)
+	}
+	if o.XMP != nil {
+		writeChunk(&body, "XMP ", o.XMP)
+	}
+
+	if uint64(body.Len()) > math.MaxUint32 {
+		return fmt.Errorf("animation of %d bytes is too large for RIFF", body.Len())
+	}
+	var header [8]byte
+	copy(header[:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(body.Len()))
+	if _, err := a.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := a.w.Write(body.Bytes())
+	return err
+}
+
+// Write an animation of frames to w.
+func EncodeAnimation(w io.Writer, options AnimationOptions, frames []Frame) error {
+	a := MakeAnimationWriter(w, options)
+	for _, frame := range frames {
+		if err := a.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+
+	return a.Close()
+}
+
+// Write a RIFF chunk, padding odd sizes.
+func writeChunk(w *bytes.Buffer, fourCC string, payload []byte) {
+	var header [8]byte
+	copy(header[:4], fourCC)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+	w.Write(header[:])
+	w.Write(payload)
+	if len(payload)%2 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+func putUint24(w *bytes.Buffer, v uint32) {
+	w.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+}
@@ -0,0 +1,264 @@
+package encode_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+	"webpfex/webpfex"
+
+	"golang.org/x/image/webp"
+)
+
+// Canvas with a pattern exercising literals, backward references and alpha.
+func makePatternCanvas(width, height uint32, seed uint16) canvas.Canvas {
+	cv := canvas.MakeCanvas(width, height)
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			v := uint16(x*37+y*11) + seed
+			a := uint16(0xFF)
+			if x < width/2 {
+				a = uint16(y * 255 / height)
+			}
+			if y%4 == 0 {
+				v = seed // Runs of a single color.
+			}
+			cv.WriteAt(x, y, canvas.MakeColorRgba(
+				(v&0xFF)*0x101, (v>>3&0xFF)*0x101, (v*7&0xFF)*0x101, a*0x101))
+		}
+	}
+
+	return cv
+}
+
+func makeTestFrames() []encode.Frame {
+	return []encode.Frame{
+		{Canvas: makePatternCanvas(40, 30, 0), Duration: 100 * time.Millisecond},
+		{
+			Canvas:   makePatternCanvas(17, 9, 100),
+			XOffset:  4,
+			YOffset:  20,
+			Duration: 40 * time.Millisecond,
+			Blend:    true,
+			Dispose:  true,
+		},
+		{Canvas: makePatternCanvas(1, 1, 7), XOffset: 38, YOffset: 28, Blend: true},
+	}
+}
+
+// Decode the VP8L payload of an ANMF chunk as a standalone WEBP.
+func decodeAnmf(t *testing.T, anmf webpfex.Chunk) canvas.Canvas {
+	t.Helper()
+
+	vp8l := anmf.Payload[16:]
+	size := binary.LittleEndian.Uint32(vp8l[4:8])
+	var data bytes.Buffer
+	data.WriteString("RIFF")
+	binary.Write(&data, binary.LittleEndian, uint32(4+len(vp8l)))
+	data.WriteString("WEBP")
+	data.Write(vp8l[:8+size])
+	if size%2 == 1 {
+		data.WriteByte(0)
+	}
+
+	img, err := webp.Decode(&data)
+	if err != nil {
+		t.Fatalf("Decoding frame: %v", err)
+	}
+	return webpfex.ImageToCanvas(img)
+}
+
+func expectSameCanvas(t *testing.T, expected canvas.Canvas, got canvas.Canvas) {
+	t.Helper()
+
+	if expected.Width() != got.Width() || expected.Height() != got.Height() {
+		t.Fatalf("Expecting %dx%d, got %dx%d",
+			expected.Width(), expected.Height(), got.Width(), got.Height())
+	}
+	for y := uint32(0); y < expected.Height(); y++ {
+		for x := uint32(0); x < expected.Width(); x++ {
+			e, g := expected.At(x, y), got.At(x, y)
+			if e.A() == 0 && g.A() == 0 {
+				continue // x/image/webp drops the color of transparent pixels.
+			}
+			if e != g {
+				t.Fatalf("At %d,%d expecting %X, got %X", x, y, e.Value(), g.Value())
+			}
+		}
+	}
+}
+
+func TestEncodeAnimation(t *testing.T) {
+	frames := makeTestFrames()
+	var out bytes.Buffer
+	err := encode.EncodeAnimation(&out, encode.AnimationOptions{
+		Width:           40,
+		Height:          30,
+		BackgroundColor: canvas.MakeColorRgba(0x1111, 0x2222, 0x3333, 0xFFFF),
+		LoopCount:       3,
+		EXIF:            []byte("odd"),
+	}, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := webpfex.ParseWebpChunks(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fourCCs []string
+	for _, c := range chunks {
+		fourCCs = append(fourCCs, c.FourCC)
+	}
+	expected := []string{"VP8X", "ANIM", "ANMF", "ANMF", "ANMF", "EXIF"}
+	if len(fourCCs) != len(expected) {
+		t.Fatalf("Expecting chunks %v, got %v", expected, fourCCs)
+	}
+	for i := range expected {
+		if fourCCs[i] != expected[i] {
+			t.Fatalf("Expecting chunks %v, got %v", expected, fourCCs)
+		}
+	}
+
+	vp8x := chunks[0].Payload
+	if vp8x[0] != 0x1A { // Animation, alpha and EXIF.
+		t.Errorf("Expecting VP8X flags 1A, got %X", vp8x[0])
+	}
+	anim := chunks[1].Payload
+	if !bytes.Equal(anim, []byte{0x33, 0x22, 0x11, 0xFF, 3, 0}) {
+		t.Errorf("Expecting BGRA background and loop count, got %X", anim)
+	}
+
+	for i, frame := range frames {
+		header := chunks[2+i].Payload
+		uint24 := func(b []byte) uint32 { return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 }
+		if x, y := 2*uint24(header[0:]), 2*uint24(header[3:]); x != frame.XOffset || y != frame.YOffset {
+			t.Errorf("Frame %d: expecting offset %d,%d got %d,%d",
+				i+1, frame.XOffset, frame.YOffset, x, y)
+		}
+		if d := uint24(header[12:]); int64(d) != frame.Duration.Milliseconds() {
+			t.Errorf("Frame %d: expecting duration %s, got %dms", i+1, frame.Duration, d)
+		}
+		if blend := header[15]&0x02 == 0; blend != frame.Blend {
+			t.Errorf("Frame %d: expecting blend %t", i+1, frame.Blend)
+		}
+		if dispose := header[15]&0x01 != 0; dispose != frame.Dispose {
+			t.Errorf("Frame %d: expecting dispose %t", i+1, frame.Dispose)
+		}
+
+		expectSameCanvas(t, frame.Canvas, decodeAnmf(t, chunks[2+i]))
+	}
+}
+
+func TestEncodeVP8LLarge(t *testing.T) {
+	// Enough distinct pixels to need long prefix codes and far references.
+	cv := makePatternCanvas(300, 200, 3)
+	random := rand.New(rand.NewSource(1))
+	for x := uint32(0); x < 300; x++ {
+		v := uint16(random.Intn(0x100))
+		cv.WriteAt(x, 199, canvas.MakeColorRgba(v*0x101, (0xFF-v)*0x101, v/3*0x101, 0xFFFF))
+	}
+	data, err := encode.EncodeVP8L(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anmf := webpfex.Chunk{Payload: append(make([]byte, 16), append([]byte("VP8L\x00\x00\x00\x00"), data...)...)}
+	binary.LittleEndian.PutUint32(anmf.Payload[20:], uint32(len(data)))
+	expectSameCanvas(t, cv, decodeAnmf(t, anmf))
+}
+
+func TestAnimationWriterErrors(t *testing.T) {
+	options := encode.AnimationOptions{Width: 8, Height: 8}
+	cases := map[string]encode.Frame{
+		"odd offset":     {Canvas: canvas.MakeCanvas(2, 2), XOffset: 1},
+		"out of canvas":  {Canvas: canvas.MakeCanvas(8, 8), YOffset: 2},
+		"empty frame":    {Canvas: canvas.MakeCanvas(0, 0)},
+		"long duration":  {Canvas: canvas.MakeCanvas(2, 2), Duration: 5 * time.Hour},
+		"negative delay": {Canvas: canvas.MakeCanvas(2, 2), Duration: -time.Second},
+	}
+	for name, frame := range cases {
+		w := encode.MakeAnimationWriter(&bytes.Buffer{}, options)
+		if err := w.WriteFrame(frame); err == nil {
+			t.Errorf("%s: expecting an error", name)
+		}
+	}
+
+	if err := encode.EncodeAnimation(&bytes.Buffer{}, options, nil); err == nil {
+		t.Error("Expecting an error without frames")
+	}
+}
+
+func TestAnimationWriterEncoder(t *testing.T) {
+	encoded := 0
+	alpha := false
+	options := encode.AnimationOptions{Width: 40, Height: 30, Encoder: func(cv canvas.Canvas) ([]byte, bool, error) {
+		encoded++
+		chunks, _, err := encode.EncodeVP8LChunk(cv)
+		return chunks, alpha, err
+	}}
+
+	var out bytes.Buffer
+	if err := encode.EncodeAnimation(&out, options, makeTestFrames()); err != nil {
+		t.Fatal(err)
+	}
+	if encoded != 3 {
+		t.Errorf("Expecting every frame encoded by the encoder, got %d", encoded)
+	}
+	// Whether the animation has alpha follows the encoder.
+	if chunks, err := webpfex.ParseWebpChunks(out.Bytes()); err != nil || chunks[0].Payload[0]&0x10 != 0 {
+		t.Errorf("Expecting no alpha flag: %v", err)
+	}
+
+	options.Encoder = func(cv canvas.Canvas) ([]byte, bool, error) {
+		return nil, false, errors.New("no")
+	}
+	if err := encode.EncodeAnimation(&bytes.Buffer{}, options, makeTestFrames()); err == nil {
+		t.Error("Expecting the encoder's error")
+	}
+}
+
+// Read the encoded animation back with webpmux, as extract and convert do.
+func TestEncodeAnimationWebpmux(t *testing.T) {
+	if _, err := (webpfex.Tools{}).Lookup(webpfex.ToolWebpmux); err != nil {
+		t.Skip(err)
+	}
+
+	frames := makeTestFrames()
+	path := filepath.Join(t.TempDir(), "out.webp")
+	var out bytes.Buffer
+	if err := encode.EncodeAnimation(&out, encode.AnimationOptions{Width: 40, Height: 30}, frames); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := webpfex.ExtractAWebpInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FrameCount != uint32(len(frames)) {
+		t.Fatalf("Expecting %d frames, got %d", len(frames), info.FrameCount)
+	}
+	for i, frame := range frames {
+		frameInfo := info.FrameInfos[i]
+		if frameInfo.XOffset != frame.XOffset || frameInfo.YOffset != frame.YOffset ||
+			frameInfo.Duration != frame.Duration || frameInfo.Blend != frame.Blend ||
+			frameInfo.Dispose != frame.Dispose {
+			t.Errorf("Frame %d: expecting %+v, got %+v", i+1, frame, frameInfo)
+		}
+
+		cv, err := webpfex.LoadAWebpFrame(path, frameInfo.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectSameCanvas(t, frame.Canvas, cv)
+	}
+}
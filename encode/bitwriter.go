@@ -0,0 +1,36 @@
+package encode
+
+// Writes bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// Write the n least significant bits of v, n being at most 32.
+func (w *bitWriter) WriteBits(v uint32, n uint) {
+	w.acc |= uint64(v&(1<<n-1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// Write a Huffman code of length n, which is read most significant bit first.
+func (w *bitWriter) WriteCode(code uint16, n uint8) {
+	reversed := uint32(0)
+	for i := uint8(0); i < n; i++ {
+		reversed = reversed<<1 | uint32(code>>i&1)
+	}
+	w.WriteBits(reversed, uint(n))
+}
+
+// Pad to a whole byte and return the written bytes.
+func (w *bitWriter) Bytes() []byte {
+	if w.nbits > 0 {
+		w.WriteBits(0, 8-w.nbits)
+	}
+	return w.buf
+}
@@ -0,0 +1,99 @@
+package encode
+
+import (
+	"math"
+	"webpfex/canvas"
+)
+
+// CoalescingWriter merges consecutive frames looking alike into one lasting as
+// long as they all did, and writes them to another FrameWriter. The result has
+// a variable frame rate.
+type CoalescingWriter struct {
+	w         FrameWriter
+	threshold uint8
+	pending   *Frame
+	merged    int
+}
+
+// Frames are alike when no channel of any pixel differs by more than
+// threshold, out of 255, 0 only merging identical frames. See SimilarCanvases.
+func MakeCoalescingWriter(w FrameWriter, threshold uint8) *CoalescingWriter {
+	return &CoalescingWriter{w: w, threshold: threshold}
+}
+
+// Write frame, or add its duration to the previous one. The canvas may be
+// reused once this returns.
+func (c *CoalescingWriter) WriteFrame(frame Frame) error {
+	if c.pending != nil && c.pending.XOffset == frame.XOffset &&
+		c.pending.YOffset == frame.YOffset && c.pending.Blend == frame.Blend &&
+		SimilarCanvases(&c.pending.Canvas, &frame.Canvas, c.threshold) {
+		c.pending.Duration += frame.Duration
+		c.merged++
+		return nil
+	}
+
+	if err := c.flush(); err != nil {
+		return err
+	}
+	frame.Canvas = frame.Canvas.Clone()
+	c.pending = &frame
+	return nil
+}
+
+// Write the last frame and close the underlying writer.
+func (c *CoalescingWriter) Close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	return c.w.Close()
+}
+
+// Number of frames merged into a previous one.
+func (c *CoalescingWriter) Merged() int {
+	return c.merged
+}
+
+func (c *CoalescingWriter) flush() error {
+	if c.pending == nil {
+		return nil
+	}
+
+	pending := c.pending
+	c.pending = nil
+	return c.w.WriteFrame(*pending)
+}
+
+// Whether no channel of any pixel of a and b differs by more than threshold,
+// out of 255. Colors are compared premultiplied by alpha, so that differences
+// hidden by transparency don't count, and with a threshold of 0 only identical
+// looking canvases are similar.
+func SimilarCanvases(a, b *canvas.Canvas, threshold uint8) bool {
+	if a.Width() != b.Width() || a.Height() != b.Height() {
+		return false
+	}
+
+	for y := uint32(0); y < a.Height(); y++ {
+		for x := uint32(0); x < a.Width(); x++ {
+			if colorDistance(a.At(x, y), b.At(x, y)) > float64(threshold) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Largest difference between the channels of a and b premultiplied by alpha,
+// out of 255.
+func colorDistance(a, b canvas.Color) float64 {
+	aA := float64(a.A()>>8) / 0xFF
+	bA := float64(b.A()>>8) / 0xFF
+	channel := func(a, b uint16) float64 {
+		return math.Abs(float64(a>>8)*aA - float64(b>>8)*bA)
+	}
+
+	return math.Max(
+		math.Max(channel(a.R(), b.R()), channel(a.G(), b.G())),
+		math.Max(channel(a.B(), b.B()), math.Abs(aA-bA)*0xFF),
+	)
+}
@@ -0,0 +1,80 @@
+package encode_test
+
+import (
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+func TestCoalescingWriter(t *testing.T) {
+	gray := func(v uint16) canvas.Canvas {
+		cv := canvas.MakeCanvas(2, 2)
+		fillRect(&cv, 0, 0, 2, 2, canvas.MakeColorRgba(v*0x101, v*0x101, v*0x101, 0xFFFF))
+		return cv
+	}
+	canvases := []canvas.Canvas{gray(10), gray(10), gray(12), gray(20), gray(20), gray(10)}
+
+	cases := []struct {
+		threshold uint8
+		durations []time.Duration
+	}{
+		{0, []time.Duration{2, 1, 2, 1}},
+		{2, []time.Duration{3, 2, 1}},
+		{10, []time.Duration{6}},
+	}
+	for _, c := range cases {
+		var recorder frameRecorder
+		w := encode.MakeCoalescingWriter(&recorder, c.threshold)
+		for _, cv := range canvases {
+			if err := w.WriteFrame(encode.Frame{Canvas: cv, Duration: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var durations []time.Duration
+		for _, f := range recorder.frames {
+			durations = append(durations, f.Duration)
+		}
+		if len(durations) != len(c.durations) {
+			t.Errorf("Threshold %d: expecting durations %v, got %v", c.threshold, c.durations, durations)
+			continue
+		}
+		for i := range durations {
+			if durations[i] != c.durations[i] {
+				t.Errorf("Threshold %d: expecting durations %v, got %v", c.threshold, c.durations, durations)
+				break
+			}
+		}
+		if merged := w.Merged(); merged != len(canvases)-len(c.durations) {
+			t.Errorf("Threshold %d: expecting %d merged, got %d",
+				c.threshold, len(canvases)-len(c.durations), merged)
+		}
+	}
+}
+
+func TestSimilarCanvases(t *testing.T) {
+	a := canvas.MakeCanvas(1, 1)
+	b := canvas.MakeCanvas(1, 1)
+	a.WriteAt(0, 0, canvas.MakeColorRgba(0xFFFF, 0, 0, 0))
+	b.WriteAt(0, 0, canvas.MakeColorRgba(0, 0xFFFF, 0, 0))
+	if !encode.SimilarCanvases(&a, &b, 0) {
+		t.Error("Expecting the color of transparent pixels not to matter")
+	}
+
+	b.WriteAt(0, 0, canvas.MakeColorRgba(0, 0xFFFF, 0, 0x101))
+	if encode.SimilarCanvases(&a, &b, 0) {
+		t.Error("Expecting a barely visible difference to count without threshold")
+	}
+	if !encode.SimilarCanvases(&a, &b, 1) {
+		t.Error("Expecting a barely visible difference within threshold")
+	}
+
+	c := canvas.MakeCanvas(1, 2)
+	if encode.SimilarCanvases(&a, &c, 255) {
+		t.Error("Expecting differently sized canvases to differ")
+	}
+}
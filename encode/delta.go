@@ -0,0 +1,190 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"webpfex/canvas"
+)
+
+// FrameWriter is implemented by the animated encoders.
+type FrameWriter interface {
+	WriteFrame(frame Frame) error
+	Close() error
+}
+
+// DeltaAreaStats compares the pixel area delta frames store with storing whole
+// frames. It doesn't measure encoded bytes, which depend on the encoder and on
+// what the pixels hold.
+type DeltaAreaStats struct {
+	Frames       int
+	WholePixels  uint64
+	StoredPixels uint64
+}
+
+// Fraction of the whole frames' pixels not stored.
+func (s DeltaAreaStats) AreaSavings() float64 {
+	if s.WholePixels == 0 {
+		return 0
+	}
+	return 1 - float64(s.StoredPixels)/float64(s.WholePixels)
+}
+
+// DeltaWriter turns whole composited canvases into frames storing only what
+// changed since the previous one, with the blend and dispose settings needing
+// the fewest pixels, and writes them to another FrameWriter. This is the
+// inverse of compositing.
+type DeltaWriter struct {
+	w FrameWriter
+	// What is displayed after the last frame, before it's disposed of.
+	displayed canvas.Canvas
+	// The last frame, held until whether disposing of it helps the next one is
+	// known.
+	pending *Frame
+	stats   DeltaAreaStats
+}
+
+func MakeDeltaWriter(w FrameWriter) *DeltaWriter {
+	return &DeltaWriter{w: w}
+}
+
+// Write the canvas and duration of frame, whose other fields are ignored. The
+// canvas may be reused once this returns.
+func (d *DeltaWriter) WriteFrame(frame Frame) error {
+	cv := frame.Canvas
+	var delta deltaFrame
+	if d.pending == nil {
+		if d.stats.Frames > 0 {
+			return errors.New("write to closed DeltaWriter")
+		}
+		// Drawn on a cleared canvas without blending.
+		transparent := canvas.MakeCanvas(cv.Width(), cv.Height())
+		delta = makeDeltaFrame(&transparent, &cv, image.Rectangle{}, false)
+	} else {
+		if cv.Width() != d.displayed.Width() || cv.Height() != d.displayed.Height() {
+			return fmt.Errorf("frame %d is %dx%d, previous ones are %dx%d", d.stats.Frames+1,
+				cv.Width(), cv.Height(), d.displayed.Width(), d.displayed.Height())
+		}
+
+		kept := makeDeltaFrame(&d.displayed, &cv, image.Rectangle{}, true)
+
+		disposedRect := frameRectOf(*d.pending)
+		disposed := d.displayed.Clone()
+		clearRect(&disposed, disposedRect)
+		cleared := makeDeltaFrame(&disposed, &cv, disposedRect, true)
+
+		delta = kept
+		if area(cleared.rect) < area(kept.rect) {
+			delta = cleared
+			d.pending.Dispose = true
+		}
+		if err := d.w.WriteFrame(*d.pending); err != nil {
+			return err
+		}
+	}
+
+	d.displayed = cv.Clone()
+	d.pending = &Frame{
+		Canvas:   delta.canvas,
+		XOffset:  uint32(delta.rect.Min.X),
+		YOffset:  uint32(delta.rect.Min.Y),
+		Duration: frame.Duration,
+		Blend:    delta.blend,
+	}
+
+	d.stats.Frames++
+	d.stats.WholePixels += uint64(cv.Width()) * uint64(cv.Height())
+	d.stats.StoredPixels += uint64(area(delta.rect))
+	return nil
+}
+
+// Write the last frame and close the underlying writer.
+func (d *DeltaWriter) Close() error {
+	if d.pending != nil {
+		pending := d.pending
+		d.pending = nil
+		if err := d.w.WriteFrame(*pending); err != nil {
+			return err
+		}
+	}
+
+	return d.w.Close()
+}
+
+func (d *DeltaWriter) AreaStats() DeltaAreaStats {
+	return d.stats
+}
+
+// A frame turning one canvas into another.
+type deltaFrame struct {
+	rect   image.Rectangle
+	canvas canvas.Canvas
+	blend  bool
+}
+
+// The smallest frame turning from into to. Within copied, decoders copy rather
+// than blend pixels, as they do in the area the previous frame disposed.
+func makeDeltaFrame(from, to *canvas.Canvas, copied image.Rectangle, blend bool) deltaFrame {
+	var rect image.Rectangle
+	for y := uint32(0); y < to.Height(); y++ {
+		for x := uint32(0); x < to.Width(); x++ {
+			if sameColor(from.At(x, y), to.At(x, y)) {
+				continue
+			}
+
+			p := image.Point{int(x), int(y)}
+			rect = rect.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+			// Blending can only leave opaque pixels as they are.
+			blend = blend && (p.In(copied) || to.At(x, y).A()>>8 == 0xFF)
+		}
+	}
+
+	// Frames can't be empty, and their offsets are even.
+	if rect.Empty() {
+		rect = image.Rect(0, 0, 1, 1)
+	}
+	rect.Min.X -= rect.Min.X % 2
+	rect.Min.Y -= rect.Min.Y % 2
+
+	cv := canvas.MakeCanvas(uint32(rect.Dx()), uint32(rect.Dy()))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			color := to.At(uint32(x), uint32(y))
+			// Transparent pixels leave the canvas as is when blending.
+			if blend && sameColor(from.At(uint32(x), uint32(y)), color) {
+				continue
+			}
+			cv.WriteAt(uint32(x-rect.Min.X), uint32(y-rect.Min.Y), color)
+		}
+	}
+
+	return deltaFrame{rect, cv, blend}
+}
+
+// Whether a and b encode the same, the color of transparent pixels not
+// mattering.
+func sameColor(a, b canvas.Color) bool {
+	if a.A()>>8 == 0 && b.A()>>8 == 0 {
+		return true
+	}
+	return a.R()>>8 == b.R()>>8 && a.G()>>8 == b.G()>>8 &&
+		a.B()>>8 == b.B()>>8 && a.A()>>8 == b.A()>>8
+}
+
+func frameRectOf(frame Frame) image.Rectangle {
+	min := image.Point{int(frame.XOffset), int(frame.YOffset)}
+	size := image.Point{int(frame.Canvas.Width()), int(frame.Canvas.Height())}
+	return image.Rectangle{min, min.Add(size)}
+}
+
+func clearRect(cv *canvas.Canvas, rect image.Rectangle) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			cv.WriteAt(uint32(x), uint32(y), canvas.MakeColor(0))
+		}
+	}
+}
+
+func area(rect image.Rectangle) int {
+	return rect.Dx() * rect.Dy()
+}
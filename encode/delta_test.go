@@ -0,0 +1,142 @@
+package encode_test
+
+import (
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+	"webpfex/webpfex"
+)
+
+// Collects written frames.
+type frameRecorder struct {
+	frames []encode.Frame
+	closed bool
+}
+
+func (r *frameRecorder) WriteFrame(frame encode.Frame) error {
+	frame.Canvas = frame.Canvas.Clone()
+	r.frames = append(r.frames, frame)
+	return nil
+}
+
+func (r *frameRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func fillRect(cv *canvas.Canvas, x0, y0, x1, y1 uint32, color canvas.Color) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			cv.WriteAt(x, y, color)
+		}
+	}
+}
+
+// Composite frames the way decoders do.
+func replayFrames(width, height uint32, frames []encode.Frame) []canvas.Canvas {
+	compositor := webpfex.MakeCompositor(width, height, webpfex.BlendGamma)
+	var canvases []canvas.Canvas
+	for i, frame := range frames {
+		alpha := false
+		for y := uint32(0); y < frame.Canvas.Height(); y++ {
+			for x := uint32(0); x < frame.Canvas.Width(); x++ {
+				alpha = alpha || frame.Canvas.At(x, y).A()>>8 != 0xFF
+			}
+		}
+
+		frameInfo := webpfex.MakeAWebpFrameInfo(uint32(i+1),
+			frame.Canvas.Width(), frame.Canvas.Height(), alpha,
+			frame.XOffset, frame.YOffset, frame.Duration, frame.Blend)
+		frameInfo.Dispose = frame.Dispose
+		compositor.Draw(frameInfo, &frame.Canvas)
+		canvases = append(canvases, compositor.Canvas.Clone())
+	}
+
+	return canvases
+}
+
+func TestDeltaWriter(t *testing.T) {
+	const width, height = 24, 16
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+	blue := canvas.MakeColorRgba(0, 0, 0xFFFF, 0xFFFF)
+	halfGreen := canvas.MakeColorRgba(0, 0xFFFF, 0, 0x8080)
+
+	var canvases []canvas.Canvas
+	add := func(draw func(cv *canvas.Canvas)) {
+		cv := canvas.MakeCanvas(width, height)
+		draw(&cv)
+		canvases = append(canvases, cv)
+	}
+	// A sprite on a transparent background, moving then vanishing.
+	add(func(cv *canvas.Canvas) { fillRect(cv, 3, 3, 7, 7, red) })
+	add(func(cv *canvas.Canvas) { fillRect(cv, 5, 3, 9, 7, red) })
+	add(func(cv *canvas.Canvas) {})
+	add(func(cv *canvas.Canvas) {})
+	// An opaque background with opaque, then translucent changes.
+	add(func(cv *canvas.Canvas) { fillRect(cv, 0, 0, width, height, blue) })
+	add(func(cv *canvas.Canvas) {
+		fillRect(cv, 0, 0, width, height, blue)
+		fillRect(cv, 11, 9, 14, 12, red)
+	})
+	add(func(cv *canvas.Canvas) {
+		fillRect(cv, 0, 0, width, height, blue)
+		fillRect(cv, 11, 9, 14, 12, halfGreen)
+	})
+
+	var recorder frameRecorder
+	delta := encode.MakeDeltaWriter(&recorder)
+	for i, cv := range canvases {
+		frame := encode.Frame{Canvas: cv, Duration: time.Duration(i+1) * time.Millisecond}
+		if err := delta.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := delta.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !recorder.closed {
+		t.Error("Expecting the underlying writer to be closed")
+	}
+
+	frames := recorder.frames
+	if len(frames) != len(canvases) {
+		t.Fatalf("Expecting %d frames, got %d", len(canvases), len(frames))
+	}
+	for i, cv := range replayFrames(width, height, frames) {
+		if frames[i].Duration != time.Duration(i+1)*time.Millisecond {
+			t.Errorf("Frame %d: expecting its duration kept, got %s", i+1, frames[i].Duration)
+		}
+		expectSameCanvas(t, canvases[i], cv)
+	}
+
+	// The vanishing sprite is best disposed of rather than overwritten.
+	if !frames[1].Dispose || frames[2].Canvas.Width() != 1 {
+		t.Errorf("Expecting frame 2 to be disposed of, got %+v then %+v", frames[1], frames[2])
+	}
+	// The opaque change blends, the translucent one can't.
+	if f := frames[5]; !f.Blend || f.XOffset != 10 || f.YOffset != 8 || f.Canvas.Width() != 4 {
+		t.Errorf("Expecting frame 6 to blend a small area, got %+v", f)
+	}
+	if frames[6].Blend {
+		t.Error("Expecting frame 7 not to blend")
+	}
+
+	stats := delta.AreaStats()
+	if stats.Frames != len(canvases) || stats.WholePixels != width*height*uint64(len(canvases)) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.AreaSavings() < 0.5 {
+		t.Errorf("Expecting most pixels saved, got %f", stats.AreaSavings())
+	}
+}
+
+func TestDeltaWriterSizeMismatch(t *testing.T) {
+	delta := encode.MakeDeltaWriter(&frameRecorder{})
+	if err := delta.WriteFrame(encode.Frame{Canvas: canvas.MakeCanvas(4, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := delta.WriteFrame(encode.Frame{Canvas: canvas.MakeCanvas(4, 2)}); err == nil {
+		t.Error("Expecting an error for a differently sized frame")
+	}
+}
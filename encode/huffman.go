@@ -0,0 +1,263 @@
+package encode
+
+import "sort"
+
+// Canonical Huffman code of an alphabet, as VP8L builds them from lengths.
+type huffmanCode struct {
+	lengths []uint8
+	codes   []uint16
+	// At most one symbol is used, which takes no bits.
+	single bool
+}
+
+// Build a code for symbols occurring counts times, no longer than maxLength.
+func makeHuffmanCode(counts []uint32, maxLength uint8) huffmanCode {
+	lengths := huffmanLengths(counts, maxLength)
+
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+
+	return huffmanCode{lengths, canonicalCodes(lengths), used <= 1}
+}
+
+// Write symbol with the code.
+func (h *huffmanCode) WriteSymbol(w *bitWriter, symbol int) {
+	if !h.single {
+		w.WriteCode(h.codes[symbol], h.lengths[symbol])
+	}
+}
+
+// Length of the code of each symbol, 0 for unused ones. A lone used symbol gets
+// length 1 as the decoder needs one non-zero length.
+func huffmanLengths(counts []uint32, maxLength uint8) []uint8 {
+	lengths := make([]uint8, len(counts))
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	// Flatten the distribution until the tree is shallow enough.
+	for countMin := uint32(1); ; countMin *= 2 {
+		if buildHuffmanLengths(counts, used, countMin, maxLength, lengths) {
+			return lengths
+		}
+	}
+}
+
+// Fill lengths from the Huffman tree of the used symbols, counting at least
+// countMin each. Reports whether no length exceeds maxLength.
+func buildHuffmanLengths(
+	counts []uint32,
+	used []int,
+	countMin uint32,
+	maxLength uint8,
+	lengths []uint8,
+) bool {
+	n := len(used)
+	weights := make([]uint64, 2*n-1)
+	parents := make([]int, 2*n-1)
+
+	leaves := append([]int(nil), used...)
+	weight := func(symbol int) uint64 {
+		if counts[symbol] < countMin {
+			return uint64(countMin)
+		}
+		return uint64(counts[symbol])
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return weight(leaves[i]) < weight(leaves[j])
+	})
+	for i, symbol := range leaves {
+		weights[i] = weight(symbol)
+	}
+
+	// Internal nodes are created in increasing weight order, so the two
+	// lightest nodes are at the front of either queue.
+	nextLeaf, nextInternal := 0, n
+	lightest := func(end int) int {
+		if nextLeaf < n && (nextInternal >= end || weights[nextLeaf] <= weights[nextInternal]) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextInternal++
+		return nextInternal - 1
+	}
+	for node := n; node < 2*n-1; node++ {
+		a := lightest(node)
+		b := lightest(node)
+		weights[node] = weights[a] + weights[b]
+		parents[a] = node
+		parents[b] = node
+	}
+
+	depths := make([]uint8, 2*n-1)
+	for node := 2*n - 3; node >= 0; node-- {
+		depths[node] = depths[parents[node]] + 1
+		if depths[node] > maxLength {
+			return false
+		}
+	}
+	for i, symbol := range leaves {
+		lengths[symbol] = depths[i]
+	}
+
+	return true
+}
+
+// Canonical codes of lengths: shorter codes first, then by symbol.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var histogram [16]uint16
+	for _, l := range lengths {
+		histogram[l]++
+	}
+	histogram[0] = 0
+
+	var next [16]uint16
+	code := uint16(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + histogram[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for symbol, l := range lengths {
+		if l > 0 {
+			codes[symbol] = next[l]
+			next[l]++
+		}
+	}
+
+	return codes
+}
+
+// Order in which the lengths of the code length code are stored.
+var codeLengthCodeOrder = [19]int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// A code length, or a repeat code and its extra bits.
+type codeLengthToken struct {
+	symbol    uint8
+	extra     uint32
+	extraBits uint
+}
+
+// Write the lengths of h, as a simple code when possible.
+func writeHuffmanCode(w *bitWriter, h huffmanCode) {
+	var symbols []int
+	for symbol, l := range h.lengths {
+		if l > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	simple := len(symbols) <= 2
+	for _, symbol := range symbols {
+		simple = simple && symbol < 256
+	}
+	if simple {
+		writeSimpleHuffmanCode(w, symbols)
+		return
+	}
+
+	tokens := codeLengthTokens(h.lengths)
+	counts := make([]uint32, len(codeLengthCodeOrder))
+	for _, t := range tokens {
+		counts[t.symbol]++
+	}
+	lengthCode := makeHuffmanCode(counts, 7)
+
+	stored := len(codeLengthCodeOrder)
+	for stored > 4 && lengthCode.lengths[codeLengthCodeOrder[stored-1]] == 0 {
+		stored--
+	}
+
+	w.WriteBits(0, 1) // Normal code.
+	w.WriteBits(uint32(stored-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:stored] {
+		w.WriteBits(uint32(lengthCode.lengths[symbol]), 3)
+	}
+	w.WriteBits(0, 1) // Lengths of the whole alphabet follow.
+	for _, t := range tokens {
+		lengthCode.WriteSymbol(w, int(t.symbol))
+		w.WriteBits(t.extra, t.extraBits)
+	}
+}
+
+// Write a code of up to two symbols below 256, used symbols being ascending.
+func writeSimpleHuffmanCode(w *bitWriter, symbols []int) {
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+
+	w.WriteBits(1, 1) // Simple code.
+	w.WriteBits(uint32(len(symbols)-1), 1)
+	if symbols[0] < 2 {
+		w.WriteBits(0, 1)
+		w.WriteBits(uint32(symbols[0]), 1)
+	} else {
+		w.WriteBits(1, 1)
+		w.WriteBits(uint32(symbols[0]), 8)
+	}
+	if len(symbols) == 2 {
+		w.WriteBits(uint32(symbols[1]), 8)
+	}
+}
+
+// Run length encode lengths with the repeat codes: 16 repeats the previous
+// length 3 to 6 times, 17 and 18 repeat zero 3 to 10 and 11 to 138 times.
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	var tokens []codeLengthToken
+	for i := 0; i < len(lengths); {
+		value := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, codeLengthToken{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, codeLengthToken{17, uint32(run - 3), 3})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, codeLengthToken{value, 0, 0})
+			run--
+			for run >= 3 {
+				n := run
+				if n > 6 {
+					n = 6
+				}
+				tokens = append(tokens, codeLengthToken{16, uint32(n - 3), 2})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, codeLengthToken{value, 0, 0})
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,47 @@
+package encode
+
+import "testing"
+
+func TestHuffmanLengthsLimit(t *testing.T) {
+	// Fibonacci counts make the deepest unrestricted Huffman trees.
+	counts := make([]uint32, 30)
+	a, b := uint32(1), uint32(1)
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+
+	lengths := huffmanLengths(counts, 15)
+	kraft := 0.0
+	for symbol, l := range lengths {
+		if l == 0 || l > 15 {
+			t.Fatalf("Symbol %d: expecting a length of 1 to 15, got %d", symbol, l)
+		}
+		kraft += 1 / float64(uint(1)<<l)
+	}
+	if kraft != 1 {
+		t.Errorf("Expecting a complete code, Kraft sum is %f", kraft)
+	}
+}
+
+func TestPrefixEncode(t *testing.T) {
+	cases := []struct {
+		v         int
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}{
+		{1, 0, 0, 0},
+		{4, 3, 0, 0},
+		{5, 4, 0, 1},
+		{7, 5, 0, 1},
+		{4096, 23, 1023, 10},
+	}
+	for _, c := range cases {
+		symbol, extra, extraBits := prefixEncode(c.v)
+		if symbol != c.symbol || extra != c.extra || extraBits != c.extraBits {
+			t.Errorf("%d: expecting %d %d %d, got %d %d %d",
+				c.v, c.symbol, c.extra, c.extraBits, symbol, extra, extraBits)
+		}
+	}
+}
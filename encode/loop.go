@@ -0,0 +1,104 @@
+package encode
+
+import "time"
+
+// LoopOptions says how many times LoopingWriter plays its frames.
+type LoopOptions struct {
+	// Passes over the frames, at least one.
+	Loops int
+	// Add passes until the output lasts at least that long.
+	MinDuration time.Duration
+	// Play each pass forward then backward, the first and last frames showing
+	// once per pass so that passes follow each other smoothly.
+	Boomerang bool
+}
+
+// LoopingWriter plays the frames written to it several times, and writes them
+// to another FrameWriter. The first pass is written as it comes, the others
+// once closed, holding the frames of one pass in the meantime.
+type LoopingWriter struct {
+	w       FrameWriter
+	options LoopOptions
+	frames  []Frame
+}
+
+func MakeLoopingWriter(w FrameWriter, options LoopOptions) *LoopingWriter {
+	return &LoopingWriter{w: w, options: options}
+}
+
+// Write frame, and remember it for the other passes. The canvas may be reused
+// once this returns.
+func (l *LoopingWriter) WriteFrame(frame Frame) error {
+	if err := l.w.WriteFrame(frame); err != nil {
+		return err
+	}
+
+	frame.Canvas = frame.Canvas.Clone()
+	l.frames = append(l.frames, frame)
+	return nil
+}
+
+// Write the rest of the passes and close the underlying writer.
+func (l *LoopingWriter) Close() error {
+	backward := l.backward()
+	passes := l.Passes()
+	frames := l.frames
+	l.frames = nil
+
+	for pass := 0; pass < passes; pass++ {
+		if pass > 0 {
+			if err := l.writeFrames(frames); err != nil {
+				return err
+			}
+		}
+		if err := l.writeFrames(backward); err != nil {
+			return err
+		}
+	}
+
+	return l.w.Close()
+}
+
+// Number of passes over the frames written so far.
+func (l *LoopingWriter) Passes() int {
+	passes := l.options.Loops
+	if passes < 1 {
+		passes = 1
+	}
+
+	var duration time.Duration
+	for _, frame := range l.frames {
+		duration += frame.Duration
+	}
+	for _, frame := range l.backward() {
+		duration += frame.Duration
+	}
+	if duration > 0 && time.Duration(passes)*duration < l.options.MinDuration {
+		passes = int((l.options.MinDuration + duration - 1) / duration)
+	}
+
+	return passes
+}
+
+// The frames played backward after each pass when bouncing, all but the first
+// and last in reverse.
+func (l *LoopingWriter) backward() []Frame {
+	if !l.options.Boomerang || len(l.frames) < 3 {
+		return nil
+	}
+
+	frames := make([]Frame, 0, len(l.frames)-2)
+	for i := len(l.frames) - 2; i > 0; i-- {
+		frames = append(frames, l.frames[i])
+	}
+	return frames
+}
+
+func (l *LoopingWriter) writeFrames(frames []Frame) error {
+	for _, frame := range frames {
+		if err := l.w.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package encode_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/encode"
+)
+
+func TestLoopingWriter(t *testing.T) {
+	var frames []encode.Frame
+	for i := 1; i <= 4; i++ {
+		frames = append(frames, encode.Frame{
+			Canvas:   grayCanvas(uint8(i)),
+			Duration: time.Duration(i) * 100 * time.Millisecond,
+		})
+	}
+
+	cases := []struct {
+		options  encode.LoopOptions
+		expected []uint8
+	}{
+		{encode.LoopOptions{}, []uint8{1, 2, 3, 4}},
+		{encode.LoopOptions{Loops: 2}, []uint8{1, 2, 3, 4, 1, 2, 3, 4}},
+		// One pass lasts 1s.
+		{encode.LoopOptions{MinDuration: 1500 * time.Millisecond}, []uint8{1, 2, 3, 4, 1, 2, 3, 4}},
+		{encode.LoopOptions{Loops: 2, MinDuration: time.Second}, []uint8{1, 2, 3, 4, 1, 2, 3, 4}},
+		{encode.LoopOptions{Boomerang: true}, []uint8{1, 2, 3, 4, 3, 2}},
+		// One bouncing pass lasts 1.5s.
+		{
+			encode.LoopOptions{Boomerang: true, MinDuration: 2 * time.Second},
+			[]uint8{1, 2, 3, 4, 3, 2, 1, 2, 3, 4, 3, 2},
+		},
+	}
+	for _, c := range cases {
+		var recorder frameRecorder
+		w := encode.MakeLoopingWriter(&recorder, c.options)
+		for _, frame := range frames {
+			if err := w.WriteFrame(frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !recorder.closed {
+			t.Errorf("%+v: expecting the underlying writer to be closed", c.options)
+		}
+
+		var got []uint8
+		for _, frame := range recorder.frames {
+			v := uint8(frame.Canvas.At(0, 0).R() >> 8)
+			got = append(got, v)
+			if frame.Duration != time.Duration(v)*100*time.Millisecond {
+				t.Errorf("%+v: expecting frame %d's duration kept, got %s", c.options, v, frame.Duration)
+			}
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%+v: expecting frames %v, got %v", c.options, c.expected, got)
+		}
+	}
+}
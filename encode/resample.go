@@ -0,0 +1,209 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+	"webpfex/canvas"
+)
+
+// FrameRate is a rate of Num/Den frames per second, 0/0 meaning none.
+type FrameRate struct {
+	Num int64
+	Den int64
+}
+
+// Highest frame rate accepted by ParseFrameRate.
+const MaxFrameRate = 1000
+
+// Parse a frame rate given as an integer, a decimal number or a fraction, like
+// 30, 29.97 or 30000/1001.
+func ParseFrameRate(s string) (FrameRate, error) {
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 || rate.Cmp(big.NewRat(MaxFrameRate, 1)) > 0 ||
+		!rate.Num().IsInt64() || !rate.Denom().IsInt64() {
+		return FrameRate{}, fmt.Errorf("invalid frame rate %q, expecting a number above 0 up to %d",
+			s, MaxFrameRate)
+	}
+
+	return FrameRate{rate.Num().Int64(), rate.Denom().Int64()}, nil
+}
+
+func (r FrameRate) IsZero() bool {
+	return r.Num == 0
+}
+
+func (r FrameRate) String() string {
+	if r.Den == 1 {
+		return fmt.Sprint(r.Num)
+	}
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// When the nth frame starts, rounded down to the nanosecond. Computed from n
+// rather than accumulated, so that rounding doesn't drift.
+func (r FrameRate) FrameStart(n int64) time.Duration {
+	t := new(big.Int).Mul(big.NewInt(n), big.NewInt(r.Den))
+	t.Mul(t, big.NewInt(int64(time.Second)))
+	return time.Duration(t.Quo(t, big.NewInt(r.Num)).Int64())
+}
+
+// ResamplingWriter turns frames of any duration into frames at a constant
+// rate, and writes them to another FrameWriter. Each output frame shows the
+// input frame displayed at its middle, or with cross-fading the average of
+// the input frames displayed during it, weighted by how long they are. The
+// output lasts as long as the input to within half a frame.
+//
+// Frames are expected to cover the whole canvas.
+type ResamplingWriter struct {
+	w         FrameWriter
+	rate      FrameRate
+	crossFade bool
+	elapsed   time.Duration // Input time at the start of the next input frame.
+	next      int64         // Number of the next output frame, from 0.
+	last      *canvas.Canvas
+
+	// Sums of premultiplied channels weighted by nanoseconds, when
+	// cross-fading.
+	sums     []float64
+	weighted time.Duration
+}
+
+func MakeResamplingWriter(w FrameWriter, rate FrameRate, crossFade bool) *ResamplingWriter {
+	return &ResamplingWriter{w: w, rate: rate, crossFade: crossFade}
+}
+
+// Write the output frames frame spans. The canvas may be reused once this
+// returns.
+func (r *ResamplingWriter) WriteFrame(frame Frame) error {
+	if r.rate.Num <= 0 || r.rate.Den <= 0 {
+		return errors.New("resampling needs a frame rate")
+	}
+	if r.last != nil && (r.last.Width() != frame.Canvas.Width() ||
+		r.last.Height() != frame.Canvas.Height()) {
+		return errors.New("resampled frames must be the same size")
+	}
+
+	start := r.elapsed
+	end := start + frame.Duration
+	r.elapsed = end
+	last := frame.Canvas.Clone()
+	r.last = &last
+
+	if r.crossFade {
+		return r.crossFadeFrame(&frame.Canvas, start, end)
+	}
+
+	for {
+		begin, finish := r.rate.FrameStart(r.next), r.rate.FrameStart(r.next+1)
+		if middle := begin + (finish-begin)/2; middle >= end {
+			return nil
+		}
+		if err := r.emit(frame.Canvas); err != nil {
+			return err
+		}
+	}
+}
+
+// Add canvas, displayed from start to end, to the output frames it overlaps,
+// writing the completed ones.
+func (r *ResamplingWriter) crossFadeFrame(cv *canvas.Canvas, start, end time.Duration) error {
+	if r.sums == nil {
+		r.sums = make([]float64, 4*int(cv.Width())*int(cv.Height()))
+	}
+
+	for start < end {
+		finish := r.rate.FrameStart(r.next + 1)
+		segment := end - start
+		if finish-start < segment {
+			segment = finish - start
+		}
+		r.accumulate(cv, segment)
+		start += segment
+
+		if start == finish {
+			if err := r.emit(r.average()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *ResamplingWriter) accumulate(cv *canvas.Canvas, weight time.Duration) {
+	w := float64(weight)
+	i := 0
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			red, green, blue, alpha := cv.At(x, y).Rgba()
+			a := float64(alpha>>8) / 0xFF
+			r.sums[i] += float64(red>>8) * a * w
+			r.sums[i+1] += float64(green>>8) * a * w
+			r.sums[i+2] += float64(blue>>8) * a * w
+			r.sums[i+3] += a * w
+			i += 4
+		}
+	}
+	r.weighted += weight
+}
+
+// The accumulated canvas, which is reset.
+func (r *ResamplingWriter) average() canvas.Canvas {
+	cv := canvas.MakeCanvas(r.last.Width(), r.last.Height())
+	total := float64(r.weighted)
+	i := 0
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			alpha := r.sums[i+3]
+			if alpha > 0 {
+				channel := func(sum float64) uint16 {
+					return uint16(math.Min(0xFF, math.Round(sum/alpha))) * 0x101
+				}
+				cv.WriteAt(x, y, canvas.MakeColorRgba(
+					channel(r.sums[i]),
+					channel(r.sums[i+1]),
+					channel(r.sums[i+2]),
+					uint16(math.Round(alpha/total*0xFF))*0x101,
+				))
+			}
+			i += 4
+		}
+	}
+
+	for i := range r.sums {
+		r.sums[i] = 0
+	}
+	r.weighted = 0
+	return cv
+}
+
+// Write the next output frame showing cv.
+func (r *ResamplingWriter) emit(cv canvas.Canvas) error {
+	duration := r.rate.FrameStart(r.next+1) - r.rate.FrameStart(r.next)
+	r.next++
+	return r.w.WriteFrame(Frame{Canvas: cv, Duration: duration})
+}
+
+// Write the last output frame if the input covers most of it, or if there
+// would be no output otherwise, and close the underlying writer.
+func (r *ResamplingWriter) Close() error {
+	if r.last != nil {
+		half := (r.rate.FrameStart(r.next+1) - r.rate.FrameStart(r.next)) / 2
+		switch {
+		case r.crossFade && r.weighted > 0 && (r.weighted > half || r.next == 0):
+			if err := r.emit(r.average()); err != nil {
+				return err
+			}
+		case !r.crossFade && r.next == 0:
+			if err := r.emit(*r.last); err != nil {
+				return err
+			}
+		}
+		r.last = nil
+	}
+
+	return r.w.Close()
+}
@@ -0,0 +1,124 @@
+package encode_test
+
+import (
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+func TestParseFrameRate(t *testing.T) {
+	valid := map[string]encode.FrameRate{
+		"30":         {30, 1},
+		"29.97":      {2997, 100},
+		"30000/1001": {30000, 1001},
+		"0.5":        {1, 2},
+	}
+	for s, expected := range valid {
+		if rate, err := encode.ParseFrameRate(s); err != nil || rate != expected {
+			t.Errorf("%s: expecting %v, got %v %v", s, expected, rate, err)
+		}
+	}
+
+	for _, s := range []string{"", "0", "-1", "fast", "1001", "1/0"} {
+		if _, err := encode.ParseFrameRate(s); err == nil {
+			t.Errorf("%s: expecting an error", s)
+		}
+	}
+}
+
+func grayCanvas(v uint8) canvas.Canvas {
+	cv := canvas.MakeCanvas(2, 1)
+	c := uint16(v) * 0x101
+	fillRect(&cv, 0, 0, 2, 1, canvas.MakeColorRgba(c, c, c, 0xFFFF))
+	return cv
+}
+
+func resample(t *testing.T, rate encode.FrameRate, crossFade bool, frames []encode.Frame) []encode.Frame {
+	t.Helper()
+
+	var recorder frameRecorder
+	w := encode.MakeResamplingWriter(&recorder, rate, crossFade)
+	for _, frame := range frames {
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.frames
+}
+
+func TestResamplingWriter(t *testing.T) {
+	frames := []encode.Frame{
+		{Canvas: grayCanvas(10), Duration: 100 * time.Millisecond},
+		{Canvas: grayCanvas(20), Duration: 50 * time.Millisecond},
+		{Canvas: grayCanvas(30), Duration: 250 * time.Millisecond},
+	}
+
+	// Frames are sampled at 50, 150, 250 and 350ms.
+	out := resample(t, encode.FrameRate{Num: 10, Den: 1}, false, frames)
+	expected := []uint16{10, 30, 30, 30}
+	if len(out) != len(expected) {
+		t.Fatalf("Expecting %d frames, got %d", len(expected), len(out))
+	}
+	for i, f := range out {
+		if g := f.Canvas.At(0, 0).G() >> 8; g != expected[i] || f.Duration != 100*time.Millisecond {
+			t.Errorf("Frame %d: expecting gray %d for 100ms, got %d for %s",
+				i+1, expected[i], g, f.Duration)
+		}
+	}
+}
+
+func TestResamplingWriterTotalDuration(t *testing.T) {
+	var frames []encode.Frame
+	for i := 0; i < 7; i++ {
+		frames = append(frames, encode.Frame{Canvas: grayCanvas(uint8(i)), Duration: 143 * time.Millisecond})
+	}
+
+	for _, rate := range []encode.FrameRate{{30000, 1001}, {24, 1}, {7, 3}} {
+		for _, crossFade := range []bool{false, true} {
+			out := resample(t, rate, crossFade, frames)
+			var total time.Duration
+			for _, f := range out {
+				total += f.Duration
+			}
+
+			// 1001ms of input.
+			half := rate.FrameStart(1) / 2
+			if diff := total - 1001*time.Millisecond; diff > half || -diff > half {
+				t.Errorf("%v cross-fade %t: expecting about 1001ms, got %s over %d frames",
+					rate, crossFade, total, len(out))
+			}
+			if total != rate.FrameStart(int64(len(out))) {
+				t.Errorf("%v: expecting durations to add up without drift, got %s", rate, total)
+			}
+		}
+	}
+}
+
+func TestResamplingWriterCrossFade(t *testing.T) {
+	frames := []encode.Frame{
+		{Canvas: grayCanvas(0), Duration: 75 * time.Millisecond},
+		{Canvas: grayCanvas(200), Duration: 75 * time.Millisecond},
+	}
+
+	out := resample(t, encode.FrameRate{Num: 20, Den: 1}, true, frames)
+	expected := []uint16{0, 100, 200}
+	if len(out) != len(expected) {
+		t.Fatalf("Expecting %d frames, got %d", len(expected), len(out))
+	}
+	for i, f := range out {
+		if g := f.Canvas.At(0, 0).G() >> 8; g != expected[i] {
+			t.Errorf("Frame %d: expecting gray %d, got %d", i+1, expected[i], g)
+		}
+	}
+
+	// A frame too short for any output frame still yields one.
+	short := resample(t, encode.FrameRate{Num: 1, Den: 1}, false, frames[:1])
+	if len(short) != 1 {
+		t.Errorf("Expecting a single frame, got %d", len(short))
+	}
+}
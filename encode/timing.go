@@ -0,0 +1,119 @@
+package encode
+
+import (
+	"math"
+	"time"
+)
+
+// Browsers show frames lasting at most BrowserClampMax for BrowserClampDuration
+// instead, like they do the 0 delays of GIFs.
+const (
+	BrowserClampMax      = 10 * time.Millisecond
+	BrowserClampDuration = 100 * time.Millisecond
+)
+
+// TimingOptions says how TimingWriter changes the timing of frames.
+type TimingOptions struct {
+	// Duration of the ith frame written, from 0, given its own. Nil keeps
+	// durations as they are.
+	Durations func(i int, duration time.Duration) time.Duration
+	// Play that many times faster, or slower below 1. 0 keeps the speed.
+	Speed float64
+	// Play the frames last to first.
+	Reverse bool
+}
+
+// TimingWriter changes the durations and order of the frames written to it,
+// and writes them to another FrameWriter. Reversing holds every frame until
+// closed.
+//
+// Sped up frames are timed to the millisecond from their start, keeping the
+// total duration exact. A frame a speed up would leave lasting no more than
+// BrowserClampMax, which browsers would slow down instead, absorbs the frames
+// after it until it lasts longer.
+type TimingWriter struct {
+	w       FrameWriter
+	options TimingOptions
+	written int
+	held    []Frame
+	// Time the frames sped up so far lasted before.
+	elapsed time.Duration
+	// Sped up frame too short to be written on its own so far.
+	pending *Frame
+}
+
+func MakeTimingWriter(w FrameWriter, options TimingOptions) *TimingWriter {
+	return &TimingWriter{w: w, options: options}
+}
+
+// Write frame, or hold it when reversing. The canvas may be reused once this
+// returns.
+func (t *TimingWriter) WriteFrame(frame Frame) error {
+	if t.options.Durations != nil {
+		frame.Duration = t.options.Durations(t.written, frame.Duration)
+	}
+	t.written++
+
+	if t.options.Reverse {
+		frame.Canvas = frame.Canvas.Clone()
+		t.held = append(t.held, frame)
+		return nil
+	}
+	return t.speedUp(frame)
+}
+
+// Write the held frames and close the underlying writer.
+func (t *TimingWriter) Close() error {
+	held := t.held
+	t.held = nil
+	for i := len(held) - 1; i >= 0; i-- {
+		if err := t.speedUp(held[i]); err != nil {
+			return err
+		}
+	}
+
+	if t.pending != nil {
+		// Nothing follows to make up for it.
+		if t.pending.Duration <= BrowserClampMax {
+			t.pending.Duration = BrowserClampMax + time.Millisecond
+		}
+		if err := t.w.WriteFrame(*t.pending); err != nil {
+			return err
+		}
+		t.pending = nil
+	}
+
+	return t.w.Close()
+}
+
+func (t *TimingWriter) speedUp(frame Frame) error {
+	speed := t.options.Speed
+	if speed == 0 || speed == 1 {
+		return t.w.WriteFrame(frame)
+	}
+
+	scale := func(d time.Duration) time.Duration {
+		ms := math.Round(float64(d) / speed / float64(time.Millisecond))
+		return time.Duration(ms) * time.Millisecond
+	}
+	original := frame.Duration
+	start := scale(t.elapsed)
+	t.elapsed += original
+	frame.Duration = scale(t.elapsed) - start
+
+	if t.pending != nil {
+		t.pending.Duration += frame.Duration
+		if t.pending.Duration <= BrowserClampMax {
+			return nil
+		}
+		pending := *t.pending
+		t.pending = nil
+		return t.w.WriteFrame(pending)
+	}
+	if frame.Duration <= BrowserClampMax && original > BrowserClampMax {
+		frame.Canvas = frame.Canvas.Clone()
+		t.pending = &frame
+		return nil
+	}
+	return t.w.WriteFrame(frame)
+}
@@ -0,0 +1,87 @@
+package encode_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/encode"
+)
+
+func TestTimingWriter(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		options   encode.TimingOptions
+		durations []time.Duration
+		expected  []uint8
+		timing    []time.Duration
+	}{
+		{encode.TimingOptions{}, []time.Duration{40 * ms, 60 * ms}, []uint8{1, 2}, []time.Duration{40 * ms, 60 * ms}},
+		{
+			encode.TimingOptions{Reverse: true},
+			[]time.Duration{40 * ms, 60 * ms, 80 * ms},
+			[]uint8{3, 2, 1},
+			[]time.Duration{80 * ms, 60 * ms, 40 * ms},
+		},
+		// Rounded from the start of each frame, 100ms in total.
+		{
+			encode.TimingOptions{Speed: 1.5},
+			[]time.Duration{50 * ms, 50 * ms, 50 * ms},
+			[]uint8{1, 2, 3},
+			[]time.Duration{33 * ms, 34 * ms, 33 * ms},
+		},
+		{
+			encode.TimingOptions{Speed: 0.5},
+			[]time.Duration{40 * ms, 0},
+			[]uint8{1, 2},
+			[]time.Duration{80 * ms, 0},
+		},
+		// Frames of 8ms would be slowed down to 100ms by browsers.
+		{
+			encode.TimingOptions{Speed: 5},
+			[]time.Duration{40 * ms, 40 * ms, 40 * ms, 100 * ms, 40 * ms},
+			[]uint8{1, 3, 5},
+			[]time.Duration{16 * ms, 28 * ms, 11 * ms},
+		},
+		{
+			encode.TimingOptions{
+				Durations: func(i int, d time.Duration) time.Duration {
+					if i == 0 {
+						return 200 * ms
+					}
+					return d
+				},
+				Reverse: true,
+				Speed:   2,
+			},
+			[]time.Duration{40 * ms, 60 * ms},
+			[]uint8{2, 1},
+			[]time.Duration{30 * ms, 100 * ms},
+		},
+	}
+	for _, c := range cases {
+		var recorder frameRecorder
+		w := encode.MakeTimingWriter(&recorder, c.options)
+		for i, d := range c.durations {
+			if err := w.WriteFrame(encode.Frame{Canvas: grayCanvas(uint8(i + 1)), Duration: d}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !recorder.closed {
+			t.Errorf("%+v: expecting the underlying writer to be closed", c.options)
+		}
+
+		var got []uint8
+		var timing []time.Duration
+		for _, frame := range recorder.frames {
+			got = append(got, uint8(frame.Canvas.At(0, 0).R()>>8))
+			timing = append(timing, frame.Duration)
+		}
+		if !reflect.DeepEqual(got, c.expected) || !reflect.DeepEqual(timing, c.timing) {
+			t.Errorf("%+v: expecting frames %v lasting %v, got %v lasting %v",
+				c.options, c.expected, c.timing, got, timing)
+		}
+	}
+}
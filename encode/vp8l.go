@@ -0,0 +1,217 @@
+package encode
+
+import (
+	"fmt"
+	"webpfex/canvas"
+)
+
+const (
+	vp8lSignature  = 0x2F
+	vp8lMaxSize    = 1 << 14
+	subtractGreen  = 2
+	lengthSymbols  = 24
+	distanceCodes  = 40
+	maxMatchLength = 4096
+	minMatchLength = 3
+	hashBits       = 16
+	// Distance codes up to 120 address neighbouring pixels, farther distances
+	// are offset by it.
+	planeCodes = 120
+	// Largest distance the 40 distance prefix symbols can code.
+	maxDistance = 1<<20 - planeCodes
+)
+
+// A literal pixel, or a backward reference copying length pixels from
+// distance pixels before.
+type vp8lToken struct {
+	argb     uint32
+	length   int
+	distance int
+}
+
+// Encode cv as a VP8L bitstream, the payload of a VP8L chunk.
+func EncodeVP8L(cv canvas.Canvas) ([]byte, error) {
+	width, height := cv.Width(), cv.Height()
+	if width == 0 || height == 0 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return nil, fmt.Errorf("cannot encode %dx%d frame, VP8L frames are 1 to %d pixels wide and high",
+			width, height, vp8lMaxSize)
+	}
+
+	pixels := make([]uint32, 0, width*height)
+	alpha := false
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			r, g, b, a := cv.At(x, y).Rgba()
+			alpha = alpha || a>>8 != 0xFF
+			pixels = append(pixels, uint32(a>>8)<<24|uint32(r>>8)<<16|uint32(g>>8)<<8|uint32(b>>8))
+		}
+	}
+	applySubtractGreen(pixels)
+	tokens := findBackwardReferences(pixels, int(width))
+
+	var w bitWriter
+	w.WriteBits(vp8lSignature, 8)
+	w.WriteBits(width-1, 14)
+	w.WriteBits(height-1, 14)
+	if alpha {
+		w.WriteBits(1, 1)
+	} else {
+		w.WriteBits(0, 1)
+	}
+	w.WriteBits(0, 3) // Version.
+
+	w.WriteBits(1, 1) // A transform follows.
+	w.WriteBits(subtractGreen, 2)
+	w.WriteBits(0, 1) // No more transforms.
+	w.WriteBits(0, 1) // No color cache.
+	w.WriteBits(0, 1) // A single group of prefix codes for the whole image.
+	writeVP8LImageData(&w, tokens, int(width))
+
+	return w.Bytes(), nil
+}
+
+// Store red and blue as differences with green, which they tend to follow.
+func applySubtractGreen(pixels []uint32) {
+	for i, argb := range pixels {
+		green := argb >> 8 & 0xFF
+		red := (argb>>16 - green) & 0xFF
+		blue := (argb - green) & 0xFF
+		pixels[i] = argb&0xFF00FF00 | red<<16 | blue
+	}
+}
+
+// Greedily replace runs of pixels seen before with backward references,
+// looking at the pixels left and above, and the last position with the same
+// two pixels.
+func findBackwardReferences(pixels []uint32, width int) []vp8lToken {
+	var tokens []vp8lToken
+	var last [1 << hashBits]int32
+	for i := range last {
+		last[i] = -1
+	}
+	hash := func(i int) uint32 {
+		return (pixels[i]*0x1E35A7BD + pixels[i+1]*0x9E3779B1) >> (32 - hashBits)
+	}
+	matchLength := func(i, distance int) int {
+		n := 0
+		for i+n < len(pixels) && n < maxMatchLength && pixels[i+n] == pixels[i+n-distance] {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(pixels); {
+		best := vp8lToken{}
+		candidates := []int{1, width}
+		if i+1 < len(pixels) {
+			if pos := last[hash(i)]; pos >= 0 {
+				candidates = append(candidates, i-int(pos))
+			}
+		}
+		for _, distance := range candidates {
+			if distance < 1 || distance > i || distance > maxDistance {
+				continue
+			}
+			if n := matchLength(i, distance); n > best.length {
+				best = vp8lToken{length: n, distance: distance}
+			}
+		}
+
+		n := 1
+		if best.length >= minMatchLength {
+			tokens = append(tokens, best)
+			n = best.length
+		} else {
+			tokens = append(tokens, vp8lToken{argb: pixels[i]})
+		}
+		for end := i + n; i < end; i++ {
+			if i+1 < len(pixels) {
+				last[hash(i)] = int32(i)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// Split the 1-based value v into a prefix symbol and extra bits, as lengths and
+// distances are coded.
+func prefixEncode(v int) (symbol int, extra uint32, extraBits uint) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+
+	highest := uint(0)
+	for v>>(highest+1) != 0 {
+		highest++
+	}
+	second := v >> (highest - 1) & 1
+	extraBits = highest - 1
+
+	return int(2*highest) + second, uint32(v) & (1<<extraBits - 1), extraBits
+}
+
+// Distance code of distance: 1 and 2 for the pixels above and to the left,
+// which are common, otherwise offset past the neighbourhood codes.
+func distanceCode(distance int, width int) int {
+	switch distance {
+	case width:
+		return 1
+	case 1:
+		return 2
+	default:
+		return distance + planeCodes
+	}
+}
+
+// Write the prefix codes of tokens followed by the tokens.
+func writeVP8LImageData(w *bitWriter, tokens []vp8lToken, width int) {
+	green := make([]uint32, 256+lengthSymbols)
+	red := make([]uint32, 256)
+	blue := make([]uint32, 256)
+	alpha := make([]uint32, 256)
+	distance := make([]uint32, distanceCodes)
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[t.argb>>8&0xFF]++
+			red[t.argb>>16&0xFF]++
+			blue[t.argb&0xFF]++
+			alpha[t.argb>>24]++
+			continue
+		}
+
+		lengthSymbol, _, _ := prefixEncode(t.length)
+		green[256+lengthSymbol]++
+		distanceSymbol, _, _ := prefixEncode(distanceCode(t.distance, width))
+		distance[distanceSymbol]++
+	}
+
+	codes := []huffmanCode{
+		makeHuffmanCode(green, 15),
+		makeHuffmanCode(red, 15),
+		makeHuffmanCode(blue, 15),
+		makeHuffmanCode(alpha, 15),
+		makeHuffmanCode(distance, 15),
+	}
+	for _, code := range codes {
+		writeHuffmanCode(w, code)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].WriteSymbol(w, int(t.argb>>8&0xFF))
+			codes[1].WriteSymbol(w, int(t.argb>>16&0xFF))
+			codes[2].WriteSymbol(w, int(t.argb&0xFF))
+			codes[3].WriteSymbol(w, int(t.argb>>24))
+			continue
+		}
+
+		symbol, extra, extraBits := prefixEncode(t.length)
+		codes[0].WriteSymbol(w, 256+symbol)
+		w.WriteBits(extra, extraBits)
+		symbol, extra, extraBits = prefixEncode(distanceCode(t.distance, width))
+		codes[4].WriteSymbol(w, symbol)
+		w.WriteBits(extra, extraBits)
+	}
+}
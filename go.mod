@@ -2,4 +2,4 @@ module webpfex
 
 go 1.19
 
-require golang.org/x/image v0.2.0 // indirect
+require golang.org/x/image v0.2.0
@@ -1,44 +1,278 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
+	"os/signal"
+	"runtime/debug"
 	"strings"
 	"webpfex/webpfex"
 )
 
+// Exit codes, documented in HELP.
+const (
+	exitOk          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitInput       = 3
+	exitToolMissing = 4
+	exitEncode      = 5
+)
+
 var HELP string = strings.TrimSpace(`
-Usage: webpfex extract AWEBP OUTDIR
-       webpfex convert AWEBP OUTMP4
+Usage: webpfex COMMAND [OPTIONS] ARGS...
 
-webpfex extracts frames from an animated WEBP or convert them to MP4. Relies on 
+webpfex extracts frames from an animated WEBP or convert them to MP4. Relies on
 webpmux and ffmpeg.
+
+Commands:
+%s
+Run 'webpfex COMMAND --help' for the options of a command.
+
+Exit codes:
+  0  success
+  1  failure, including some files of a batch failing
+  2  usage error
+  3  input error: missing, malformed, not animated or over a limit
+  4  a required tool such as webpmux or ffmpeg is missing
+  5  encoding the output failed
 `)
 
+// Set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// A subcommand of the CLI.
+type command struct {
+	name    string
+	usage   string // Synopsis lines following "webpfex".
+	summary string
+	help    string // Description and options, shown by --help.
+	run     func(ctx context.Context, cli *cli, args []string) error
+}
+
+// Streams the running command writes to.
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Returned by commands for invalid arguments.
+type usageError struct {
+	reason string
+}
+
+func (e usageError) Error() string {
+	return e.reason
+}
+
+// Returned by commands that have already reported their failure.
+var errReported = errors.New("failure already reported")
+
+// Returned by doctor once it reported missing tools.
+var errToolsMissing = errors.New("tools missing")
+
 func main() {
-	args := os.Args[1:]
-	if len(args) == 3 {
-		switch args[0] {
-		case "extract":
-			webp := args[1]
-			outdir := args[2]
-
-			err := webpfex.ExtractWebpFramesAsPng(webp, outdir)
-			if err != nil {
-				panic(err)
-			}
-		case "convert":
-			webp := args[1]
-			out := args[2]
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+
+	os.Exit(code)
+}
 
-			err := webpfex.ConvertWebpToMp4(webp, out)
-			if err != nil {
-				panic(err)
+// Run the CLI with args, excluding the program name, and return the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	c := cli{stdout, stderr}
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage())
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				fmt.Fprintln(stdout, commandHelp(cmd))
+				return exitOk
 			}
+			fmt.Fprintf(stderr, "webpfex: unknown command %q\n", args[1])
+			return exitUsage
+		}
+		fmt.Fprintln(stdout, usage())
+		return exitOk
+	case "version", "-version", "--version":
+		fmt.Fprintln(stdout, "webpfex", versionString())
+		return exitOk
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "webpfex: unknown command %q\n\n%s\n", args[0], usage())
+		return exitUsage
+	}
+
+	return c.exit(cmd, cmd.run(ctx, &c, args[1:]))
+}
+
+// Report err from cmd and return the matching exit code.
+func (c *cli) exit(cmd *command, err error) int {
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprintln(c.stdout, commandHelp(cmd))
+		return exitOk
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(c.stderr, "webpfex %s: %s\n", cmd.name, usageErr.reason)
+		fmt.Fprintf(c.stderr, "Usage: %s\nRun 'webpfex %s --help' for details.\n",
+			usageLines(cmd), cmd.name)
+		return exitUsage
+	case errors.Is(err, errReported):
+		return exitFailure
+	case errors.Is(err, errToolsMissing):
+		return exitToolMissing
+	}
+
+	fmt.Fprintf(c.stderr, "webpfex %s: %v\n", cmd.name, err)
+	return exitCode(err)
+}
+
+// Exit code for an error returned by the webpfex package.
+func exitCode(err error) int {
+	var commandErr *webpfex.CommandError
+	var limitErr *webpfex.LimitError
+	var parsingErr *webpfex.ParsingError
+	var toolErr *webpfex.ToolError
+	var timeoutErr *webpfex.TimeoutError
+	switch {
+	case errors.As(err, &toolErr), errors.Is(err, exec.ErrNotFound):
+		return exitToolMissing
+	case errors.As(err, &limitErr), errors.As(err, &timeoutErr), errors.As(err, &parsingErr),
+		errors.Is(err, fs.ErrNotExist), errors.Is(err, webpfex.ErrNotAnimated):
+		return exitInput
+	case errors.As(err, &commandErr):
+		if commandErr.Name == webpfex.ToolFfmpeg {
+			return exitEncode
 		}
+		return exitInput
+	default:
+		return exitFailure
+	}
+}
 
-		return
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
 
-	fmt.Println(HELP)
+	return nil
+}
+
+func usage() string {
+	var list strings.Builder
+	for _, cmd := range commands {
+		fmt.Fprintf(&list, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+
+	return fmt.Sprintf(HELP, list.String())
+}
+
+func usageLines(cmd *command) string {
+	lines := strings.Split(cmd.usage, "\n")
+	for i := range lines {
+		lines[i] = "webpfex " + lines[i]
+	}
+
+	return strings.Join(lines, "\n       ")
+}
+
+func commandHelp(cmd *command) string {
+	return fmt.Sprintf("Usage: %s\n\n%s", usageLines(cmd), strings.TrimSpace(cmd.help))
+}
+
+func versionString() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" &&
+		info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	return version
+}
+
+// Flags shared by every command that writes outputs.
+type commonFlags struct {
+	overwrite bool
+	quiet     bool
+	verbose   bool
+}
+
+const commonFlagsHelp = `  --overwrite      replace existing outputs
+  --quiet          print nothing but errors
+  --verbose        print details about every processed file`
+
+func addCommonFlags(flags *flag.FlagSet) *commonFlags {
+	var common commonFlags
+	flags.BoolVar(&common.overwrite, "overwrite", false, "")
+	flags.BoolVar(&common.quiet, "quiet", false, "")
+	flags.BoolVar(&common.verbose, "verbose", false, "")
+
+	return &common
+}
+
+func (common *commonFlags) validate() error {
+	if common.quiet && common.verbose {
+		return usageError{"--quiet and --verbose are mutually exclusive"}
+	}
+
+	return nil
+}
+
+const toolFlagsHelp = `  --webpmux=PATH   webpmux to run instead of $WEBPFEX_WEBPMUX or the one in PATH
+  --ffmpeg=PATH    ffmpeg to run instead of $WEBPFEX_FFMPEG or the one in PATH`
+
+func addToolFlags(flags *flag.FlagSet) *webpfex.Tools {
+	var tools webpfex.Tools
+	flags.StringVar(&tools.Webpmux, "webpmux", "", "")
+	flags.StringVar(&tools.Ffmpeg, "ffmpeg", "", "")
+
+	return &tools
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	return flags
+}
+
+// Parse args with flags, allowing flags after positional arguments, and return
+// the positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err.Error()}
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 }
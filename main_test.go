@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/webpfex"
+)
+
+// Run the CLI in-process, returning its exit code, stdout and stderr.
+func runCli(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCliUsage(t *testing.T) {
+	code, _, stderr := runCli(t)
+	if code != exitUsage {
+		t.Errorf("Expecting exit code %d without args, got %d", exitUsage, code)
+	}
+	if !strings.Contains(stderr, "Usage: webpfex") {
+		t.Errorf("Expecting usage on stderr, got %q", stderr)
+	}
+
+	code, stdout, _ := runCli(t, "--help")
+	if code != exitOk || !strings.Contains(stdout, "Exit codes:") {
+		t.Errorf("Expecting help on stdout, got %d %q", code, stdout)
+	}
+}
+
+func TestCliUnknownCommand(t *testing.T) {
+	code, _, stderr := runCli(t, "frobnicate", "a", "b")
+	if code != exitUsage {
+		t.Errorf("Expecting exit code %d, got %d", exitUsage, code)
+	}
+	if !strings.Contains(stderr, `unknown command "frobnicate"`) {
+		t.Errorf("Expecting unknown command error, got %q", stderr)
+	}
+}
+
+func TestCliVersion(t *testing.T) {
+	for _, arg := range []string{"version", "--version"} {
+		code, stdout, _ := runCli(t, arg)
+		if code != exitOk || !strings.HasPrefix(stdout, "webpfex ") {
+			t.Errorf("%s: expecting version, got %d %q", arg, code, stdout)
+		}
+	}
+}
+
+func TestCliCommandHelp(t *testing.T) {
+	for _, cmd := range commands {
+		for _, args := range [][]string{{cmd.name, "--help"}, {"help", cmd.name}} {
+			code, stdout, _ := runCli(t, args...)
+			if code != exitOk {
+				t.Errorf("%v: expecting exit code %d, got %d", args, exitOk, code)
+			}
+			if !strings.HasPrefix(stdout, "Usage: webpfex "+cmd.name) {
+				t.Errorf("%v: expecting command usage, got %q", args, stdout)
+			}
+		}
+	}
+}
+
+func TestCliUsageErrors(t *testing.T) {
+	cases := [][]string{
+		{"extract"},
+		{"convert", "in.webp"},
+		{"convert", "--frobnicate", "in.webp", "out.mp4"},
+		{"convert", "--quiet", "--verbose", "in.webp", "out.mp4"},
+		{"convert", "--progress=loud", "in.webp", "out.mp4"},
+		{"convert", "--jobs=0", "in.webp", "out.mp4"},
+		{"extract", "--color=cmyk", "in.webp", "out"},
+		{"convert", "--coalesce=256", "in.webp", "out.mp4"},
+		{"extract", "--coalesce", "in.webp", "out"},
+		{"convert", "--fps=0", "in.webp", "out.mp4"},
+		{"extract", "--fps=abc", "in.webp", "out"},
+		{"extract", "--cross-fade", "in.webp", "out"},
+		{"convert", "--coalesce", "--fps=30", "in.webp", "out.mp4"},
+		{"convert", "--loops=-1", "in.webp", "out.mp4"},
+		{"convert", "--min-duration=5", "in.webp", "out.mp4"},
+		{"convert", "--min-duration=-5s", "in.webp", "out.mp4"},
+		{"extract", "--boomerang", "in.webp", "out"},
+		{"transform", "--resize=abc", "in.webp", "out.webp"},
+		{"transform", "--rotate=45", "in.webp", "out.webp"},
+		{"transform", "--filter=bicubic", "in.webp", "out.webp"},
+		{"transform", "--format=gif", "in.webp", "out.gif"},
+		{"convert", "--resize=512x512", "in.webp", "out.mp4"},
+		{"convert", "--background=missing.png", "in.webp", "out.mp4"},
+		{"assemble", "frames"},
+		{"assemble", "--duration=soon", "frames", "out.webp"},
+		{"assemble", "--quality=101", "frames", "out.webp"},
+		{"assemble", "--loops=65536", "frames", "out.webp"},
+		{"import", "in.gif"},
+		{"import", "--loops=65536", "in.gif", "out.webp"},
+		{"convert", "--lossy", "in.webp", "out.mp4"},
+		{"retime", "--speed=0", "in.webp", "out.webp"},
+		{"retime", "--speed=-1.5", "in.webp", "out.webp"},
+		{"retime", "--format=gif", "in.webp", "out.gif"},
+		{"extract", "--reverse", "in.webp", "out"},
+		{"convert", "--timing-policy=fast", "in.webp", "out.mp4"},
+		{"import", "--timing-policy=min=soon", "in.gif", "out.webp"},
+		{"lint"},
+		{"lint", "a.webp", "b.webp"},
+		{"chunks"},
+		{"chunks", "drop", "EXIF", "in.webp"},
+		{"chunks", "drop", "EXIFS", "in.webp", "out.webp"},
+		{"chunks", "--index=2", "in.webp"},
+		{"chunks", "--json", "extract", "EXIF", "in.webp", "out.exif"},
+		{"repair"},
+		{"repair", "--extract", "in.webp"},
+		{"repair", "in.webp", "out.webp", "extra"},
+	}
+	for _, args := range cases {
+		code, _, stderr := runCli(t, args...)
+		if code != exitUsage {
+			t.Errorf("%v: expecting exit code %d, got %d", args, exitUsage, code)
+		}
+		if !strings.Contains(stderr, "Run 'webpfex "+args[0]+" --help'") {
+			t.Errorf("%v: expecting a pointer to help, got %q", args, stderr)
+		}
+	}
+}
+
+func TestCliMissingInput(t *testing.T) {
+	dir := t.TempDir()
+	code, _, stderr := runCli(t,
+		"extract", filepath.Join(dir, "missing.webp"), filepath.Join(dir, "out"))
+	if code != exitInput {
+		t.Errorf("Expecting exit code %d, got %d: %s", exitInput, code, stderr)
+	}
+}
+
+func TestCliToolMissing(t *testing.T) {
+	dir := t.TempDir()
+	webp := filepath.Join(dir, "in.webp")
+	if err := os.WriteFile(webp, []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "")
+
+	code, _, stderr := runCli(t, "convert", "--quiet", webp, filepath.Join(dir, "out.mp4"))
+	if code != exitToolMissing {
+		t.Errorf("Expecting exit code %d, got %d: %s", exitToolMissing, code, stderr)
+	}
+}
+
+func TestCliRefusesOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	webp := filepath.Join(dir, "in.webp")
+	out := filepath.Join(dir, "out.mp4")
+	for _, p := range []string{webp, out} {
+		if err := os.WriteFile(p, []byte("RIFF"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flags may follow the positional arguments.
+	code, _, stderr := runCli(t, "convert", webp, out, "--quiet")
+	if code != exitFailure || !strings.Contains(stderr, "file already exists") {
+		t.Errorf("Expecting exit code %d refusing to overwrite, got %d: %s",
+			exitFailure, code, stderr)
+	}
+}
+
+func TestCliBatchSummary(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	os.Mkdir(in, 0755)
+	if err := os.WriteFile(filepath.Join(in, "a.webp"), []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "")
+
+	code, _, stderr := runCli(t, "convert", "--recursive", in, filepath.Join(dir, "out"))
+	if code != exitFailure {
+		t.Errorf("Expecting exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr, "0 succeeded, 0 skipped, 1 failed") {
+		t.Errorf("Expecting summary, got %q", stderr)
+	}
+}
+
+func TestCliDoctor(t *testing.T) {
+	t.Setenv("PATH", "")
+	t.Setenv("WEBPFEX_WEBPMUX", "")
+	t.Setenv("WEBPFEX_FFMPEG", "")
+
+	code, stdout, _ := runCli(t, "doctor")
+	if code != exitToolMissing {
+		t.Errorf("Expecting exit code %d, got %d", exitToolMissing, code)
+	}
+	if !strings.Contains(stdout, "webpmux  missing") || !strings.Contains(stdout, "ffmpeg   missing") {
+		t.Errorf("Expecting both tools reported missing, got %q", stdout)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	webpmux := filepath.Join(dir, "webpmux")
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	os.WriteFile(webpmux, []byte("#!/bin/sh\necho 1.3.2\n"), 0755)
+	os.WriteFile(ffmpeg, []byte(`#!/bin/sh
+echo "ffmpeg version 6.0"
+echo " ------"
+echo " V....D libx264    H.264"
+echo " V....D libwebp    libwebp WebP"
+`), 0755)
+
+	code, stdout, _ = runCli(t, "doctor", "--webpmux="+webpmux, "--ffmpeg="+ffmpeg)
+	if code != exitOk {
+		t.Errorf("Expecting exit code %d, got %d: %s", exitOk, code, stdout)
+	}
+	if !strings.Contains(stdout, "encoder libx264") || !strings.Contains(stdout, "encoder libwebp") {
+		t.Errorf("Expecting encoders to be reported, got %q", stdout)
+	}
+}
+
+func TestCoalesceFlag(t *testing.T) {
+	cases := []struct {
+		value     string
+		enabled   bool
+		threshold uint8
+	}{
+		{"true", true, 0},
+		{"false", false, 0},
+		{"1", true, 1},
+		{"12", true, 12},
+	}
+	for _, c := range cases {
+		var f coalesceFlag
+		if err := f.Set(c.value); err != nil {
+			t.Errorf("%s: unexpected error %v", c.value, err)
+		} else if f.enabled != c.enabled || f.threshold != c.threshold {
+			t.Errorf("%s: expecting %t %d, got %t %d",
+				c.value, c.enabled, c.threshold, f.enabled, f.threshold)
+		}
+	}
+}
+
+func TestTransformFlags(t *testing.T) {
+	flags := newFlagSet("transform")
+	transform := addTransformFlags(flags)
+	_, err := parseFlags(flags, []string{
+		"--rotate=90", "--resize=64x", "--filter=nearest", "--flip=v", "in.webp", "out.webp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transforms, err := transform.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []webpfex.Transform{
+		webpfex.Rotate{Degrees: 90},
+		webpfex.Resize{Width: 64, Kernel: webpfex.ResizeNearest},
+		webpfex.Flip{Vertical: true},
+	}
+	if !reflect.DeepEqual(transforms, expected) {
+		t.Errorf("Expecting %v, got %v", expected, transforms)
+	}
+}
+
+func TestRetimeFlags(t *testing.T) {
+	durations := filepath.Join(t.TempDir(), "durations.txt")
+	os.WriteFile(durations, []byte("3 250ms\n"), 0644)
+
+	flags := newFlagSet("retime")
+	retime := addRetimeFlags(flags)
+	_, err := parseFlags(flags, []string{"--speed=1.5", "in.webp", "--reverse", "out.webp", "--durations", durations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts webpfex.Options
+	if err := retime.apply(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Speed != 1.5 || !opts.Reverse || opts.Durations == nil ||
+		opts.Durations.Named["3"] != 250*time.Millisecond {
+		t.Errorf("Expecting a reversed 1.5 speed with frame 3 lasting 250ms, got %+v", opts)
+	}
+
+	retime.durations = filepath.Join(t.TempDir(), "missing.txt")
+	if err := retime.apply(&opts); err == nil {
+		t.Error("Expecting an error for a missing durations file")
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	cases := []struct {
+		format string
+		out    string
+		batch  bool
+		want   string
+	}{
+		{"", "out.webp", false, formatWebp},
+		{"", "OUT.MP4", false, formatMp4},
+		{"", "frames", false, formatPng},
+		{"", "out.webp", true, formatPng},
+		{"webp", "outdir", true, formatWebp},
+	}
+	for _, c := range cases {
+		if got, err := outputFormat(c.format, c.out, c.batch); err != nil || got != c.want {
+			t.Errorf("%+v: expecting %s, got %s %v", c, c.want, got, err)
+		}
+	}
+}
+
+func TestProgressFraction(t *testing.T) {
+	r, err := makeProgressReporter("none", formatMp4, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compositing and encoding alternate frame by frame.
+	last := 0.0
+	for frame := uint32(1); frame <= 4; frame++ {
+		for _, stage := range []webpfex.Stage{webpfex.StageComposite, webpfex.StageEncode} {
+			r.Report(webpfex.Progress{Stage: stage, Frame: frame, FrameCount: 4})
+			if fraction := r.fraction(); fraction < last {
+				t.Errorf("Frame %d %s: expecting progress to grow from %f, got %f",
+					frame, stage, last, fraction)
+			} else {
+				last = fraction
+			}
+		}
+	}
+	if last != 0.5 {
+		t.Errorf("Expecting half done before ffmpeg, got %f", last)
+	}
+	r.Report(webpfex.Progress{Stage: webpfex.StageFfmpeg, Frame: 4, FrameCount: 4})
+	if fraction := r.fraction(); fraction != 1 {
+		t.Errorf("Expecting all done after ffmpeg, got %f", fraction)
+	}
+}
+
+func TestCliInfo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	webp := filepath.Join(dir, "in.webp")
+	os.WriteFile(webp, []byte("RIFF\x04\x00\x00\x00WEBP"), 0644)
+	webpmux := filepath.Join(dir, "webpmux")
+	os.WriteFile(webpmux, []byte(`#!/bin/sh
+cat <<EOF
+Canvas size: 64 x 32
+Features present: animation transparency
+Background color : 0xFF336699  Loop Count : 2
+Number of frames: 2
+No.: width height alpha x_offset y_offset duration   dispose blend image_size  compression
+  1:    64    32   yes        0        0       40       none    no        120    lossless
+  2:    16     8   yes       10        4       60 background   yes         80    lossless
+EOF
+`), 0755)
+
+	code, stdout, stderr := runCli(t, "info", "--json", "--webpmux="+webpmux, webp)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	var info struct {
+		Width      uint32 `json:"width"`
+		LoopCount  uint16 `json:"loop_count"`
+		Background string `json:"background_color"`
+		DurationMs int64  `json:"duration_ms"`
+		Frames     []struct {
+			XOffset uint32 `json:"x_offset"`
+			Dispose bool   `json:"dispose"`
+		} `json:"frames"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 64 || info.LoopCount != 2 || info.Background != "#336699FF" ||
+		info.DurationMs != 100 ||
+		len(info.Frames) != 2 || info.Frames[1].XOffset != 10 || !info.Frames[1].Dispose {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	code, stdout, _ = runCli(t, "info", "--webpmux="+webpmux, webp)
+	if code != exitOk || !strings.Contains(stdout, "loops     2 times") {
+		t.Errorf("Expecting the loop count printed, got %q", stdout)
+	}
+}
+
+func TestCliImport(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.gif")
+	palette := color.Palette{color.Black, color.White}
+	g := gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+			image.NewPaletted(image.Rect(1, 1, 3, 3), palette),
+		},
+		Delay: []int{5, 20},
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, &g); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(in, b.Bytes(), 0644)
+
+	out := filepath.Join(dir, "out.webp")
+	code, _, stderr := runCli(t, "import", "--quiet", "--loops=2", in, out)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	chunks, err := webpfex.ReadWebpChunks(out)
+	if err != nil || len(chunks) != 4 || chunks[3].FourCC != "ANMF" {
+		t.Errorf("Expecting an animation of 2 frames: %v", err)
+	}
+}
+
+func TestCliLint(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.gif")
+	palette := color.Palette{color.Black, color.White}
+	g := gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+			image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+		},
+		Delay: []int{5, 5},
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, &g); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(in, b.Bytes(), 0644)
+	webp := filepath.Join(dir, "in.webp")
+	if code, _, stderr := runCli(t, "import", "--quiet", in, webp); code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+
+	missing := "--webpmux=" + filepath.Join(dir, "webpmux")
+	code, stdout, stderr := runCli(t, "lint", missing, webp)
+	if code != exitOk || !strings.Contains(stdout, "0 errors, 0 warnings") {
+		t.Errorf("Expecting no problems, got exit code %d: %s%s", code, stdout, stderr)
+	}
+
+	data, _ := os.ReadFile(webp)
+	truncated := filepath.Join(dir, "truncated.webp")
+	os.WriteFile(truncated, data[:len(data)-10], 0644)
+	code, stdout, _ = runCli(t, "lint", "--json", missing, truncated)
+	if code != exitFailure {
+		t.Errorf("Expecting exit code %d, got %d", exitFailure, code)
+	}
+	var report struct {
+		Errors   int
+		Findings []struct {
+			Severity string
+			Check    string
+			Offset   *int64
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("%v: %s", err, stdout)
+	}
+	checks := map[string]bool{}
+	for _, f := range report.Findings {
+		checks[f.Check] = true
+	}
+	if report.Errors == 0 || !checks["truncated-file"] || !checks["webpmux"] {
+		t.Errorf("Expecting the truncation to be reported, got %+v", report)
+	}
+}
+
+func TestCliChunks(t *testing.T) {
+	dir := t.TempDir()
+	frames := filepath.Join(dir, "frames")
+	os.Mkdir(frames, 0755)
+	for _, name := range []string{"1.png", "2.png"} {
+		if err := webpfex.SavePng(canvas.MakeCanvas(4, 4), filepath.Join(frames, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webp := filepath.Join(dir, "in.webp")
+	if code, _, stderr := runCli(t, "assemble", "--quiet", frames, webp); code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+
+	code, stdout, _ := runCli(t, "chunks", webp)
+	if code != exitOk || !strings.Contains(stdout, "flags=alpha,animation canvas=4x4") ||
+		!strings.Contains(stdout, "  VP8L") {
+		t.Errorf("Expecting the chunks listed with their fields, got exit code %d: %s", code, stdout)
+	}
+
+	exif := filepath.Join(dir, "exif")
+	os.WriteFile(exif, []byte("exif"), 0644)
+	with := filepath.Join(dir, "with.webp")
+	if code, _, stderr := runCli(t, "chunks", "replace", "EXIF", exif, webp, with); code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	extracted := filepath.Join(dir, "extracted")
+	if code, _, stderr := runCli(t, "chunks", "extract", "EXIF", with, extracted); code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	if data, _ := os.ReadFile(extracted); string(data) != "exif" {
+		t.Errorf("Expecting the EXIF payload extracted, got %q", data)
+	}
+
+	code, _, stderr := runCli(t, "chunks", "drop", "--overwrite", "EXIF", with, with)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	code, stdout, _ = runCli(t, "chunks", "--json", with)
+	var listing struct {
+		Chunks []struct {
+			FourCC string
+			Fields map[string]string
+			Chunks []struct{ FourCC string }
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &listing); err != nil || code != exitOk {
+		t.Fatalf("Expecting a JSON listing, got exit code %d: %v", code, err)
+	}
+	if len(listing.Chunks) != 4 || listing.Chunks[0].Fields["flags"] != "alpha,animation" ||
+		len(listing.Chunks[3].Chunks) == 0 {
+		t.Errorf("Expecting the EXIF chunk and flag dropped, got %+v", listing)
+	}
+
+	if code, _, _ := runCli(t, "chunks", "extract", "EXIF", with, filepath.Join(dir, "none")); code != exitFailure {
+		t.Errorf("Expecting exit code %d extracting a missing chunk, got %d", exitFailure, code)
+	}
+}
+
+func TestCliRepair(t *testing.T) {
+	dir := t.TempDir()
+	frames := filepath.Join(dir, "frames")
+	os.Mkdir(frames, 0755)
+	for _, name := range []string{"1.png", "2.png", "3.png"} {
+		if err := webpfex.SavePng(canvas.MakeCanvas(4, 4), filepath.Join(frames, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webp := filepath.Join(dir, "in.webp")
+	if code, _, stderr := runCli(t, "assemble", "--quiet", frames, webp); code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	data, _ := os.ReadFile(webp)
+	truncated := filepath.Join(dir, "truncated.webp")
+	os.WriteFile(truncated, data[:len(data)-6], 0644)
+
+	code, stdout, stderr := runCli(t, "repair", truncated)
+	if code != exitOk || !strings.Contains(stdout, "dropped ANMF at offset") ||
+		!strings.Contains(stdout, "frame 3: cut off") || !strings.Contains(stdout, "recovered 2 frames") {
+		t.Errorf("Expecting the last frame reported dropped, got exit code %d: %s%s", code, stdout, stderr)
+	}
+	if after, _ := os.ReadFile(truncated); len(after) != len(data)-6 {
+		t.Error("Expecting the input untouched without an output")
+	}
+
+	code, stdout, stderr = runCli(t, "repair", "--json", "--overwrite", truncated, truncated)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	var report struct {
+		FrameCount int `json:"frame_count"`
+		Dropped    []struct{ Frame int }
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("%v: %s", err, stdout)
+	}
+	if report.FrameCount != 2 || len(report.Dropped) != 1 || report.Dropped[0].Frame != 3 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	chunks, err := webpfex.ReadWebpChunks(truncated)
+	if err != nil || len(chunks) != 4 {
+		t.Errorf("Expecting a repaired file of 2 frames, got %d chunks: %v", len(chunks), err)
+	}
+
+	os.WriteFile(truncated, data[:40], 0644)
+	if code, _, _ := runCli(t, "repair", truncated); code != exitInput {
+		t.Errorf("Expecting exit code %d without a frame to recover, got %d", exitInput, code)
+	}
+}
+
+func TestCliAssemble(t *testing.T) {
+	dir := t.TempDir()
+	frames := filepath.Join(dir, "frames")
+	os.Mkdir(frames, 0755)
+	for _, name := range []string{"1.png", "2.png"} {
+		if err := webpfex.SavePng(canvas.MakeCanvas(4, 4), filepath.Join(frames, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timing := filepath.Join(dir, "timing.txt")
+	os.WriteFile(timing, []byte("2.png 250ms\n"), 0644)
+
+	out := filepath.Join(dir, "out.webp")
+	code, _, stderr := runCli(t, "assemble", "--quiet", "--optimize", "--timing="+timing, frames, out)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	chunks, err := webpfex.ReadWebpChunks(out)
+	if err != nil || len(chunks) != 4 || chunks[3].FourCC != "ANMF" {
+		t.Errorf("Expecting an animation of 2 frames: %v", err)
+	}
+
+	code, _, stderr = runCli(t, "assemble", "--verbose", "--progress=none", "--overwrite",
+		"--optimize", frames, out)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	if !strings.Contains(stderr, "stored 2 of 32 pixels in 2 frames, 94% less") {
+		t.Errorf("Expecting the pixels --optimize saved with --verbose, got %q", stderr)
+	}
+
+	// The timing file extract writes is read by default.
+	os.WriteFile(filepath.Join(frames, webpfex.TimingSidecar), []byte("1.png 300ms\n"), 0644)
+	code, _, stderr = runCli(t, "assemble", "--quiet", "--overwrite", frames, out)
+	if code != exitOk {
+		t.Fatalf("Expecting exit code %d, got %d: %s", exitOk, code, stderr)
+	}
+	chunks, err = webpfex.ReadWebpChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anmf, err := webpfex.ParseAnmfHeader(chunks[2].Payload); err != nil ||
+		anmf.Duration != 300*time.Millisecond {
+		t.Errorf("Expecting the first frame timed by timing.txt, got %+v: %v", anmf, err)
+	}
+}
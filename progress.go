@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"webpfex/webpfex"
+)
+
+const progressBarWidth = 30
+
+// Renders webpfex.Progress updates for the user.
+type progressReporter struct {
+	mode   string
+	ffmpeg bool // Whether ffmpeg encodes once frames are composited.
+	// Completion in [0, 1] of each stage reported so far.
+	done    map[webpfex.Stage]float64
+	out     io.Writer
+	start   time.Time
+	drawn   bool
+	lastBar time.Time
+}
+
+// Make a reporter for mode, one of auto, bar, json or none, for the stages
+// producing format. Progress bars go to stderr and JSON lines go to stdout.
+func makeProgressReporter(
+	mode string,
+	format string,
+	stdout, stderr io.Writer,
+) (*progressReporter, error) {
+	r := progressReporter{
+		mode:   mode,
+		ffmpeg: format == formatMp4,
+		done:   map[webpfex.Stage]float64{},
+		start:  time.Now(),
+	}
+	switch mode {
+	case "auto":
+		r.mode = "none"
+		if isTerminal(stderr) {
+			r.mode = "bar"
+		}
+		r.out = stderr
+	case "bar":
+		r.out = stderr
+	case "json":
+		r.out = stdout
+	case "none":
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+
+	return &r, nil
+}
+
+func (r *progressReporter) Report(p webpfex.Progress) {
+	if p.FrameCount > 0 {
+		stage := float64(p.Frame) / float64(p.FrameCount)
+		if stage > 1 {
+			stage = 1
+		}
+		r.done[p.Stage] = stage
+	}
+
+	switch r.mode {
+	case "bar":
+		r.drawBar(p)
+	case "json":
+		r.writeJson(p)
+	}
+}
+
+// End the progress output, leaving the cursor on a fresh line.
+func (r *progressReporter) Finish() {
+	if r.mode == "bar" && r.drawn {
+		fmt.Fprintln(r.out)
+	}
+}
+
+func (r *progressReporter) drawBar(p webpfex.Progress) {
+	// Redrawing on every frame makes the terminal flicker on fast stages.
+	done := p.Frame == p.FrameCount
+	if !done && time.Since(r.lastBar) < 100*time.Millisecond {
+		return
+	}
+	r.lastBar = time.Now()
+
+	fraction := r.fraction()
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+
+	eta := "--:--"
+	if elapsed := time.Since(r.start); fraction > 0 {
+		remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		eta = formatClock(remaining)
+	}
+
+	fmt.Fprintf(r.out, "\r[%s] %3.0f%% %-9s %d/%d ETA %s",
+		bar, fraction*100, p.Stage, p.Frame, p.FrameCount, eta)
+	r.drawn = true
+}
+
+func (r *progressReporter) writeJson(p webpfex.Progress) {
+	line, _ := json.Marshal(struct {
+		Stage      string  `json:"stage"`
+		Frame      uint32  `json:"frame"`
+		FrameCount uint32  `json:"frame_count"`
+		OutTimeMs  int64   `json:"out_time_ms,omitempty"`
+		Fraction   float64 `json:"fraction"`
+		ElapsedMs  int64   `json:"elapsed_ms"`
+	}{
+		Stage:      p.Stage.String(),
+		Frame:      p.Frame,
+		FrameCount: p.FrameCount,
+		OutTimeMs:  p.OutTime.Milliseconds(),
+		Fraction:   r.fraction(),
+		ElapsedMs:  time.Since(r.start).Milliseconds(),
+	})
+	fmt.Fprintln(r.out, string(line))
+}
+
+// Overall completion in [0, 1]. Frames are composited and encoded one after
+// the other, so that loop is as far as the frames done with both stages
+// reported, then weighs as much as ffmpeg encoding them if it follows.
+func (r *progressReporter) fraction() float64 {
+	loop := -1.0
+	for _, s := range []webpfex.Stage{webpfex.StageComposite, webpfex.StageEncode} {
+		if done, ok := r.done[s]; ok && (loop < 0 || done < loop) {
+			loop = done
+		}
+	}
+	if loop < 0 {
+		loop = 0
+	}
+
+	if !r.ffmpeg {
+		return loop
+	}
+	return (loop + r.done[webpfex.StageFfmpeg]) / 2
+}
+
+// Format d as MM:SS, or HH:MM:SS when it exceeds an hour.
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	}
+
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
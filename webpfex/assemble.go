@@ -0,0 +1,240 @@
+package webpfex
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"webpfex/encode"
+
+	_ "image/jpeg"
+)
+
+// Timing gives the durations of a sequence of frames.
+type Timing struct {
+	Default   time.Duration            // Of frames not given one otherwise.
+	Durations []time.Duration          // Of the first frames, in order.
+	Named     map[string]time.Duration // Of frames by file name.
+}
+
+// Duration of the ith frame, from 0, read from the file name.
+func (t Timing) Duration(i int, name string) time.Duration {
+	if d, ok := t.Lookup(i, name); ok {
+		return d
+	}
+	return t.Default
+}
+
+// Like Duration but false rather than the default for frames not given one.
+func (t Timing) Lookup(i int, name string) (time.Duration, bool) {
+	if d, ok := t.Named[name]; ok {
+		return d, true
+	}
+	if i < len(t.Durations) {
+		return t.Durations[i], true
+	}
+	return 0, false
+}
+
+// Parse a timing file, of one frame per line given as a duration for the
+// frames in order, or as a file name followed by a duration. Durations are
+// like 40ms or 1.5s, or numbers of milliseconds. Blank lines and lines starting
+// with # are skipped.
+func ParseTiming(r io.Reader, defaultDuration time.Duration) (Timing, error) {
+	timing := Timing{Default: defaultDuration, Named: map[string]time.Duration{}}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := ""
+		field := line
+		if i := strings.LastIndexFunc(line, unicode.IsSpace); i >= 0 {
+			name = strings.TrimSpace(line[:i])
+			field = line[i+1:]
+		}
+		d, err := ParseFrameDuration(field)
+		if err != nil {
+			return Timing{}, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if name == "" {
+			timing.Durations = append(timing.Durations, d)
+		} else {
+			timing.Named[name] = d
+		}
+	}
+
+	return timing, scanner.Err()
+}
+
+// Name of the timing file extract writes next to the frames, which assemble
+// reads by default.
+const TimingSidecar = "timing.txt"
+
+// Write the names and durations of frames as the timing file TimingSidecar in
+// dir, returning its path.
+func writeTimingSidecar(dir string, frames []sequenceFrame, opts Options) (string, error) {
+	var timing strings.Builder
+	for _, f := range frames {
+		fmt.Fprintf(&timing, "%s %v\n", f.Name, f.Duration)
+	}
+
+	p := filepath.Join(dir, TimingSidecar)
+	if err := checkOverwrite(p, opts); err != nil {
+		return "", err
+	}
+	return p, os.WriteFile(p, []byte(timing.String()), 0644)
+}
+
+// Parse a frame duration like 40ms or 1.5s, or a number of milliseconds.
+func ParseFrameDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q, expecting milliseconds or like 40ms", s)
+	}
+	return d, nil
+}
+
+// Whether a sorts before b, comparing runs of digits by their value so that
+// frame2 comes before frame10.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits := leadingDigits(a)
+		bDigits := leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aValue := strings.TrimLeft(aDigits, "0")
+			bValue := strings.TrimLeft(bDigits, "0")
+			if len(aValue) != len(bValue) {
+				return len(aValue) < len(bValue)
+			}
+			if aValue != bValue {
+				return aValue < bValue
+			}
+			if len(aDigits) != len(bDigits) {
+				return len(aDigits) < len(bDigits)
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// Extensions of the images assembled.
+var frameExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".webp": true}
+
+// The images in dir that AssembleAWebp reads, in natural sort order.
+func FrameFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && frameExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+
+	return names, nil
+}
+
+func AssembleAWebp(dir string, out string, timing Timing) error {
+	return AssembleAWebpContext(context.Background(), dir, out, timing, 0, DefaultOptions())
+}
+
+// Encode the PNG, JPEG and WEBP images in dir, in natural sort order, as the
+// frames of an animated WEBP at out looping loopCount times, 0 meaning
+// forever, timed by timing. Images must all be the same size. Gives up once ctx
+// is done, removing the partial output.
+func AssembleAWebpContext(
+	ctx context.Context,
+	dir string,
+	out string,
+	timing Timing,
+	loopCount uint16,
+	opts Options,
+) error {
+	names, err := FrameFiles(dir)
+	if err != nil {
+		return err
+	}
+	// Not when overwriting a previous output.
+	for i, name := range names {
+		if filepath.Clean(filepath.Join(dir, name)) == filepath.Clean(out) {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no PNG, JPEG or WEBP images in %s", dir)
+	}
+	first, err := LoadWebp(filepath.Join(dir, names[0]))
+	if err != nil {
+		return err
+	}
+
+	options := encode.AnimationOptions{
+		Width:     first.Width(),
+		Height:    first.Height(),
+		LoopCount: loopCount,
+	}
+	return writeAWebp(ctx, out, options, opts, func(w encode.FrameWriter) error {
+		for i, name := range names {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			cv, err := LoadWebp(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			if cv.Width() != first.Width() || cv.Height() != first.Height() {
+				return fmt.Errorf("%s is %dx%d, %s is %dx%d", name, cv.Width(), cv.Height(),
+					names[0], first.Width(), first.Height())
+			}
+
+			frame := encode.Frame{Canvas: cv, Duration: timing.Duration(i, name)}
+			if err := w.WriteFrame(frame); err != nil {
+				return err
+			}
+			opts.report(Progress{
+				Stage:      StageEncode,
+				Frame:      uint32(i + 1),
+				FrameCount: uint32(len(names)),
+			})
+		}
+
+		return w.Close()
+	})
+}
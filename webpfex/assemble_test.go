@@ -0,0 +1,136 @@
+package webpfex
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"webpfex/canvas"
+)
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"frame10.png", "frame2.png", "frame02.png", "b.png", "frame1.png", "a10b2", "a10b10", "a9"}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+	expected := []string{"a9", "a10b2", "a10b10", "b.png", "frame1.png", "frame2.png", "frame02.png", "frame10.png"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expecting %v, got %v", expected, names)
+	}
+}
+
+func TestParseTiming(t *testing.T) {
+	timing, err := ParseTiming(strings.NewReader(`# Timing
+40
+1.5s
+
+my frame.png 120ms
+`), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	durations := []time.Duration{
+		timing.Duration(0, "a.png"),
+		timing.Duration(1, "b.png"),
+		timing.Duration(2, "my frame.png"),
+		timing.Duration(3, "d.png"),
+	}
+	expected := []time.Duration{
+		40 * time.Millisecond, 1500 * time.Millisecond, 120 * time.Millisecond, 100 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting %v, got %v", expected, durations)
+	}
+
+	if _, err := ParseTiming(strings.NewReader("a.png soon\n"), 0); err == nil {
+		t.Error("Expecting an invalid duration error")
+	}
+}
+
+func TestTimingSidecar(t *testing.T) {
+	dir := t.TempDir()
+	frames := []sequenceFrame{{"000000001.png", 40 * time.Millisecond}, {"000000002.png", 1500 * time.Millisecond}}
+	p, err := writeTimingSidecar(dir, frames, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	timing, err := ParseTiming(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range frames {
+		if d, ok := timing.Lookup(i, f.Name); !ok || d != f.Duration {
+			t.Errorf("%s: expecting %v read back, got %v", f.Name, f.Duration, d)
+		}
+	}
+	if names, _ := FrameFiles(dir); len(names) != 0 {
+		t.Errorf("Expecting the timing file not to be taken for a frame, got %v", names)
+	}
+}
+
+func TestAssembleAWebp(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"f10.png", "f9.png", "f1.png"} {
+		cv := canvas.MakeCanvas(6, 4)
+		ClearCanvas(&cv, canvas.MakeColorRgba(uint16(i)*0x4040, 0, 0, 0xFFFF))
+		if err := SavePng(cv, path.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not a frame.
+	os.WriteFile(path.Join(dir, "timing.txt"), []byte("f9.png 70\n"), 0644)
+
+	out := path.Join(dir, "out.webp")
+	timing := Timing{Default: 40 * time.Millisecond, Named: map[string]time.Duration{"f9.png": 70 * time.Millisecond}}
+	if err := AssembleAWebp(dir, out, timing); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := ReadWebpChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var durations []uint32
+	for _, c := range chunks {
+		if c.FourCC == "ANMF" {
+			durations = append(durations, uint32(c.Payload[12])|uint32(binary.LittleEndian.Uint16(c.Payload[13:]))<<8)
+		}
+	}
+	if expected := []uint32{40, 70, 40}; !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting frames lasting %v, got %v", expected, durations)
+	}
+
+	// Refuses to overwrite unless allowed to, and skips its previous output.
+	opts := DefaultOptions()
+	opts.Overwrite = false
+	if err := AssembleAWebpContext(context.Background(), dir, out, timing, 0, opts); err == nil {
+		t.Error("Expecting an error overwriting the output")
+	}
+	opts.Overwrite = true
+	if err := AssembleAWebpContext(context.Background(), dir, out, timing, 0, opts); err != nil {
+		t.Error(err)
+	}
+	// Like the plain function, which replaces outputs.
+	if err := AssembleAWebp(dir, out, timing); err != nil {
+		t.Error(err)
+	}
+
+	odd := canvas.MakeCanvas(2, 2)
+	SavePng(odd, path.Join(dir, "f20.png"))
+	if err := AssembleAWebpContext(context.Background(), dir, out, timing, 0, opts); err == nil {
+		t.Error("Expecting an error for frames of different sizes")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("Expecting the partial output removed")
+	}
+}
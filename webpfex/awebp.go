@@ -0,0 +1,132 @@
+package webpfex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+// Create the animated WEBP out, described by options, from the frames produce
+// writes then closes, which opts retimes and encodes. out is removed on
+// failure.
+func writeAWebp(
+	ctx context.Context,
+	out string,
+	options encode.AnimationOptions,
+	opts Options,
+	produce func(w encode.FrameWriter) error,
+) (err error) {
+	if err := checkOverwrite(out, opts); err != nil {
+		return err
+	}
+	if opts.Lossy {
+		// Fail before producing every frame for nothing.
+		if _, err := opts.Tools.Lookup(ToolFfmpeg); err != nil {
+			return err
+		}
+		dir, err := os.MkdirTemp("", "webpfex")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		options.Encoder = ffmpegFrameEncoder(ctx, dir, opts)
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+
+	var writer encode.FrameWriter = encode.MakeAnimationWriter(file, options)
+	if opts.Optimize {
+		writer = encode.MakeDeltaWriter(writer)
+	}
+	if err := produce(timingWriter(retimingWriter(writer, opts), opts)); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// A FrameEncoder encoding frames lossily at opts.Quality with ffmpeg's
+// libwebp, through files in dir.
+func ffmpegFrameEncoder(ctx context.Context, dir string, opts Options) encode.FrameEncoder {
+	in := path.Join(dir, "frame.png")
+	out := path.Join(dir, "frame.webp")
+	return func(cv canvas.Canvas) ([]byte, bool, error) {
+		if err := SavePng(cv, in); err != nil {
+			return nil, false, err
+		}
+		_, err := runCommand(ctx, opts, ToolFfmpeg,
+			"-y",
+			"-loglevel", "error",
+			"-i", in,
+			"-c:v", "libwebp",
+			"-lossless", "0",
+			"-quality", strconv.Itoa(opts.Quality),
+			"-pix_fmt", "yuva420p",
+			"-f", "webp",
+			out)
+		if err != nil {
+			return nil, false, err
+		}
+
+		data, err := os.ReadFile(out)
+		if err != nil {
+			return nil, false, err
+		}
+		chunks, err := ParseWebpChunks(data)
+		if err != nil {
+			return nil, false, err
+		}
+		return imageChunks(chunks)
+	}
+}
+
+// The image chunks of a still WEBP, as they go in an ANMF chunk, and whether
+// the image uses alpha.
+func imageChunks(chunks []Chunk) ([]byte, bool, error) {
+	var data bytes.Buffer
+	alpha := false
+	found := false
+	for _, c := range chunks {
+		switch c.FourCC {
+		case "ALPH":
+			alpha = true
+		case "VP8L":
+			// The alpha_is_used bit of the VP8L header.
+			alpha = alpha || len(c.Payload) > 4 && c.Payload[4]&0x10 != 0
+			found = true
+		case "VP8 ":
+			found = true
+		default:
+			continue
+		}
+
+		var header [8]byte
+		copy(header[:4], c.FourCC)
+		binary.LittleEndian.PutUint32(header[4:], c.Size)
+		data.Write(header[:])
+		data.Write(c.Payload)
+		if c.Size%2 == 1 {
+			data.WriteByte(0)
+		}
+	}
+	if !found {
+		return nil, false, errors.New("encoded frame has no image data")
+	}
+
+	return data.Bytes(), alpha, nil
+}
@@ -0,0 +1,30 @@
+package webpfex
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestImageChunks(t *testing.T) {
+	chunks := []Chunk{
+		{FourCC: "VP8X", Size: 10, Payload: make([]byte, 10)},
+		{FourCC: "ALPH", Size: 3, Payload: []byte{1, 2, 3}},
+		{FourCC: "VP8 ", Size: 2, Payload: []byte{4, 5}},
+		{FourCC: "EXIF", Size: 2, Payload: []byte{6, 7}},
+	}
+	data, alpha, err := imageChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte("ALPH\x03\x00\x00\x00\x01\x02\x03\x00VP8 \x02\x00\x00\x00\x04\x05")
+	if !bytes.Equal(data, expected) || !alpha {
+		t.Errorf("Expecting %q with alpha, got %q %t", expected, data, alpha)
+	}
+
+	if _, alpha, _ := imageChunks(chunks[2:3]); alpha {
+		t.Error("Expecting no alpha without ALPH chunk")
+	}
+	if _, _, err := imageChunks(chunks[:2]); err == nil {
+		t.Error("Expecting an error without image data")
+	}
+}
@@ -0,0 +1,147 @@
+package webpfex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"webpfex/canvas"
+)
+
+// Background is what flattening composites frames over, leaving them opaque
+// for formats without alpha.
+type Background interface {
+	// The opaque background of frames of width x height of the animation info
+	// describes.
+	Render(info AWebpInfo, width, height uint32) canvas.Canvas
+}
+
+// SolidBackground is a single color, whose alpha is ignored.
+type SolidBackground struct {
+	Color canvas.Color
+}
+
+func (b SolidBackground) Render(info AWebpInfo, width, height uint32) canvas.Canvas {
+	cv := canvas.MakeCanvas(width, height)
+	r, g, blue, _ := b.Color.Rgba()
+	ClearCanvas(&cv, canvas.MakeColorRgba(r, g, blue, 0xFFFF))
+	return cv
+}
+
+// FileBackground is the background color the animation declares, whose alpha
+// is ignored.
+type FileBackground struct{}
+
+func (FileBackground) Render(info AWebpInfo, width, height uint32) canvas.Canvas {
+	return SolidBackground{info.BackgroundColor}.Render(info, width, height)
+}
+
+// CheckerboardBackground alternates light and dark gray squares of Size
+// pixels, the way image editors show transparency.
+type CheckerboardBackground struct {
+	Size uint32
+}
+
+func (b CheckerboardBackground) Render(info AWebpInfo, width, height uint32) canvas.Canvas {
+	light := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF)
+	dark := canvas.MakeColorRgba(0xCCCC, 0xCCCC, 0xCCCC, 0xFFFF)
+	size := b.Size
+	if size == 0 {
+		size = 1
+	}
+
+	cv := canvas.MakeCanvas(width, height)
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			color := light
+			if (x/size+y/size)%2 == 1 {
+				color = dark
+			}
+			cv.WriteAt(x, y, color)
+		}
+	}
+	return cv
+}
+
+// ImageBackground is an image stretched to the size of frames, over black
+// where it's transparent.
+type ImageBackground struct {
+	Canvas canvas.Canvas
+}
+
+func (b ImageBackground) Render(info AWebpInfo, width, height uint32) canvas.Canvas {
+	img := b.Canvas
+	if img.Width() != width || img.Height() != height {
+		img = ResizeCanvas(img, width, height, ResizeCatmullRom)
+	}
+
+	cv := SolidBackground{canvas.MakeColor(0)}.Render(info, width, height)
+	OverlayBlendCanvas(&cv, &img, 0, 0)
+	return cv
+}
+
+// Parse a background given as file for the animation's own color,
+// checkerboard, a color like #RRGGBB, #RRGGBBAA, black or white, or else the
+// path of a PNG or WEBP image.
+func ParseBackground(s string) (Background, error) {
+	switch s {
+	case "":
+		return nil, fmt.Errorf("empty background")
+	case "file":
+		return FileBackground{}, nil
+	case "checkerboard":
+		return CheckerboardBackground{16}, nil
+	}
+	if color, ok := ParseColor(s); ok {
+		return SolidBackground{color}, nil
+	}
+
+	cv, err := LoadWebp(s)
+	if err != nil {
+		return nil, fmt.Errorf("background %q isn't a color nor a readable image: %w", s, err)
+	}
+	return ImageBackground{cv}, nil
+}
+
+var namedColors = map[string]canvas.Color{
+	"black": canvas.MakeColorRgba(0, 0, 0, 0xFFFF),
+	"white": canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF),
+}
+
+// Parse a color like #RRGGBB or #RRGGBBAA, or black or white.
+func ParseColor(s string) (canvas.Color, bool) {
+	if color, ok := namedColors[strings.ToLower(s)]; ok {
+		return color, true
+	}
+	if !regexp.MustCompile(`^#([\dA-Fa-f]{6}|[\dA-Fa-f]{8})$`).MatchString(s) {
+		return canvas.Color{}, false
+	}
+
+	hex := s[1:]
+	if len(hex) == 6 {
+		hex += "FF"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return canvas.Color{}, false
+	}
+	channel := func(shift uint) uint16 { return uint16(v>>shift&0xFF) * 0x101 }
+	return canvas.MakeColorRgba(channel(24), channel(16), channel(8), channel(0)), true
+}
+
+// Format color as #RRGGBBAA.
+func FormatColor(color canvas.Color) string {
+	r, g, b, a := color.Rgba()
+	return fmt.Sprintf("#%02X%02X%02X%02X", r>>8, g>>8, b>>8, a>>8)
+}
+
+// Composite cv over background with the blend mode of opts.
+func flatten(cv *canvas.Canvas, background *canvas.Canvas, opts Options) canvas.Canvas {
+	flat := background.Clone()
+	if opts.Blend == BlendLinear {
+		OverlayBlendCanvasLinear(&flat, cv, 0, 0)
+	} else {
+		OverlayBlendCanvas(&flat, cv, 0, 0)
+	}
+	return flat
+}
@@ -0,0 +1,95 @@
+package webpfex
+
+import (
+	"os"
+	"path"
+	"testing"
+	"webpfex/canvas"
+)
+
+func TestParseColor(t *testing.T) {
+	cases := map[string]canvas.Color{
+		"#FF8000":   canvas.MakeColorRgba(0xFFFF, 0x8080, 0, 0xFFFF),
+		"#ff800040": canvas.MakeColorRgba(0xFFFF, 0x8080, 0, 0x4040),
+		"White":     canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF),
+	}
+	for s, expected := range cases {
+		if color, ok := ParseColor(s); !ok || color != expected {
+			t.Errorf("%s: expecting %s, got %s", s, FormatColor(expected), FormatColor(color))
+		}
+	}
+
+	for _, s := range []string{"FF8000", "#FF80", "#GG8000", "pink"} {
+		if _, ok := ParseColor(s); ok {
+			t.Errorf("%s: expecting an invalid color", s)
+		}
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	image := path.Join(t.TempDir(), "bg.png")
+	if err := SavePng(canvas.MakeCanvas(2, 2), image); err != nil {
+		t.Fatal(err)
+	}
+
+	for s, expected := range map[string]Background{
+		"file":         FileBackground{},
+		"checkerboard": CheckerboardBackground{16},
+		"#000000":      SolidBackground{canvas.MakeColorRgba(0, 0, 0, 0xFFFF)},
+	} {
+		if background, err := ParseBackground(s); err != nil || background != expected {
+			t.Errorf("%s: expecting %+v, got %+v %v", s, expected, background, err)
+		}
+	}
+
+	if background, err := ParseBackground(image); err != nil {
+		t.Error(err)
+	} else if _, ok := background.(ImageBackground); !ok {
+		t.Errorf("Expecting an image background, got %+v", background)
+	}
+	if _, err := ParseBackground(path.Join(os.TempDir(), "missing.png")); err == nil {
+		t.Error("Expecting an error for a missing image")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	var info AWebpInfo
+	info.BackgroundColor = canvas.MakeColorRgba(0, 0, 0xFFFF, 0)
+
+	cv := canvas.MakeCanvas(4, 4)
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+	cv.WriteAt(1, 1, red)
+	cv.WriteAt(2, 2, canvas.MakeColorRgba(0xFFFF, 0, 0, 0x8080))
+
+	backgrounds := []Background{
+		FileBackground{},
+		CheckerboardBackground{2},
+		ImageBackground{canvas.MakeCanvas(1, 1)},
+	}
+	for _, background := range backgrounds {
+		rendered := background.Render(info, 4, 4)
+		flat := flatten(&cv, &rendered, Options{})
+		for y := uint32(0); y < 4; y++ {
+			for x := uint32(0); x < 4; x++ {
+				if flat.At(x, y).A() != 0xFFFF {
+					t.Errorf("%+v: expecting %d,%d opaque", background, x, y)
+				}
+			}
+		}
+		if flat.At(1, 1) != red {
+			t.Errorf("%+v: expecting opaque pixels kept", background)
+		}
+		if flat.At(0, 0) != rendered.At(0, 0) {
+			t.Errorf("%+v: expecting transparent pixels to show the background", background)
+		}
+	}
+
+	file := FileBackground{}.Render(info, 1, 1)
+	if c := file.At(0, 0); c != canvas.MakeColorRgba(0, 0, 0xFFFF, 0xFFFF) {
+		t.Errorf("Expecting the file's color made opaque, got %s", FormatColor(c))
+	}
+	checkerboard := CheckerboardBackground{2}.Render(info, 4, 4)
+	if checkerboard.At(0, 0) == checkerboard.At(2, 0) || checkerboard.At(0, 0) != checkerboard.At(3, 3) {
+		t.Error("Expecting alternating squares")
+	}
+}
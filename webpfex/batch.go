@@ -0,0 +1,194 @@
+package webpfex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BatchJob is a single input processed into Output.
+type BatchJob struct {
+	Input  string
+	Output string
+}
+
+// BatchResult is the outcome of a BatchJob.
+type BatchResult struct {
+	Job     BatchJob
+	Err     error
+	Skipped bool // Input isn't an animated WEBP; Err says why.
+}
+
+// Collect a job for every WEBP among inputs, which may be files, directories or
+// glob patterns, of which only .webp files are kept. Directories are walked
+// down to their subdirectories only if recursive. Outputs are placed under
+// outdir mirroring the layout below each input directory, with the input
+// extension replaced by outExt; an empty outExt names a directory after the
+// input instead. Fails if two inputs would be written to the same output.
+func CollectBatchJobs(
+	inputs []string,
+	outdir string,
+	recursive bool,
+	outExt string,
+) ([]BatchJob, error) {
+	var jobs []BatchJob
+	seen := make(map[string]bool)
+	add := func(input, rel string) {
+		if seen[input] {
+			return
+		}
+		seen[input] = true
+
+		rel = strings.TrimSuffix(rel, filepath.Ext(rel)) + outExt
+		jobs = append(jobs, BatchJob{input, filepath.Join(outdir, rel)})
+	}
+
+	for _, pattern := range inputs {
+		matches := []string{pattern}
+		glob := strings.ContainsAny(pattern, "*?[")
+		if glob {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, input := range matches {
+			stat, err := os.Stat(input)
+			if err != nil {
+				return nil, err
+			}
+			if !stat.IsDir() {
+				if !glob || isWebpPath(input) {
+					add(input, filepath.Base(input))
+				}
+				continue
+			}
+
+			err = filepath.WalkDir(input, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if p != input && !recursive {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !isWebpPath(p) {
+					return nil
+				}
+
+				rel, err := filepath.Rel(input, p)
+				if err != nil {
+					return err
+				}
+				add(p, rel)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Output < jobs[j].Output })
+	for i := 1; i < len(jobs); i++ {
+		if jobs[i].Output == jobs[i-1].Output {
+			return nil, fmt.Errorf("%s and %s would both be written to %s",
+				jobs[i-1].Input, jobs[i].Input, jobs[i].Output)
+		}
+	}
+	return jobs, nil
+}
+
+func isWebpPath(p string) bool {
+	return strings.EqualFold(filepath.Ext(p), ".webp")
+}
+
+// Run process over jobs on up to workers goroutines, creating each output's
+// parent directory beforehand. Inputs that aren't animated WEBPs are skipped.
+// No new job is started once ctx is done. Results are in the order of jobs;
+// onResult, if not nil, is called as soon as each one is known.
+func RunBatch(
+	ctx context.Context,
+	jobs []BatchJob,
+	workers int,
+	process func(ctx context.Context, job BatchJob) error,
+	onResult func(BatchResult),
+) []BatchResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]BatchResult, len(jobs))
+	indices := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				result := runBatchJob(ctx, jobs[i], process)
+
+				mu.Lock()
+				results[i] = result
+				if onResult != nil {
+					onResult(result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range jobs {
+		if ctx.Err() != nil {
+			results[i] = BatchResult{Job: jobs[i], Err: ctx.Err()}
+			continue
+		}
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	return results
+}
+
+func runBatchJob(
+	ctx context.Context,
+	job BatchJob,
+	process func(ctx context.Context, job BatchJob) error,
+) BatchResult {
+	if err := ctx.Err(); err != nil {
+		return BatchResult{Job: job, Err: err}
+	}
+	if err := os.MkdirAll(filepath.Dir(job.Output), 0755); err != nil {
+		return BatchResult{Job: job, Err: err}
+	}
+
+	err := process(ctx, job)
+	return BatchResult{Job: job, Err: err, Skipped: errors.Is(err, ErrNotAnimated)}
+}
+
+// Count the succeeded, skipped and failed results.
+func SummarizeBatch(results []BatchResult) (succeeded, skipped, failed int) {
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+			skipped++
+		case r.Err != nil:
+			failed++
+		default:
+			succeeded++
+		}
+	}
+
+	return succeeded, skipped, failed
+}
@@ -0,0 +1,105 @@
+package webpfex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectBatchJobs(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a.webp", "b.WEBP", "notes.txt", "sub/c.webp"} {
+		p = filepath.Join(root, p)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := CollectBatchJobs([]string{root}, "out", true, ".mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []BatchJob{
+		{filepath.Join(root, "a.webp"), filepath.Join("out", "a.mp4")},
+		{filepath.Join(root, "b.WEBP"), filepath.Join("out", "b.mp4")},
+		{filepath.Join(root, "sub", "c.webp"), filepath.Join("out", "sub", "c.mp4")},
+	}
+	if len(jobs) != len(expected) {
+		t.Fatalf("Expecting %v got %v", expected, jobs)
+	}
+	for i := range expected {
+		if jobs[i] != expected[i] {
+			t.Errorf("Expecting %v got %v", expected[i], jobs[i])
+		}
+	}
+
+	jobs, err = CollectBatchJobs([]string{root}, "out", false, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("Expecting 2 jobs without recursion, got %v", jobs)
+	}
+
+	jobs, err = CollectBatchJobs(
+		[]string{filepath.Join(root, "sub", "*.webp")}, "out", false, ".mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Output != filepath.Join("out", "c.mp4") {
+		t.Errorf("Expecting out/c.mp4 from glob, got %v", jobs)
+	}
+
+	jobs, err = CollectBatchJobs([]string{filepath.Join(root, "*")}, "out", false, ".mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 3 {
+		t.Errorf("Expecting the WEBPs matched and sub walked, not notes.txt, got %v", jobs)
+	}
+
+	other := filepath.Join(root, "other")
+	os.Mkdir(other, 0755)
+	os.WriteFile(filepath.Join(other, "a.webp"), nil, 0644)
+	_, err = CollectBatchJobs(
+		[]string{filepath.Join(root, "a.webp"), filepath.Join(other, "a.webp")}, "out", false, ".mp4")
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("out", "a.mp4")) {
+		t.Errorf("Expecting an error for inputs sharing an output, got %v", err)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	out := t.TempDir()
+	jobs := []BatchJob{
+		{"ok.webp", filepath.Join(out, "ok.mp4")},
+		{"still.webp", filepath.Join(out, "nested", "still.mp4")},
+		{"bad.webp", filepath.Join(out, "bad.mp4")},
+	}
+
+	results := RunBatch(context.Background(), jobs, 2,
+		func(ctx context.Context, job BatchJob) error {
+			switch job.Input {
+			case "still.webp":
+				_, err := ParseAWebpInfo("No features present.")
+				return err
+			case "bad.webp":
+				return errors.New("broken")
+			}
+			return nil
+		}, nil)
+
+	succeeded, skipped, failed := SummarizeBatch(results)
+	if succeeded != 1 || skipped != 1 || failed != 1 {
+		t.Errorf("Expecting 1/1/1 got %d/%d/%d", succeeded, skipped, failed)
+	}
+	if results[2].Job.Input != "bad.webp" || results[2].Err == nil {
+		t.Errorf("Expecting results in job order, got %v", results)
+	}
+	if _, err := os.Stat(filepath.Join(out, "nested")); err != nil {
+		t.Errorf("Expecting output parent directory to be created: %v", err)
+	}
+}
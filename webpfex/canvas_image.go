@@ -6,6 +6,8 @@ import (
 	"webpfex/canvas"
 )
 
+// Convert img to a canvas of straight, not premultiplied, colors. Decoded WEBP
+// frames convert losslessly.
 func ImageToCanvas(img image.Image) canvas.Canvas {
 	// Bounds don't necessarily start at 0, yes it's hell!
 	bounds := img.Bounds()
@@ -18,21 +20,80 @@ func ImageToCanvas(img image.Image) canvas.Canvas {
 			toX := uint32(fromX - bounds.Min.X)
 			toY := uint32(fromY - bounds.Min.Y)
 
-			r, g, b, a := img.At(fromX, fromY).RGBA()
-			color := canvas.MakeColorRgba(uint16(r), uint16(g), uint16(b), uint16(a))
-
-			cv.WriteAt(toX, toY, color)
+			cv.WriteAt(toX, toY, straightColorAt(img, fromX, fromY))
 		}
 	}
 
 	return cv
 }
 
+// Color of img at x, y without going through premultiplied alpha, which would
+// lose precision, for the image types the WEBP decoder returns.
+func straightColorAt(img image.Image, x, y int) canvas.Color {
+	expand := func(v uint8) uint16 { return uint16(v) * 0x101 }
+	switch img := img.(type) {
+	case *image.NRGBA:
+		c := img.NRGBAAt(x, y)
+		return canvas.MakeColorRgba(expand(c.R), expand(c.G), expand(c.B), expand(c.A))
+	case *image.NYCbCrA:
+		c := img.NYCbCrAAt(x, y)
+		r, g, b := vp8YuvToRgb(c.Y, c.Cb, c.Cr)
+		return canvas.MakeColorRgba(expand(r), expand(g), expand(b), expand(c.A))
+	case *image.YCbCr:
+		c := img.YCbCrAt(x, y)
+		r, g, b := vp8YuvToRgb(c.Y, c.Cb, c.Cr)
+		return canvas.MakeColorRgba(expand(r), expand(g), expand(b), 0xFFFF)
+	default:
+		c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		return canvas.MakeColorRgba(c.R, c.G, c.B, c.A)
+	}
+}
+
+// Convert a lossy WEBP's limited range BT.601 color to RGB like libwebp does.
+// color.YCbCrToRGB is full range JPEG's conversion, shifting colors by up to 16.
+func vp8YuvToRgb(y, u, v uint8) (uint8, uint8, uint8) {
+	mulHi := func(v uint8, coeff int) int { return int(v) * coeff >> 8 }
+	clip := func(v int) uint8 {
+		// 6 bits of fixed point precision.
+		if v < 0 {
+			return 0
+		}
+		if v >= 256<<6 {
+			return 255
+		}
+		return uint8(v >> 6)
+	}
+	luma := mulHi(y, 19077)
+	r := clip(luma + mulHi(v, 26149) - 14234)
+	g := clip(luma - mulHi(u, 6419) - mulHi(v, 13320) + 8708)
+	b := clip(luma + mulHi(u, 33050) - 17685)
+	return r, g, b
+}
+
 func CanvasToImage(cv canvas.Canvas) image.Image {
 	ci := MakeCanvasImage(cv)
 	return &ci
 }
 
+// Convert cv to an 8-bit image, exactly for canvases converted from 8-bit
+// images.
+func CanvasToNrgba(cv canvas.Canvas) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, int(cv.Width()), int(cv.Height())))
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			c := cv.At(x, y)
+			img.SetNRGBA(int(x), int(y), color.NRGBA{
+				R: uint8(c.R() >> 8),
+				G: uint8(c.G() >> 8),
+				B: uint8(c.B() >> 8),
+				A: uint8(c.A() >> 8),
+			})
+		}
+	}
+
+	return img
+}
+
 type CanvasImage struct {
 	canvas canvas.Canvas
 }
@@ -42,7 +103,7 @@ func MakeCanvasImage(cv canvas.Canvas) CanvasImage {
 }
 
 func (ci *CanvasImage) ColorModel() color.Model {
-	return color.RGBAModel
+	return color.NRGBA64Model
 }
 
 func (ci *CanvasImage) Bounds() image.Rectangle {
@@ -53,7 +114,8 @@ func (ci *CanvasImage) Bounds() image.Rectangle {
 }
 
 func (ci *CanvasImage) At(x, y int) color.Color {
-	return MakeCanvasImageColor(ci.canvas.At(uint32(x), uint32(y)))
+	r, g, b, a := ci.canvas.At(uint32(x), uint32(y)).Rgba()
+	return color.NRGBA64{R: r, G: g, B: b, A: a}
 }
 
 type CanvasImageColor struct {
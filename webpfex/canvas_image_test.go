@@ -23,3 +23,21 @@ func TestMakeImageCanvasColor(t *testing.T) {
 		t.Errorf("%d != %d", ca, ia)
 	}
 }
+
+func TestVp8YuvToRgb(t *testing.T) {
+	// Limited range: luma 16 is black and 235 white.
+	for _, c := range []struct {
+		y, u, v uint8
+		r, g, b uint8
+	}{
+		{16, 128, 128, 0, 0, 0},
+		{235, 128, 128, 255, 255, 255},
+		{41, 240, 110, 0, 0, 255},
+		{0, 0, 0, 0, 136, 0},
+	} {
+		r, g, b := vp8YuvToRgb(c.y, c.u, c.v)
+		if r != c.r || g != c.g || b != c.b {
+			t.Errorf("%d,%d,%d: expecting %d,%d,%d, got %d,%d,%d", c.y, c.u, c.v, c.r, c.g, c.b, r, g, b)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ChunkField is a header field of a chunk, decoded for display.
+type ChunkField struct {
+	Name  string
+	Value string
+}
+
+// Decode the header fields of c, the ones of the canvas, frames, bitstreams
+// and metadata. Chunks of other kinds, or too short for their header, have
+// none.
+func ChunkFields(c Chunk) []ChunkField {
+	var fields []ChunkField
+	add := func(name, format string, args ...interface{}) {
+		fields = append(fields, ChunkField{name, fmt.Sprintf(format, args...)})
+	}
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	switch c.FourCC {
+	case "VP8X":
+		vp8x, err := ParseVp8xHeader(c.Payload)
+		if err != nil {
+			return nil
+		}
+		var flags []string
+		for _, f := range []struct {
+			name string
+			flag byte
+		}{
+			{"icc", Vp8xFlagIcc}, {"alpha", Vp8xFlagAlpha}, {"exif", Vp8xFlagExif},
+			{"xmp", Vp8xFlagXmp}, {"animation", Vp8xFlagAnimation},
+		} {
+			if vp8x.Flags&f.flag != 0 {
+				flags = append(flags, f.name)
+			}
+		}
+		if flags == nil {
+			flags = []string{"none"}
+		}
+		add("flags", "%s", strings.Join(flags, ","))
+		add("canvas", "%dx%d", vp8x.Width, vp8x.Height)
+	case "ANIM":
+		anim, err := ParseAnimHeader(c.Payload)
+		if err != nil {
+			return nil
+		}
+		add("background", "%s", FormatColor(anim.BackgroundColor))
+		add("loops", "%d", anim.LoopCount)
+	case "ANMF":
+		anmf, err := ParseAnmfHeader(c.Payload)
+		if err != nil {
+			return nil
+		}
+		add("size", "%dx%d", anmf.Width, anmf.Height)
+		add("offset", "%d,%d", anmf.XOffset, anmf.YOffset)
+		add("duration", "%v", anmf.Duration)
+		add("blend", "%s", yesNo[anmf.Blend])
+		add("dispose", "%s", yesNo[anmf.Dispose])
+	case "VP8 ", "VP8L":
+		width, height, alpha, ok := bitstreamInfo([]Chunk{c})
+		if !ok {
+			return nil
+		}
+		add("size", "%dx%d", width, height)
+		if c.FourCC == "VP8L" {
+			add("alpha", "%s", yesNo[alpha])
+		}
+	case "ALPH":
+		if len(c.Payload) < 1 {
+			return nil
+		}
+		compression := map[byte]string{0: "none", 1: "lossless"}[c.Payload[0]&0x03]
+		if compression == "" {
+			compression = "unknown"
+		}
+		filter := []string{"none", "horizontal", "vertical", "gradient"}[c.Payload[0]>>2&0x03]
+		add("compression", "%s", compression)
+		add("filter", "%s", filter)
+	case "ICCP":
+		profile, err := ParseIccProfile(c.Payload)
+		if err != nil {
+			return nil
+		}
+		add("description", "%s", profile.Description)
+	}
+
+	return fields
+}
+
+// Parse the chunks of the frame in the ANMF chunk c, offsets being relative to
+// the file c is in.
+func FrameChunks(c Chunk) ([]Chunk, error) {
+	if len(c.Payload) < anmfHeaderSize {
+		return nil, makeParsingError("Truncated ANMF chunk",
+			fmt.Sprintf("%d bytes", len(c.Payload)))
+	}
+
+	chunks, err := parseChunks(c.Payload, anmfHeaderSize, int64(len(c.Payload)))
+	for i := range chunks {
+		chunks[i].Offset += c.Offset + 8
+	}
+	return chunks, err
+}
+
+// Parse fourCC as given on the command line, padding it with spaces like for
+// "XMP".
+func ParseFourCC(s string) (string, error) {
+	if s == "" || len(s) > 4 {
+		return "", fmt.Errorf("invalid chunk name %q, expecting 1 to 4 characters", s)
+	}
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			return "", fmt.Errorf("invalid chunk name %q", s)
+		}
+	}
+
+	return s + strings.Repeat(" ", 4-len(s)), nil
+}
+
+// ChunkSelector picks chunks directly within a WEBP file.
+type ChunkSelector struct {
+	FourCC string
+	// Pick only the Nth chunk with FourCC, from 1. 0 picks all of them, or the
+	// first when a single one is wanted.
+	Index int
+}
+
+func (s ChunkSelector) String() string {
+	name := strings.TrimRight(s.FourCC, " ")
+	if s.Index == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s #%d", name, s.Index)
+}
+
+// Index within chunks of each chunk s picks.
+func (s ChunkSelector) find(chunks []Chunk) []int {
+	var found []int
+	n := 0
+	for i, c := range chunks {
+		if c.FourCC != s.FourCC {
+			continue
+		}
+		n++
+		if s.Index == 0 || s.Index == n {
+			found = append(found, i)
+		}
+	}
+
+	return found
+}
+
+// The chunk s picks, the first if it picks several.
+func SelectChunk(chunks []Chunk, s ChunkSelector) (Chunk, bool) {
+	found := s.find(chunks)
+	if len(found) == 0 {
+		return Chunk{}, false
+	}
+	return chunks[found[0]], true
+}
+
+// Drop the chunks s picks, returning the others and how many were dropped.
+// The metadata flags of the VP8X chunk follow.
+func DropChunks(chunks []Chunk, s ChunkSelector) ([]Chunk, int) {
+	dropped := map[int]bool{}
+	for _, i := range s.find(chunks) {
+		dropped[i] = true
+	}
+
+	kept := make([]Chunk, 0, len(chunks)-len(dropped))
+	for i, c := range chunks {
+		if !dropped[i] {
+			kept = append(kept, c)
+		}
+	}
+	return syncVp8xFlags(kept), len(dropped)
+}
+
+// Replace the payload of the chunk s picks, the first if it picks several.
+// Missing ICCP, EXIF and XMP chunks are added where the container spec puts
+// them, others fail. The metadata flags of the VP8X chunk follow.
+func ReplaceChunk(chunks []Chunk, s ChunkSelector, payload []byte) ([]Chunk, error) {
+	replaced := make([]Chunk, len(chunks))
+	copy(replaced, chunks)
+	chunk := Chunk{FourCC: s.FourCC, Size: uint32(len(payload)), Payload: payload}
+
+	if found := s.find(chunks); len(found) > 0 {
+		chunk.Offset = chunks[found[0]].Offset
+		replaced[found[0]] = chunk
+		return syncVp8xFlags(replaced), nil
+	}
+	if s.Index > 1 || !isMetadataChunk(s.FourCC) {
+		return nil, fmt.Errorf("no %s chunk to replace", s)
+	}
+	vp8x := findChunkIndex(replaced, "VP8X")
+	if vp8x < 0 {
+		return nil, fmt.Errorf("no VP8X chunk, which a %s chunk needs", s)
+	}
+
+	// ICCP follows VP8X, EXIF then XMP end the file.
+	at := len(replaced)
+	switch s.FourCC {
+	case "ICCP":
+		at = vp8x + 1
+	case "EXIF":
+		if xmp := findChunkIndex(replaced, "XMP "); xmp >= 0 {
+			at = xmp
+		}
+	}
+	replaced = append(replaced[:at], append([]Chunk{chunk}, replaced[at:]...)...)
+	return syncVp8xFlags(replaced), nil
+}
+
+func isMetadataChunk(fourCC string) bool {
+	for _, m := range metadataFlags {
+		if m.fourCC == fourCC {
+			return true
+		}
+	}
+	return false
+}
+
+// Index of the first chunk with fourCC, or -1.
+func findChunkIndex(chunks []Chunk, fourCC string) int {
+	for i, c := range chunks {
+		if c.FourCC == fourCC {
+			return i
+		}
+	}
+	return -1
+}
+
+// Set the metadata flags of the VP8X chunk, if any, to the metadata chunks
+// present.
+func syncVp8xFlags(chunks []Chunk) []Chunk {
+	i := findChunkIndex(chunks, "VP8X")
+	if i < 0 || len(chunks[i].Payload) < 1 {
+		return chunks
+	}
+
+	payload := append([]byte(nil), chunks[i].Payload...)
+	for _, m := range metadataFlags {
+		if findChunkIndex(chunks, m.fourCC) >= 0 {
+			payload[0] |= m.flag
+		} else {
+			payload[0] &^= m.flag
+		}
+	}
+	chunks[i].Payload = payload
+	return chunks
+}
+
+// Serialize chunks as a WEBP file, sizing each chunk and the RIFF header after
+// the payloads.
+func EncodeWebpChunks(chunks []Chunk) []byte {
+	size := 4
+	for _, c := range chunks {
+		size += 8 + len(c.Payload) + len(c.Payload)%2
+	}
+
+	data := make([]byte, 0, 8+size)
+	data = append(data, "RIFF"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(size))
+	data = append(data, "WEBP"...)
+	for _, c := range chunks {
+		data = append(data, c.FourCC...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(c.Payload)))
+		data = append(data, c.Payload...)
+		if len(c.Payload)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+
+	return data
+}
+
+// Write chunks as the WEBP file out, which may be the one they were read from.
+func WriteWebpChunks(out string, chunks []Chunk, opts Options) error {
+	if err := checkOverwrite(out, opts); err != nil {
+		return err
+	}
+
+	return os.WriteFile(out, EncodeWebpChunks(chunks), 0644)
+}
+
+// Write the payload of c to the file out.
+func WriteChunkPayload(out string, c Chunk, opts Options) error {
+	if err := checkOverwrite(out, opts); err != nil {
+		return err
+	}
+
+	return os.WriteFile(out, c.Payload, 0644)
+}
@@ -0,0 +1,147 @@
+package webpfex
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/encode"
+)
+
+func fourCCs(chunks []Chunk) []string {
+	var names []string
+	for _, c := range chunks {
+		names = append(names, c.FourCC)
+	}
+	return names
+}
+
+func TestChunkFields(t *testing.T) {
+	data := encodeTestAWebp(t, encode.AnimationOptions{LoopCount: 2}, 40*time.Millisecond)
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]ChunkField{
+		"VP8X": {{"flags", "animation"}, {"canvas", "4x4"}},
+		"ANIM": {{"background", "#00000000"}, {"loops", "2"}},
+		"ANMF": {{"size", "4x4"}, {"offset", "0,0"}, {"duration", "40ms"},
+			{"blend", "no"}, {"dispose", "no"}},
+	}
+	for _, c := range chunks {
+		if fields := ChunkFields(c); !reflect.DeepEqual(fields, expected[c.FourCC]) {
+			t.Errorf("%s: expecting %v, got %v", c.FourCC, expected[c.FourCC], fields)
+		}
+	}
+
+	frameChunks, err := FrameChunks(chunks[2])
+	if err != nil || len(frameChunks) != 1 || frameChunks[0].FourCC != "VP8L" {
+		t.Fatalf("Expecting a VP8L chunk in the frame, got %v: %v", fourCCs(frameChunks), err)
+	}
+	if frameChunks[0].Offset != chunks[2].Offset+8+anmfHeaderSize {
+		t.Errorf("Expecting the VP8L chunk at an offset within the file, got %d", frameChunks[0].Offset)
+	}
+	fields := ChunkFields(frameChunks[0])
+	if !reflect.DeepEqual(fields, []ChunkField{{"size", "4x4"}, {"alpha", "no"}}) {
+		t.Errorf("Unexpected VP8L fields %v", fields)
+	}
+
+	if fields := ChunkFields(Chunk{FourCC: "VP8X", Payload: []byte{0}}); fields != nil {
+		t.Errorf("Expecting no fields for a truncated chunk, got %v", fields)
+	}
+}
+
+func TestParseFourCC(t *testing.T) {
+	for s, expected := range map[string]string{"EXIF": "EXIF", "XMP": "XMP ", "VP8": "VP8 "} {
+		if fourCC, err := ParseFourCC(s); err != nil || fourCC != expected {
+			t.Errorf("%q: expecting %q, got %q: %v", s, expected, fourCC, err)
+		}
+	}
+	for _, s := range []string{"", "ICCPX", "A\x00"} {
+		if _, err := ParseFourCC(s); err == nil {
+			t.Errorf("%q: expecting an error", s)
+		}
+	}
+}
+
+func TestDropChunks(t *testing.T) {
+	data := makeWebpData("VP8X", string([]byte{Vp8xFlagExif | Vp8xFlagAnimation, 0, 0, 0, 3, 0, 0, 3, 0, 0}),
+		"ANIM", "\x00\x00\x00\x00\x00\x00", "EXIF", "one", "EXIF", "two")
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept, dropped := DropChunks(chunks, ChunkSelector{FourCC: "EXIF", Index: 2})
+	if dropped != 1 || len(kept) != 3 || string(kept[2].Payload) != "one" {
+		t.Errorf("Expecting the second EXIF chunk dropped, got %v", fourCCs(kept))
+	}
+	if kept[0].Payload[0] != Vp8xFlagExif|Vp8xFlagAnimation {
+		t.Errorf("Expecting the EXIF flag kept, got %#x", kept[0].Payload[0])
+	}
+
+	kept, dropped = DropChunks(chunks, ChunkSelector{FourCC: "EXIF"})
+	if dropped != 2 || !reflect.DeepEqual(fourCCs(kept), []string{"VP8X", "ANIM"}) {
+		t.Errorf("Expecting every EXIF chunk dropped, got %v", fourCCs(kept))
+	}
+	if kept[0].Payload[0] != Vp8xFlagAnimation {
+		t.Errorf("Expecting the EXIF flag cleared, got %#x", kept[0].Payload[0])
+	}
+	if data[20] != Vp8xFlagExif|Vp8xFlagAnimation {
+		t.Error("Expecting the original data untouched")
+	}
+}
+
+func TestReplaceChunk(t *testing.T) {
+	data := makeWebpData("VP8X", string([]byte{Vp8xFlagXmp | Vp8xFlagAnimation, 0, 0, 0, 3, 0, 0, 3, 0, 0}),
+		"ANIM", "\x00\x00\x00\x00\x00\x00", "XMP ", "<x/>")
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := ReplaceChunk(chunks, ChunkSelector{FourCC: "XMP "}, []byte("<y/>"))
+	if err != nil || string(replaced[2].Payload) != "<y/>" {
+		t.Fatalf("Expecting the XMP chunk replaced: %v", err)
+	}
+	replaced, err = ReplaceChunk(replaced, ChunkSelector{FourCC: "ICCP"}, []byte("icc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, err = ReplaceChunk(replaced, ChunkSelector{FourCC: "EXIF"}, []byte("exif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"VP8X", "ICCP", "ANIM", "EXIF", "XMP "}
+	if !reflect.DeepEqual(fourCCs(replaced), expected) {
+		t.Errorf("Expecting chunks %v, got %v", expected, fourCCs(replaced))
+	}
+	if flags := replaced[0].Payload[0]; flags != Vp8xFlagIcc|Vp8xFlagExif|Vp8xFlagXmp|Vp8xFlagAnimation {
+		t.Errorf("Expecting the metadata flags set, got %#x", flags)
+	}
+
+	if _, err := ReplaceChunk(chunks, ChunkSelector{FourCC: "ANMF"}, nil); err == nil {
+		t.Error("Expecting an error replacing a missing ANMF chunk")
+	}
+	if _, err := ReplaceChunk(chunks, ChunkSelector{FourCC: "XMP ", Index: 2}, nil); err == nil {
+		t.Error("Expecting an error replacing a missing second XMP chunk")
+	}
+}
+
+func TestEncodeWebpChunks(t *testing.T) {
+	data := makeWebpData("VP8X", "0123456789", "EXIF", "odd", "XMP ", "<x/>")
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded := EncodeWebpChunks(chunks); !bytes.Equal(encoded, data) {
+		t.Errorf("Expecting %q, got %q", data, encoded)
+	}
+
+	chunks[1].Payload = []byte("even")
+	expected := makeWebpData("VP8X", "0123456789", "EXIF", "even", "XMP ", "<x/>")
+	if encoded := EncodeWebpChunks(chunks); !bytes.Equal(encoded, expected) {
+		t.Errorf("Expecting sizes following payloads, got %q", encoded)
+	}
+}
@@ -0,0 +1,151 @@
+package webpfex
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"webpfex/canvas"
+)
+
+// FrameFunc receives each composited frame of an animated WEBP. The canvas is
+// reused for the next frame and must be copied to be retained.
+type FrameFunc func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error
+
+// Composite every frame of the animated WEBP at path, described by info, onto a
+// single canvas and call fn after each one, transformed by opts.Transforms then
+// flattened onto opts.Background. Stops between frames once ctx is done.
+func CompositeAWebp(
+	ctx context.Context,
+	path string,
+	info AWebpInfo,
+	opts Options,
+	fn FrameFunc,
+) error {
+	if _, _, err := TransformedSize(opts.Transforms, info.Width, info.Height); err != nil {
+		return err
+	}
+	compositor := MakeCompositor(info.Width, info.Height, opts.Blend)
+	var background *canvas.Canvas
+
+	var converter *srgbConverter
+	if profile := sourceProfile(info, opts); profile != nil {
+		converter = makeSrgbConverter(profile)
+	}
+
+	for _, frameInfo := range info.FrameInfos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		overlay, err := loadAWebpFrame(ctx, path, frameInfo.Number, opts)
+		if err != nil {
+			return err
+		}
+		// The bitstream decides the size, the ANMF header the area checked.
+		if overlay.Width() != frameInfo.Width || overlay.Height() != frameInfo.Height {
+			return makeParsingError("Frame size mismatch", fmt.Sprintf(
+				"frame %d decodes to %dx%d, its header says %dx%d", frameInfo.Number,
+				overlay.Width(), overlay.Height(), frameInfo.Width, frameInfo.Height))
+		}
+
+		if converter != nil {
+			converter.ConvertCanvas(&overlay)
+		}
+		compositor.Draw(frameInfo, &overlay)
+
+		opts.report(Progress{
+			Stage:      StageComposite,
+			Frame:      frameInfo.Number,
+			FrameCount: info.FrameCount,
+		})
+
+		cv := &compositor.Canvas
+		if len(opts.Transforms) > 0 {
+			transformed := applyTransforms(opts.Transforms, compositor.Canvas)
+			cv = &transformed
+		}
+		if opts.Background != nil {
+			if background == nil {
+				rendered := opts.Background.Render(info, cv.Width(), cv.Height())
+				background = &rendered
+			}
+			flat := flatten(cv, background, opts)
+			cv = &flat
+		}
+		if err := fn(frameInfo, cv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compositor draws the frames of an animation onto a canvas the way libwebp's
+// WebPAnimDecoder does.
+type Compositor struct {
+	Canvas canvas.Canvas
+	blend  BlendMode
+	// The last drawn frame, whose disposal is applied before drawing the next.
+	prev            *AWebpFrameInfo
+	prevWasKeyFrame bool
+}
+
+// Like libwebp, start from a transparent canvas regardless of the background
+// color, which is only a hint.
+func MakeCompositor(width, height uint32, blend BlendMode) Compositor {
+	return Compositor{Canvas: canvas.MakeCanvas(width, height), blend: blend}
+}
+
+// Draw overlay, the frame described by frameInfo, after disposing of the
+// previous frame.
+func (c *Compositor) Draw(frameInfo AWebpFrameInfo, overlay *canvas.Canvas) {
+	transparent := canvas.MakeColorRgba(0, 0, 0, 0)
+	var disposed image.Rectangle
+	if c.prev != nil && c.prev.Dispose {
+		disposed = frameRect(*c.prev)
+		ClearCanvasRect(&c.Canvas, disposed, transparent)
+	}
+
+	keyFrame := c.isKeyFrame(frameInfo)
+	if keyFrame {
+		ClearCanvas(&c.Canvas, transparent)
+		OverlayCanvas(&c.Canvas, overlay, frameInfo.XOffset, frameInfo.YOffset)
+	} else if frameInfo.Blend && c.blend == BlendLinear {
+		overlayBlendCanvas(&c.Canvas, overlay, frameInfo.XOffset, frameInfo.YOffset,
+			disposed, OverlayColorLinear)
+	} else if frameInfo.Blend {
+		overlayBlendCanvas(&c.Canvas, overlay, frameInfo.XOffset, frameInfo.YOffset,
+			disposed, OverlayColor)
+	} else {
+		OverlayCanvas(&c.Canvas, overlay, frameInfo.XOffset, frameInfo.YOffset)
+	}
+
+	c.prev = &frameInfo
+	c.prevWasKeyFrame = keyFrame
+}
+
+// Whether libwebp draws frameInfo on a cleared canvas without blending, which
+// rounds differently from blending over transparency. That's the case when
+// nothing of the canvas before it can show.
+func (c *Compositor) isKeyFrame(frameInfo AWebpFrameInfo) bool {
+	full := func(f AWebpFrameInfo) bool {
+		return f.Width == c.Canvas.Width() && f.Height == c.Canvas.Height()
+	}
+
+	if c.prev == nil {
+		return true
+	} else if (!frameInfo.Alpha || !frameInfo.Blend) && full(frameInfo) {
+		return true
+	}
+	return c.prev.Dispose && (full(*c.prev) || c.prevWasKeyFrame)
+}
+
+// The area of the canvas covered by the frame described by frameInfo.
+func frameRect(frameInfo AWebpFrameInfo) image.Rectangle {
+	return image.Rect(
+		int(frameInfo.XOffset),
+		int(frameInfo.YOffset),
+		int(frameInfo.XOffset+frameInfo.Width),
+		int(frameInfo.YOffset+frameInfo.Height),
+	)
+}
@@ -0,0 +1,73 @@
+package webpfex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"webpfex/canvas"
+)
+
+func TestCompositeAWebpCancelled(t *testing.T) {
+	info, err := ParseAWebpInfo(AWEBP_INFO_DUMMY)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = CompositeAWebp(ctx, "missing.webp", info, DefaultOptions(),
+		func(AWebpFrameInfo, *canvas.Canvas) error {
+			t.Error("Expecting no frame after cancellation")
+			return nil
+		})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.Canceled, got %v", err)
+	}
+}
+
+func filledCanvas(width, height uint32, color canvas.Color) canvas.Canvas {
+	cv := canvas.MakeCanvas(width, height)
+	ClearCanvas(&cv, color)
+	return cv
+}
+
+// Expectations follow libwebp's BlendPixelNonPremult, in 8 bits.
+func TestCompositor(t *testing.T) {
+	rgba := func(r, g, b, a uint16) canvas.Color {
+		return canvas.MakeColorRgba(r*0x101, g*0x101, b*0x101, a*0x101)
+	}
+	red, transparent := rgba(255, 0, 0, 255), rgba(0, 0, 0, 0)
+	compositor := MakeCompositor(2, 2, BlendGamma)
+	expect := func(frame int, expected [4]canvas.Color) {
+		t.Helper()
+		for i, color := range expected {
+			x, y := uint32(i%2), uint32(i/2)
+			if got := compositor.Canvas.At(x, y); got != color {
+				t.Errorf("Frame %d at %d,%d: expecting %#x, got %#x", frame, x, y, color.Value(), got.Value())
+			}
+		}
+	}
+
+	overlay := filledCanvas(2, 2, red)
+	compositor.Draw(AWebpFrameInfo{Number: 1, Width: 2, Height: 2, Blend: true}, &overlay)
+	expect(1, [4]canvas.Color{red, red, red, red})
+
+	overlay = filledCanvas(1, 1, rgba(0, 0, 255, 128))
+	compositor.Draw(AWebpFrameInfo{Number: 2, Width: 1, Height: 1, Alpha: true,
+		XOffset: 1, YOffset: 1, Blend: true, Dispose: true}, &overlay)
+	expect(2, [4]canvas.Color{red, red, red, rgba(126, 0, 127, 255)})
+
+	// The disposed area blends as transparent, leaving the overlay as-is.
+	overlay = filledCanvas(1, 1, rgba(0, 255, 0, 128))
+	compositor.Draw(AWebpFrameInfo{Number: 3, Width: 1, Height: 1, Alpha: true,
+		XOffset: 1, YOffset: 1, Blend: true}, &overlay)
+	expect(3, [4]canvas.Color{red, red, red, rgba(0, 255, 0, 128)})
+
+	// A full frame without blending is a key frame, copied as-is.
+	overlay = filledCanvas(2, 2, rgba(0, 0, 255, 64))
+	overlay.WriteAt(0, 0, transparent)
+	compositor.Draw(AWebpFrameInfo{Number: 4, Width: 2, Height: 2, Alpha: true}, &overlay)
+	half := rgba(0, 0, 255, 64)
+	expect(4, [4]canvas.Color{transparent, half, half, half})
+}
@@ -0,0 +1,181 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"webpfex/canvas"
+)
+
+// Flags of the VP8X chunk.
+const (
+	Vp8xFlagIcc       = 0x20
+	Vp8xFlagAlpha     = 0x10
+	Vp8xFlagExif      = 0x08
+	Vp8xFlagXmp       = 0x04
+	Vp8xFlagAnimation = 0x02
+)
+
+// Vp8xHeader is the payload of a VP8X chunk, heading extended WEBPs.
+type Vp8xHeader struct {
+	Flags  byte
+	Width  uint32 // Of the canvas.
+	Height uint32
+}
+
+func ParseVp8xHeader(payload []byte) (Vp8xHeader, error) {
+	if len(payload) < 10 {
+		return Vp8xHeader{}, makeParsingError("Truncated VP8X chunk",
+			fmt.Sprintf("%d bytes", len(payload)))
+	}
+
+	return Vp8xHeader{
+		Flags:  payload[0],
+		Width:  uint24(payload[4:]) + 1,
+		Height: uint24(payload[7:]) + 1,
+	}, nil
+}
+
+// AnimHeader is the payload of an ANIM chunk, holding the global parameters of
+// an animation.
+type AnimHeader struct {
+	BackgroundColor canvas.Color
+	LoopCount       uint16
+}
+
+func ParseAnimHeader(payload []byte) (AnimHeader, error) {
+	if len(payload) < 6 {
+		return AnimHeader{}, makeParsingError("Truncated ANIM chunk",
+			fmt.Sprintf("%d bytes", len(payload)))
+	}
+
+	// Stored in BGRA order.
+	expand := func(v byte) uint16 { return uint16(v) * 0x101 }
+	return AnimHeader{
+		BackgroundColor: canvas.MakeColorRgba(
+			expand(payload[2]), expand(payload[1]), expand(payload[0]), expand(payload[3])),
+		LoopCount: binary.LittleEndian.Uint16(payload[4:]),
+	}, nil
+}
+
+// Size of the header of ANMF payloads, followed by the chunks of the frame.
+const anmfHeaderSize = 16
+
+// AnmfHeader is the header of an ANMF chunk, placing and timing a frame.
+type AnmfHeader struct {
+	XOffset  uint32
+	YOffset  uint32
+	Width    uint32
+	Height   uint32
+	Duration time.Duration
+	Blend    bool
+	Dispose  bool
+}
+
+func ParseAnmfHeader(payload []byte) (AnmfHeader, error) {
+	if len(payload) < anmfHeaderSize {
+		return AnmfHeader{}, makeParsingError("Truncated ANMF chunk",
+			fmt.Sprintf("%d bytes", len(payload)))
+	}
+
+	return AnmfHeader{
+		// Offsets are stored halved, so always even.
+		XOffset:  uint24(payload[0:]) * 2,
+		YOffset:  uint24(payload[3:]) * 2,
+		Width:    uint24(payload[6:]) + 1,
+		Height:   uint24(payload[9:]) + 1,
+		Duration: time.Duration(uint24(payload[12:])) * time.Millisecond,
+		Blend:    payload[15]&0x02 == 0,
+		Dispose:  payload[15]&0x01 != 0,
+	}, nil
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// Size of the image in the chunks of a frame, as its VP8 or VP8L bitstream
+// says, and whether it has alpha. ok is false without a readable bitstream.
+func bitstreamInfo(chunks []Chunk) (width, height uint32, alpha bool, ok bool) {
+	_, alpha = findChunk(chunks, "ALPH")
+	if c, found := findChunk(chunks, "VP8L"); found {
+		if len(c.Payload) < 5 || c.Payload[0] != 0x2F {
+			return 0, 0, false, false
+		}
+		bits := binary.LittleEndian.Uint32(c.Payload[1:])
+		return bits&0x3FFF + 1, bits>>14&0x3FFF + 1, bits>>28&1 == 1, true
+	}
+	if c, found := findChunk(chunks, "VP8 "); found {
+		// A frame tag then a start code.
+		if len(c.Payload) < 10 || c.Payload[3] != 0x9D || c.Payload[4] != 0x01 ||
+			c.Payload[5] != 0x2A {
+			return 0, 0, false, false
+		}
+		width := uint32(binary.LittleEndian.Uint16(c.Payload[6:]) & 0x3FFF)
+		height := uint32(binary.LittleEndian.Uint16(c.Payload[8:]) & 0x3FFF)
+		return width, height, alpha, true
+	}
+
+	return 0, 0, false, false
+}
+
+// Describe the animated WEBP made of chunks, like webpmux would but without
+// it. The chunks of frames must be complete.
+func ParseAWebpInfoChunks(chunks []Chunk) (AWebpInfo, error) {
+	c, ok := findChunk(chunks, "VP8X")
+	if !ok {
+		e := makeParsingError("Not an animated WEBP", "no VP8X chunk")
+		e.subError = ErrNotAnimated
+		return AWebpInfo{}, e
+	}
+	vp8x, err := ParseVp8xHeader(c.Payload)
+	if err != nil {
+		return AWebpInfo{}, err
+	}
+	c, ok = findChunk(chunks, "ANIM")
+	if !ok {
+		e := makeParsingError("Not an animated WEBP", "no ANIM chunk")
+		e.subError = ErrNotAnimated
+		return AWebpInfo{}, e
+	}
+	anim, err := ParseAnimHeader(c.Payload)
+	if err != nil {
+		return AWebpInfo{}, err
+	}
+
+	var frameInfos []AWebpFrameInfo
+	for _, c := range chunks {
+		if c.FourCC != "ANMF" {
+			continue
+		}
+		frameInfo, err := parseAnmfFrameInfo(c, uint32(len(frameInfos)+1))
+		if err != nil {
+			return AWebpInfo{}, err
+		}
+		frameInfos = append(frameInfos, frameInfo)
+	}
+
+	info := MakeAWebpInfo(vp8x.Width, vp8x.Height, anim.BackgroundColor,
+		uint32(len(frameInfos)), frameInfos)
+	info.LoopCount = anim.LoopCount
+	info.Metadata = MetadataFromChunks(chunks)
+	return info, nil
+}
+
+// Describe the frame in the ANMF chunk c, numbered number.
+func parseAnmfFrameInfo(c Chunk, number uint32) (AWebpFrameInfo, error) {
+	header, err := ParseAnmfHeader(c.Payload)
+	if err != nil {
+		return AWebpFrameInfo{}, err
+	}
+	frameChunks, err := parseChunks(c.Payload, anmfHeaderSize, int64(len(c.Payload)))
+	if err != nil {
+		return AWebpFrameInfo{}, err
+	}
+	_, _, alpha, _ := bitstreamInfo(frameChunks)
+
+	frameInfo := MakeAWebpFrameInfo(number, header.Width, header.Height, alpha,
+		header.XOffset, header.YOffset, header.Duration, header.Blend)
+	frameInfo.Dispose = header.Dispose
+	return frameInfo, nil
+}
@@ -0,0 +1,76 @@
+package webpfex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+// Encode an opaque animation of 4x4 pixels, of a frame per duration.
+func encodeTestAWebp(t *testing.T, options encode.AnimationOptions, durations ...time.Duration) []byte {
+	t.Helper()
+	options.Width, options.Height = 4, 4
+	var b bytes.Buffer
+	w := encode.MakeAnimationWriter(&b, options)
+	for i, d := range durations {
+		cv := canvas.MakeCanvas(4, 4)
+		ClearCanvas(&cv, canvas.MakeColorRgba(uint16(i)*0x1111, 0, 0, 0xFFFF))
+		if err := w.WriteFrame(encode.Frame{Canvas: cv, Duration: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParseAWebpInfoChunks(t *testing.T) {
+	data := encodeTestAWebp(t, encode.AnimationOptions{LoopCount: 3, EXIF: []byte("exif")},
+		40*time.Millisecond, 60*time.Millisecond)
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParseAWebpInfoChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 4 || info.Height != 4 || info.LoopCount != 3 || info.FrameCount != 2 {
+		t.Errorf("Expecting 2 frames of 4x4 looping 3 times, got %+v", info)
+	}
+	if string(info.Metadata.EXIF) != "exif" {
+		t.Errorf("Expecting the EXIF metadata, got %q", info.Metadata.EXIF)
+	}
+	frame := info.FrameInfos[1]
+	if frame.Number != 2 || frame.Width != 4 || frame.Duration != 60*time.Millisecond || frame.Alpha {
+		t.Errorf("Expecting an opaque second frame of 4x4 lasting 60ms, got %+v", frame)
+	}
+
+	if _, err := ParseAWebpInfoChunks(chunks[2:]); err == nil {
+		t.Error("Expecting an error without VP8X")
+	}
+}
+
+func TestParseAnmfHeader(t *testing.T) {
+	header, err := ParseAnmfHeader([]byte{
+		3, 0, 0, 1, 0, 0, // Offsets halved.
+		9, 0, 0, 0, 1, 0, // Sizes minus 1.
+		0x2C, 0x01, 0, // 300ms.
+		0x03,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := AnmfHeader{6, 2, 10, 257, 300 * time.Millisecond, false, true}
+	if header != expected {
+		t.Errorf("Expecting %+v, got %+v", expected, header)
+	}
+
+	if _, err := ParseAnmfHeader(make([]byte, 15)); err == nil {
+		t.Error("Expecting an error for a truncated header")
+	}
+}
@@ -0,0 +1,123 @@
+package webpfex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go:generate go run ./internal/goldengen -out testdata/golden
+
+// Entry of testdata/golden/manifest.json as written by goldengen.
+type goldenCase struct {
+	Name      string `json:"name"`
+	Frames    int    `json:"frames"`
+	Tolerance uint8  `json:"tolerance"`
+}
+
+// Compare extracted frames against what libwebp's anim_dump renders.
+func TestGoldenFrames(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "golden", "manifest.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatal("no golden corpus, run go generate with libwebp tools installed")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Tools{}).Lookup(ToolWebpmux); err != nil {
+		t.Skip(err)
+	}
+
+	var cases []goldenCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			webp := filepath.Join("testdata", "golden", c.Name+".webp")
+			if err := ExtractWebpFramesAsPng(webp, out); err != nil {
+				t.Fatal(err)
+			}
+
+			for n := 1; n <= c.Frames; n++ {
+				name := fmt.Sprintf("%09d.png", n)
+				want, err := readPng(filepath.Join("testdata", "golden", c.Name, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := readPng(filepath.Join(out, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := compareFrames(want, got, c.Tolerance); err != nil {
+					t.Errorf("frame %d: %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+func readPng(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+// Compare want and got pixel for pixel, allowing channels to differ by up to
+// tolerance. The color of fully transparent pixels doesn't matter.
+func compareFrames(want, got image.Image, tolerance uint8) error {
+	if want.Bounds() != got.Bounds() {
+		return fmt.Errorf("expecting bounds %v, got %v", want.Bounds(), got.Bounds())
+	}
+
+	bounds := want.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			if w.A == 0 && g.A == 0 {
+				continue
+			}
+
+			for _, channel := range [][2]uint8{{w.R, g.R}, {w.G, g.G}, {w.B, g.B}, {w.A, g.A}} {
+				diff := int(channel[0]) - int(channel[1])
+				if diff > int(tolerance) || -diff > int(tolerance) {
+					return fmt.Errorf("at %d,%d expecting %v, got %v", x, y, w, g)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestCompareFrames(t *testing.T) {
+	want := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	want.SetNRGBA(0, 0, color.NRGBA{0x10, 0x20, 0x30, 0xFF})
+	got := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	got.SetNRGBA(0, 0, color.NRGBA{0x12, 0x20, 0x30, 0xFF})
+	got.SetNRGBA(1, 0, color.NRGBA{0xFF, 0xFF, 0xFF, 0x00})
+
+	if err := compareFrames(want, got, 0); err == nil {
+		t.Error("Expecting a difference")
+	}
+	if err := compareFrames(want, got, 2); err != nil {
+		t.Errorf("Expecting a match within tolerance, got %v", err)
+	}
+	if err := compareFrames(want, image.NewNRGBA(image.Rect(0, 0, 1, 1)), 0); err == nil {
+		t.Error("Expecting a bounds mismatch")
+	}
+}
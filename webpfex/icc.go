@@ -0,0 +1,425 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf16"
+	"webpfex/canvas"
+)
+
+// ColorMode chooses what happens to the colors of WEBPs with an ICC profile.
+type ColorMode int
+
+const (
+	// Keep pixel values as-is, embedding the profile in PNG outputs and tagging
+	// videos with the primaries of recognized profiles.
+	ColorPassThrough ColorMode = iota
+	// Convert pixel values from the profile to sRGB before compositing. Falls
+	// back to ColorPassThrough for profiles that aren't matrix/TRC based.
+	ColorToSrgb
+)
+
+func (m ColorMode) String() string {
+	switch m {
+	case ColorPassThrough:
+		return "passthrough"
+	case ColorToSrgb:
+		return "srgb"
+	default:
+		return fmt.Sprintf("ColorMode(%d)", int(m))
+	}
+}
+
+func ParseColorMode(s string) (ColorMode, error) {
+	switch s {
+	case "passthrough":
+		return ColorPassThrough, nil
+	case "srgb":
+		return ColorToSrgb, nil
+	default:
+		return 0, fmt.Errorf("unknown color mode %q", s)
+	}
+}
+
+// IccProfile is the part of an RGB matrix/TRC ICC profile needed to convert
+// its colors.
+type IccProfile struct {
+	Description string
+	toXyz       [3][3]float64 // Columns are the D50 XYZ of the red, green and blue primaries.
+	curves      [3]toneCurve
+}
+
+// Parse an RGB matrix/TRC ICC profile. Other profiles, e.g. LUT based ones,
+// fail with a *ParsingError.
+func ParseIccProfile(data []byte) (*IccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, makeParsingError("Not an ICC profile", fmt.Sprintf("%d bytes", len(data)))
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, makeParsingError("Unsupported ICC color spaces", string(data[16:24]))
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil, makeParsingError("Truncated ICC tag table", fmt.Sprintf("tag %d", i))
+		}
+
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 8 || offset+size > len(data) {
+			continue
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	var p IccProfile
+	p.Description = parseIccText(tags["desc"])
+	for i, name := range []string{"r", "g", "b"} {
+		xyz, ok := parseIccXyz(tags[name+"XYZ"])
+		if !ok {
+			return nil, makeParsingError("Not a matrix/TRC ICC profile", p.Description)
+		}
+		p.toXyz[0][i], p.toXyz[1][i], p.toXyz[2][i] = xyz[0], xyz[1], xyz[2]
+
+		curve, ok := parseIccCurve(tags[name+"TRC"])
+		if !ok {
+			return nil, makeParsingError("Unsupported ICC tone curve", p.Description)
+		}
+		p.curves[i] = curve
+	}
+
+	return &p, nil
+}
+
+// D50 adapted primaries of known RGB color spaces, keyed by their ffmpeg
+// color_primaries name.
+var knownPrimaries = map[string][3][3]float64{
+	"bt709": { // sRGB
+		{0.4361, 0.3851, 0.1431},
+		{0.2225, 0.7169, 0.0606},
+		{0.0139, 0.0971, 0.7141},
+	},
+	"smpte432": { // Display P3
+		{0.5151, 0.2920, 0.1571},
+		{0.2412, 0.6922, 0.0666},
+		{-0.0011, 0.0419, 0.7841},
+	},
+	"bt2020": {
+		{0.6734, 0.1656, 0.1251},
+		{0.2790, 0.6753, 0.0457},
+		{-0.0019, 0.0299, 0.7973},
+	},
+}
+
+// The ffmpeg color_primaries name of p's primaries, or "" when unrecognized.
+func (p *IccProfile) Primaries() string {
+	const tolerance = 0.005
+	for name, primaries := range knownPrimaries {
+		matches := true
+		for row := range primaries {
+			for col := range primaries[row] {
+				if math.Abs(primaries[row][col]-p.toXyz[row][col]) > tolerance {
+					matches = false
+				}
+			}
+		}
+		if matches {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Whether converting from p to sRGB would leave colors unchanged.
+func (p *IccProfile) IsSrgb() bool {
+	if p.Primaries() != "bt709" {
+		return false
+	}
+	for _, curve := range p.curves {
+		for _, x := range []float64{0.02, 0.2, 0.5, 0.8} {
+			if math.Abs(curve.Eval(x)-canvas.SrgbToLinearFloat(x)) > 0.002 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Converts straight canvas colors from an ICC profile to sRGB.
+type srgbConverter struct {
+	toLinear [3][]float32 // Per channel, indexed by the 16-bit channel value.
+	matrix   [3][3]float64
+}
+
+func makeSrgbConverter(p *IccProfile) *srgbConverter {
+	var c srgbConverter
+	for i, curve := range p.curves {
+		c.toLinear[i] = make([]float32, math.MaxUint16+1)
+		for v := range c.toLinear[i] {
+			c.toLinear[i][v] = float32(curve.Eval(float64(v) / math.MaxUint16))
+		}
+	}
+
+	fromXyz := invert3(knownPrimaries["bt709"])
+	c.matrix = multiply3(fromXyz, p.toXyz)
+	return &c
+}
+
+// Convert every pixel of cv in place.
+func (c *srgbConverter) ConvertCanvas(cv *canvas.Canvas) {
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			cv.WriteAt(x, y, c.convert(cv.At(x, y)))
+		}
+	}
+}
+
+func (c *srgbConverter) convert(color canvas.Color) canvas.Color {
+	r, g, b, a := color.Rgba()
+	if a == 0 {
+		return color
+	}
+
+	linear := [3]float64{
+		float64(c.toLinear[0][r]),
+		float64(c.toLinear[1][g]),
+		float64(c.toLinear[2][b]),
+	}
+
+	var out [3]uint16
+	for i := range out {
+		v := c.matrix[i][0]*linear[0] + c.matrix[i][1]*linear[1] + c.matrix[i][2]*linear[2]
+		v = canvas.LinearToSrgbFloat(math.Max(0, math.Min(1, v)))
+		out[i] = uint16(math.Round(v * math.MaxUint16))
+	}
+
+	return canvas.MakeColorRgba(out[0], out[1], out[2], a)
+}
+
+// ICC tone curve mapping encoded values in [0, 1] to linear light.
+type toneCurve struct {
+	table    []uint16  // curv with more than one entry.
+	function int       // para function type, or -1 for curv.
+	params   []float64 // para parameters g, a, b, c, d, e, f, or the curv gamma.
+}
+
+func (t toneCurve) Eval(x float64) float64 {
+	if t.table != nil {
+		pos := x * float64(len(t.table)-1)
+		i := int(pos)
+		if i >= len(t.table)-1 {
+			return float64(t.table[len(t.table)-1]) / math.MaxUint16
+		}
+		frac := pos - float64(i)
+		return (float64(t.table[i])*(1-frac) + float64(t.table[i+1])*frac) / math.MaxUint16
+	}
+
+	p := t.params
+	pow := func(base float64) float64 { return math.Pow(math.Max(base, 0), p[0]) }
+	switch t.function {
+	case 0:
+		return pow(x)
+	case 1:
+		if x >= -p[2]/p[1] {
+			return pow(p[1]*x + p[2])
+		}
+		return 0
+	case 2:
+		if x >= -p[2]/p[1] {
+			return pow(p[1]*x+p[2]) + p[3]
+		}
+		return p[3]
+	case 3:
+		if x >= p[4] {
+			return pow(p[1]*x + p[2])
+		}
+		return p[3] * x
+	case 4:
+		if x >= p[4] {
+			return pow(p[1]*x+p[2]) + p[5]
+		}
+		return p[3]*x + p[6]
+	default: // curv gamma, identity when 1.
+		return pow(x)
+	}
+}
+
+func parseIccCurve(tag []byte) (toneCurve, bool) {
+	if len(tag) < 12 {
+		return toneCurve{}, false
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+2*n {
+			return toneCurve{}, false
+		}
+		switch n {
+		case 0:
+			return toneCurve{function: -1, params: []float64{1}}, true
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return toneCurve{function: -1, params: []float64{gamma}}, true
+		default:
+			table := make([]uint16, n)
+			for i := range table {
+				table[i] = binary.BigEndian.Uint16(tag[12+2*i:])
+			}
+			return toneCurve{table: table, function: -1}, true
+		}
+	case "para":
+		function := int(binary.BigEndian.Uint16(tag[8:10]))
+		counts := []int{1, 3, 4, 5, 7}
+		if function >= len(counts) || len(tag) < 12+4*counts[function] {
+			return toneCurve{}, false
+		}
+		params := make([]float64, 7)
+		for i := 0; i < counts[function]; i++ {
+			params[i] = s15Fixed16(tag[12+4*i:])
+		}
+		return toneCurve{function: function, params: params}, true
+	default:
+		return toneCurve{}, false
+	}
+}
+
+func parseIccXyz(tag []byte) ([3]float64, bool) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, false
+	}
+
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, true
+}
+
+// Parse a v2 desc or v4 mluc tag, returning "" for anything else.
+func parseIccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+n {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:12]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:24]))
+		offset := int(binary.BigEndian.Uint32(tag[24:28]))
+		if offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return string(utf16.Decode(units))
+	default:
+		return ""
+	}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func multiply3(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+
+	return m
+}
+
+func invert3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}
+}
+
+// The profile of info to convert colors from under opts, or nil if colors pass
+// through.
+func sourceProfile(info AWebpInfo, opts Options) *IccProfile {
+	if opts.Color != ColorToSrgb || info.Metadata.ICC == nil {
+		return nil
+	}
+
+	profile, err := ParseIccProfile(info.Metadata.ICC)
+	if err != nil || profile.IsSrgb() {
+		return nil
+	}
+	return profile
+}
+
+// The metadata to embed in outputs, without the ICC profile once colors were
+// converted to sRGB.
+func outputMetadata(info AWebpInfo, opts Options) Metadata {
+	m := info.Metadata
+	if sourceProfile(info, opts) != nil {
+		m.ICC = nil
+	}
+
+	return m
+}
+
+// The ffmpeg scale filter options and output arguments tagging the colors of
+// info under opts. Nothing is tagged for an unrecognized profile.
+func ffmpegColorArgs(info AWebpInfo, opts Options) (string, []string) {
+	primaries, trc := "bt709", "iec61966-2-1"
+	if sourceProfile(info, opts) == nil && info.Metadata.ICC != nil {
+		profile, err := ParseIccProfile(info.Metadata.ICC)
+		if err != nil || profile.Primaries() == "" {
+			return "", nil
+		}
+
+		primaries = profile.Primaries()
+		for _, curve := range profile.curves {
+			if math.Abs(curve.Eval(0.5)-canvas.SrgbToLinearFloat(0.5)) > 0.002 {
+				trc = ""
+			}
+		}
+	} else if info.Metadata.ICC == nil {
+		return "", nil
+	}
+
+	args := []string{"-color_primaries", primaries, "-colorspace", "bt709"}
+	if trc != "" {
+		args = append(args, "-color_trc", trc)
+	}
+	return ":out_color_matrix=bt709", args
+}
@@ -0,0 +1,142 @@
+package webpfex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"webpfex/canvas"
+)
+
+// Build a v2 matrix/TRC ICC profile with primaries, as the columns of a D50
+// XYZ matrix, and the sRGB tone curve.
+func makeIccDummy(description string, primaries [3][3]float64) []byte {
+	be := binary.BigEndian
+	fixed := func(v float64) uint32 { return uint32(int32(math.Round(v * 65536))) }
+
+	var desc bytes.Buffer
+	desc.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(&desc, be, uint32(len(description)+1))
+	desc.WriteString(description + "\x00")
+
+	var trc bytes.Buffer
+	trc.WriteString("para\x00\x00\x00\x00")
+	binary.Write(&trc, be, []uint16{3, 0})
+	for _, p := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		binary.Write(&trc, be, fixed(p))
+	}
+
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{{"desc", desc.Bytes()}}
+	for i, name := range []string{"r", "g", "b"} {
+		var xyz bytes.Buffer
+		xyz.WriteString("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			binary.Write(&xyz, be, fixed(primaries[row][i]))
+		}
+		tags = append(tags, tag{name + "XYZ", xyz.Bytes()}, tag{name + "TRC", trc.Bytes()})
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB XYZ ")
+	copy(header[36:], "acsp")
+
+	var table, data bytes.Buffer
+	binary.Write(&table, be, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	for _, t := range tags {
+		table.WriteString(t.sig)
+		binary.Write(&table, be, []uint32{uint32(offset + data.Len()), uint32(len(t.data))})
+		data.Write(t.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	profile := append(header, table.Bytes()...)
+	profile = append(profile, data.Bytes()...)
+	be.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile
+}
+
+func TestParseIccProfile(t *testing.T) {
+	p3, err := ParseIccProfile(makeIccDummy("Display P3", knownPrimaries["smpte432"]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p3.Description != "Display P3" {
+		t.Errorf("Expecting description %q got %q", "Display P3", p3.Description)
+	}
+	if p3.Primaries() != "smpte432" || p3.IsSrgb() {
+		t.Errorf("Expecting Display P3 primaries, got %q", p3.Primaries())
+	}
+
+	srgb, err := ParseIccProfile(makeIccDummy("sRGB", knownPrimaries["bt709"]))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !srgb.IsSrgb() {
+		t.Errorf("Expecting sRGB profile to be recognized")
+	}
+
+	if _, err := ParseIccProfile([]byte("not a profile")); err == nil {
+		t.Errorf("Expecting error for garbage")
+	}
+}
+
+func TestSrgbConverter(t *testing.T) {
+	p3, _ := ParseIccProfile(makeIccDummy("Display P3", knownPrimaries["smpte432"]))
+	converter := makeSrgbConverter(p3)
+
+	// Display P3 red lies outside of sRGB and clips to sRGB red.
+	if c := converter.convert(canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)); c.R() != 0xFFFF ||
+		c.G() > 0x0100 || c.B() > 0x0100 {
+		t.Errorf("Expecting sRGB red, got %X", c.Value())
+	}
+
+	// Both share the white point and tone curve, so grays are kept.
+	gray := canvas.MakeColorRgba(0x8000, 0x8000, 0x8000, 0xFFFF)
+	if c := converter.convert(gray); absDiff(c.R(), 0x8000) > 0x80 ||
+		absDiff(c.G(), 0x8000) > 0x80 || absDiff(c.B(), 0x8000) > 0x80 {
+		t.Errorf("Expecting gray to be kept, got %X", c.Value())
+	}
+
+	// Half transparent gray stays half transparent gray.
+	halfGray := canvas.MakeColorRgba(0x8000, 0x8000, 0x8000, 0x8000)
+	if c := converter.convert(halfGray); c.A() != 0x8000 || absDiff(c.G(), 0x8000) > 0x80 {
+		t.Errorf("Expecting half transparent gray to be kept, got %X", c.Value())
+	}
+}
+
+func TestFfmpegColorArgs(t *testing.T) {
+	info := AWebpInfo{Metadata: Metadata{
+		ICC: makeIccDummy("Display P3", knownPrimaries["smpte432"]),
+	}}
+
+	filter, args := ffmpegColorArgs(info, Options{Color: ColorPassThrough})
+	if filter == "" || args[1] != "smpte432" {
+		t.Errorf("Expecting Display P3 to be tagged, got %q %v", filter, args)
+	}
+
+	_, args = ffmpegColorArgs(info, Options{Color: ColorToSrgb})
+	if args[1] != "bt709" {
+		t.Errorf("Expecting sRGB to be tagged after conversion, got %v", args)
+	}
+	if m := outputMetadata(info, Options{Color: ColorToSrgb}); m.ICC != nil {
+		t.Errorf("Expecting the profile to be dropped after conversion")
+	}
+
+	if filter, args := ffmpegColorArgs(AWebpInfo{}, Options{}); filter != "" || args != nil {
+		t.Errorf("Expecting nothing tagged without a profile, got %q %v", filter, args)
+	}
+}
+
+func absDiff(a, b uint16) uint16 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
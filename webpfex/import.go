@@ -0,0 +1,726 @@
+package webpfex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+// importedAnimation is an animation decoded from another format, described
+// like an animated WEBP.
+type importedAnimation struct {
+	Info AWebpInfo
+	// Composite every frame onto a single canvas and call fn after each one,
+	// with the info of that frame. Stops between frames once ctx is done.
+	Composite func(ctx context.Context, fn FrameFunc) error
+}
+
+// Extensions of the videos imported through ffmpeg.
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true,
+}
+
+func ImportAWebp(in string, out string) error {
+	return ImportAWebpContext(context.Background(), in, out, -1, DefaultOptions())
+}
+
+// Encode the GIF, animated PNG or video at in, told apart by its extension, as
+// an animated WEBP at out keeping the duration of every frame. The animation
+// loops loopCount times, 0 meaning forever, or as many times as in does if
+// loopCount is negative. GIFs and PNGs are decoded natively, videos by
+// ffmpeg. Gives up once ctx is done, removing the partial output.
+func ImportAWebpContext(
+	ctx context.Context,
+	in string,
+	out string,
+	loopCount int,
+	opts Options,
+) error {
+	animation, err := importAnimation(ctx, in, opts)
+	if err != nil {
+		return err
+	}
+	info := animation.Info
+	if loopCount >= 0 {
+		info.LoopCount = uint16(loopCount)
+	}
+	if err := opts.Limits.Check(info); err != nil {
+		return err
+	}
+
+	options := encode.AnimationOptions{
+		Width:     info.Width,
+		Height:    info.Height,
+		LoopCount: info.LoopCount,
+	}
+	return writeAWebp(ctx, out, options, opts, func(w encode.FrameWriter) error {
+		err := animation.Composite(ctx, func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error {
+			if err := w.WriteFrame(encode.Frame{Canvas: *cv, Duration: frameInfo.Duration}); err != nil {
+				return err
+			}
+			opts.report(Progress{
+				Stage:      StageEncode,
+				Frame:      frameInfo.Number,
+				FrameCount: info.FrameCount,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return w.Close()
+	})
+}
+
+func importAnimation(ctx context.Context, path string, opts Options) (importedAnimation, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if videoExtensions[ext] {
+		return importVideo(ctx, path, opts)
+	}
+
+	var decode func(r io.Reader) (importedAnimation, error)
+	switch ext {
+	case ".gif":
+		decode = func(r io.Reader) (importedAnimation, error) {
+			return decodeGif(r, opts.Limits)
+		}
+	case ".png", ".apng":
+		decode = decodeApng
+	default:
+		return importedAnimation{}, fmt.Errorf(
+			"can't import %s, expecting a .gif, .png, .apng or video like .mp4", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	defer file.Close()
+
+	animation, err := decode(bufio.NewReader(file))
+	if err != nil {
+		return importedAnimation{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return animation, nil
+}
+
+// Decode the GIF from r. Like browsers, frames are drawn over a transparent
+// canvas rather than the background color, and a delay of 0 is kept as is.
+// The canvas size, frame count and memory are checked against limits before
+// decoding any frame, the rest of them by the caller once decoded.
+func decodeGif(r io.Reader, limits Limits) (importedAnimation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	if err := checkGifLimits(data, config, limits); err != nil {
+		return importedAnimation{}, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	if len(g.Image) == 0 {
+		return importedAnimation{}, errors.New("no frames")
+	}
+	width, height := uint32(g.Config.Width), uint32(g.Config.Height)
+	if width == 0 || height == 0 {
+		bounds := g.Image[0].Bounds()
+		width, height = uint32(bounds.Max.X), uint32(bounds.Max.Y)
+	}
+	screen := image.Rect(0, 0, int(width), int(height))
+
+	frameInfos := make([]AWebpFrameInfo, len(g.Image))
+	for i, frame := range g.Image {
+		rect := frame.Bounds().Intersect(screen)
+		alpha := false
+		for _, c := range frame.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				alpha = true
+			}
+		}
+		frameInfos[i] = MakeAWebpFrameInfo(uint32(i+1),
+			uint32(rect.Dx()), uint32(rect.Dy()), alpha,
+			uint32(rect.Min.X), uint32(rect.Min.Y),
+			time.Duration(g.Delay[i])*10*time.Millisecond, true)
+		frameInfos[i].Dispose = g.Disposal[i] == gif.DisposalBackground
+	}
+
+	info := MakeAWebpInfo(width, height, canvas.MakeColor(0), uint32(len(frameInfos)), frameInfos)
+	info.LoopCount = gifLoopCount(g.LoopCount)
+	return importedAnimation{
+		Info: info,
+		Composite: func(ctx context.Context, fn FrameFunc) error {
+			cv := canvas.MakeCanvas(width, height)
+			for i, frame := range g.Image {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				var previous canvas.Canvas
+				if g.Disposal[i] == gif.DisposalPrevious {
+					previous = cv.Clone()
+				}
+				rect := frame.Bounds().Intersect(screen)
+				for y := rect.Min.Y; y < rect.Max.Y; y++ {
+					for x := rect.Min.X; x < rect.Max.X; x++ {
+						if c := straightColorAt(frame, x, y); c.A() != 0 {
+							cv.WriteAt(uint32(x), uint32(y), c)
+						}
+					}
+				}
+
+				if err := fn(frameInfos[i], &cv); err != nil {
+					return err
+				}
+
+				switch g.Disposal[i] {
+				case gif.DisposalBackground:
+					ClearCanvasRect(&cv, rect, canvas.MakeColor(0))
+				case gif.DisposalPrevious:
+					cv = previous
+				}
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+// Check the canvas of the GIF data against limits, then its frames one by one,
+// as gif.DecodeAll holds all of them at once, a byte per pixel.
+func checkGifLimits(data []byte, config image.Config, limits Limits) error {
+	width, height := uint32(config.Width), uint32(config.Height)
+	info := MakeAWebpInfo(width, height, canvas.MakeColor(0), 0, nil)
+	if err := limits.Check(info); err != nil {
+		return err
+	}
+
+	canvasArea := uint64(width) * uint64(height)
+	memory := EstimateMemory(info)
+	var frameCount uint32
+	return scanGifFrames(data, func(area uint64) error {
+		frameCount++
+		memory += area
+		if limits.MaxFrameCount != 0 && frameCount > limits.MaxFrameCount {
+			return makeLimitError(
+				"frame count", uint64(frameCount), uint64(limits.MaxFrameCount))
+		}
+		if pixels := canvasArea * uint64(frameCount); limits.MaxTotalPixels != 0 &&
+			pixels > limits.MaxTotalPixels {
+			return makeLimitError("total pixels", pixels, limits.MaxTotalPixels)
+		}
+		if limits.MaxMemory != 0 && memory > limits.MaxMemory {
+			return makeLimitError("memory (bytes)", memory, limits.MaxMemory)
+		}
+		return nil
+	})
+}
+
+// Call fn with the area of every frame of the GIF data, read from the image
+// descriptors without decoding the frames. Stops quietly at anything malformed,
+// for gif.DecodeAll to report.
+func scanGifFrames(data []byte, fn func(area uint64) error) error {
+	// The signature and logical screen descriptor, then the global color table.
+	pos := 13
+	if len(data) < pos {
+		return nil
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1)
+	}
+
+	// Skip the data sub-blocks at pos, false if data ends first.
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension, its label then sub-blocks.
+			pos += 2
+			if !skipSubBlocks() {
+				return nil
+			}
+		case 0x2C: // Image descriptor, then the local color table.
+			if pos+10 > len(data) {
+				return nil
+			}
+			width := binary.LittleEndian.Uint16(data[pos+5:])
+			height := binary.LittleEndian.Uint16(data[pos+7:])
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			if err := fn(uint64(width) * uint64(height)); err != nil {
+				return err
+			}
+			// The LZW minimum code size, then the image data sub-blocks.
+			pos++
+			if !skipSubBlocks() {
+				return nil
+			}
+		default: // The trailer.
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// WEBP loop count of a GIF's, which counts repetitions after the first play
+// and is -1 to play once.
+func gifLoopCount(loopCount int) uint16 {
+	switch {
+	case loopCount < 0:
+		return 1
+	case loopCount == 0:
+		return 0
+	case loopCount >= math.MaxUint16:
+		return math.MaxUint16
+	default:
+		return uint16(loopCount + 1)
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Values of the dispose_op and blend_op fields of APNG frame controls.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+// apngFrame is a frame of an animated PNG, as its fcTL chunk describes it
+// followed by its compressed image data.
+type apngFrame struct {
+	rect    image.Rectangle
+	delay   time.Duration
+	dispose byte
+	blend   byte
+	data    []byte
+}
+
+// Decode the animated PNG from r. A default image without a frame control
+// isn't part of the animation and is skipped.
+func decodeApng(r io.Reader) (importedAnimation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	if !bytes.HasPrefix(data, pngSignature) {
+		return importedAnimation{}, errors.New("not a PNG")
+	}
+
+	var ihdr []byte
+	// Chunks before the image data, PLTE and tRNS among them, which every frame
+	// is decoded with.
+	var header bytes.Buffer
+	animated := false
+	var plays uint32
+	var frames []apngFrame
+	seenData := false
+	for p := len(pngSignature); p < len(data); {
+		if len(data)-p < 12 {
+			return importedAnimation{}, errors.New("truncated chunk")
+		}
+		length := binary.BigEndian.Uint32(data[p:])
+		if uint64(len(data)-p-12) < uint64(length) {
+			return importedAnimation{}, errors.New("truncated chunk")
+		}
+		chunkType := string(data[p+4 : p+8])
+		body := data[p+8 : p+8+int(length)]
+		p += 12 + int(length)
+
+		switch chunkType {
+		case "IHDR":
+			if len(body) != 13 {
+				return importedAnimation{}, errors.New("invalid IHDR chunk")
+			}
+			ihdr = body
+		case "acTL":
+			if len(body) != 8 {
+				return importedAnimation{}, errors.New("invalid acTL chunk")
+			}
+			animated = true
+			plays = binary.BigEndian.Uint32(body[4:])
+		case "fcTL":
+			frame, err := parseApngFrameControl(body)
+			if err != nil {
+				return importedAnimation{}, err
+			}
+			frames = append(frames, frame)
+		case "IDAT":
+			seenData = true
+			// The default image is the first frame if a frame control precedes it.
+			if len(frames) == 1 {
+				frames[0].data = append(frames[0].data, body...)
+			}
+		case "fdAT":
+			if len(body) < 4 || len(frames) == 0 {
+				return importedAnimation{}, errors.New("invalid fdAT chunk")
+			}
+			last := &frames[len(frames)-1]
+			last.data = append(last.data, body[4:]...)
+		case "IEND":
+			p = len(data)
+		default:
+			if !seenData {
+				writePngChunk(&header, chunkType, body)
+			}
+		}
+	}
+	if ihdr == nil {
+		return importedAnimation{}, errors.New("missing IHDR chunk")
+	}
+	if !animated || len(frames) == 0 {
+		return importedAnimation{}, errors.New("not an animated PNG")
+	}
+
+	width := binary.BigEndian.Uint32(ihdr[0:])
+	height := binary.BigEndian.Uint32(ihdr[4:])
+	bounds := image.Rect(0, 0, int(width), int(height))
+	frameInfos := make([]AWebpFrameInfo, len(frames))
+	for i := range frames {
+		frame := &frames[i]
+		if !frame.rect.In(bounds) || frame.rect.Empty() {
+			return importedAnimation{}, fmt.Errorf("frame %d isn't within the %dx%d canvas",
+				i+1, width, height)
+		}
+		if len(frame.data) == 0 {
+			return importedAnimation{}, fmt.Errorf("frame %d has no image data", i+1)
+		}
+		// Nothing is drawn before the first frame to blend with or go back to.
+		if i == 0 {
+			frame.blend = apngBlendSource
+			if frame.dispose == apngDisposePrevious {
+				frame.dispose = apngDisposeBackground
+			}
+		}
+		frameInfos[i] = MakeAWebpFrameInfo(uint32(i+1),
+			uint32(frame.rect.Dx()), uint32(frame.rect.Dy()), true,
+			uint32(frame.rect.Min.X), uint32(frame.rect.Min.Y),
+			frame.delay, frame.blend == apngBlendOver)
+		frameInfos[i].Dispose = frame.dispose == apngDisposeBackground
+	}
+
+	info := MakeAWebpInfo(width, height, canvas.MakeColor(0), uint32(len(frames)), frameInfos)
+	if plays > math.MaxUint16 {
+		plays = math.MaxUint16
+	}
+	info.LoopCount = uint16(plays)
+	return importedAnimation{
+		Info: info,
+		Composite: func(ctx context.Context, fn FrameFunc) error {
+			cv := canvas.MakeCanvas(width, height)
+			for i, frame := range frames {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				overlay, err := decodeApngFrame(ihdr, header.Bytes(), frame)
+				if err != nil {
+					return fmt.Errorf("frame %d: %w", i+1, err)
+				}
+				var previous canvas.Canvas
+				if frame.dispose == apngDisposePrevious {
+					previous = cv.Clone()
+				}
+				x, y := uint32(frame.rect.Min.X), uint32(frame.rect.Min.Y)
+				if frame.blend == apngBlendOver {
+					OverlayBlendCanvas(&cv, &overlay, x, y)
+				} else {
+					OverlayCanvas(&cv, &overlay, x, y)
+				}
+
+				if err := fn(frameInfos[i], &cv); err != nil {
+					return err
+				}
+
+				switch frame.dispose {
+				case apngDisposeBackground:
+					ClearCanvasRect(&cv, frame.rect, canvas.MakeColor(0))
+				case apngDisposePrevious:
+					cv = previous
+				}
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+func parseApngFrameControl(body []byte) (apngFrame, error) {
+	if len(body) != 26 {
+		return apngFrame{}, errors.New("invalid fcTL chunk")
+	}
+	width := binary.BigEndian.Uint32(body[4:])
+	height := binary.BigEndian.Uint32(body[8:])
+	x := binary.BigEndian.Uint32(body[12:])
+	y := binary.BigEndian.Uint32(body[16:])
+	if width > math.MaxInt32 || height > math.MaxInt32 ||
+		x > math.MaxInt32-width || y > math.MaxInt32-height {
+		return apngFrame{}, errors.New("invalid fcTL chunk")
+	}
+	delayNum := binary.BigEndian.Uint16(body[20:])
+	delayDen := binary.BigEndian.Uint16(body[22:])
+	// A denominator of 0 means hundredths of a second.
+	if delayDen == 0 {
+		delayDen = 100
+	}
+
+	return apngFrame{
+		rect:    image.Rect(int(x), int(y), int(x+width), int(y+height)),
+		delay:   time.Duration(delayNum) * time.Second / time.Duration(delayDen),
+		dispose: body[24],
+		blend:   body[25],
+	}, nil
+}
+
+// Decode frame as the PNG of its size made of the header chunks and its data.
+func decodeApngFrame(ihdr []byte, header []byte, frame apngFrame) (canvas.Canvas, error) {
+	var stream bytes.Buffer
+	stream.Write(pngSignature)
+	frameIhdr := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(frameIhdr[0:], uint32(frame.rect.Dx()))
+	binary.BigEndian.PutUint32(frameIhdr[4:], uint32(frame.rect.Dy()))
+	writePngChunk(&stream, "IHDR", frameIhdr)
+	stream.Write(header)
+	writePngChunk(&stream, "IDAT", frame.data)
+	writePngChunk(&stream, "IEND", nil)
+
+	img, err := png.Decode(&stream)
+	if err != nil {
+		return canvas.Canvas{}, err
+	}
+	return ImageToCanvas(img), nil
+}
+
+// Arguments of ffmpeg listing the packets of the first video stream of path
+// with its framecrc muxer, copying rather than decoding them.
+func ffmpegPacketArgs(path string) []string {
+	return []string{
+		"-loglevel", "error",
+		"-i", path,
+		"-map", "0:v:0",
+		"-c", "copy",
+		"-f", "framecrc",
+		"-",
+	}
+}
+
+// Arguments of ffmpeg decoding the first video stream of path to raw RGBA
+// frames, each kept with its own timestamp by fpsMode, as ffmpegFpsModeArgs
+// gives it. Frames are kept as stored, like the packets listed, ignoring
+// rotation metadata.
+func ffmpegVideoArgs(path string, fpsMode []string) []string {
+	args := []string{
+		"-loglevel", "error",
+		"-noautorotate",
+		"-i", path,
+		"-map", "0:v:0",
+	}
+	args = append(args, fpsMode...)
+	return append(args,
+		"-pix_fmt", "rgba",
+		"-f", "rawvideo",
+		"-",
+	)
+}
+
+// Describe the video at path from the packets ffmpeg lists without decoding
+// them, so that limits are checked first, then composite its frames as ffmpeg
+// decodes and pipes them raw.
+func importVideo(ctx context.Context, path string, opts Options) (importedAnimation, error) {
+	if _, err := os.Stat(path); err != nil {
+		return importedAnimation{}, err
+	}
+	output, err := runCommand(ctx, opts, ToolFfmpeg, ffmpegPacketArgs(path)...)
+	if err != nil {
+		return importedAnimation{}, err
+	}
+	info, err := parseFramecrc(string(output))
+	if err != nil {
+		return importedAnimation{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return importedAnimation{
+		Info: info,
+		Composite: func(ctx context.Context, fn FrameFunc) error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			frames := rawFrameWriter{cv: canvas.MakeCanvas(info.Width, info.Height)}
+			frames.onFrame = func(cv *canvas.Canvas) error {
+				// Should ffmpeg decode more frames than packets, time them like the last.
+				i := frames.count
+				if i >= len(info.FrameInfos) {
+					i = len(info.FrameInfos) - 1
+				}
+				frameInfo := info.FrameInfos[i]
+				frameInfo.Number = uint32(frames.count + 1)
+				if err := fn(frameInfo, cv); err != nil {
+					cancel()
+					return err
+				}
+				return nil
+			}
+
+			fpsMode := ffmpegFpsModeArgs(ctx, opts, "passthrough")
+			err := runCommandTo(ctx, opts, &frames, ToolFfmpeg, ffmpegVideoArgs(path, fpsMode)...)
+			if frames.err != nil {
+				return frames.err
+			}
+			return err
+		},
+	}, nil
+}
+
+// Describe the video whose packets ffmpeg's framecrc muxer lists in output,
+// in decoding order. Frames last until the next one by timestamp starts, the
+// last one for its own duration.
+func parseFramecrc(output string) (AWebpInfo, error) {
+	var width, height uint32
+	var timeBase [2]int64
+	var starts []int64
+	var last, lastDuration int64
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#tb 0:") {
+			value := strings.TrimPrefix(line, "#tb 0:")
+			num, den, ok := strings.Cut(strings.TrimSpace(value), "/")
+			n, nErr := strconv.ParseInt(num, 10, 64)
+			d, dErr := strconv.ParseInt(den, 10, 64)
+			if !ok || nErr != nil || dErr != nil || n <= 0 || d <= 0 {
+				return AWebpInfo{}, makeParsingError("Failed parsing time base", line)
+			}
+			timeBase = [2]int64{n, d}
+		} else if strings.HasPrefix(line, "#dimensions 0:") {
+			value := strings.TrimPrefix(line, "#dimensions 0:")
+			w, h, ok := strings.Cut(strings.TrimSpace(value), "x")
+			wv, wErr := strconv.ParseUint(w, 10, 32)
+			hv, hErr := strconv.ParseUint(h, 10, 32)
+			if !ok || wErr != nil || hErr != nil || wv == 0 || hv == 0 {
+				return AWebpInfo{}, makeParsingError("Failed parsing dimensions", line)
+			}
+			width, height = uint32(wv), uint32(hv)
+		} else if line != "" && !strings.HasPrefix(line, "#") {
+			// stream, dts, pts, duration, size, checksum
+			fields := strings.Split(line, ",")
+			if len(fields) < 5 {
+				return AWebpInfo{}, makeParsingError("Failed parsing frame", line)
+			}
+			pts, ptsErr := strconv.ParseInt(strings.TrimSpace(fields[2]), 10, 64)
+			duration, durationErr := strconv.ParseInt(strings.TrimSpace(fields[3]), 10, 64)
+			if ptsErr != nil || durationErr != nil {
+				return AWebpInfo{}, makeParsingError("Failed parsing frame", line)
+			}
+			if pts == math.MinInt64 { // No timestamp.
+				continue
+			}
+			if len(starts) == 0 || pts > last {
+				last, lastDuration = pts, duration
+			}
+			starts = append(starts, pts)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	if timeBase[1] == 0 || width == 0 {
+		return AWebpInfo{}, makeParsingError("Missing time base or dimensions", output)
+	}
+	if len(starts) == 0 {
+		return AWebpInfo{}, errors.New("no video frames")
+	}
+
+	toDuration := func(ticks int64) time.Duration {
+		if ticks <= 0 {
+			return 0
+		}
+		seconds := float64(ticks) * float64(timeBase[0]) / float64(timeBase[1])
+		return time.Duration(math.Round(seconds * float64(time.Second)))
+	}
+	frameInfos := make([]AWebpFrameInfo, len(starts))
+	for i, start := range starts {
+		ticks := lastDuration
+		if i+1 < len(starts) {
+			ticks = starts[i+1] - start
+		}
+		frameInfos[i] = MakeAWebpFrameInfo(uint32(i+1), width, height, false, 0, 0,
+			toDuration(ticks), false)
+	}
+
+	return MakeAWebpInfo(width, height, canvas.MakeColor(0), uint32(len(frameInfos)), frameInfos), nil
+}
+
+// Writer splitting the raw RGBA frames written to it, of the size of cv, and
+// passing each to onFrame drawn on cv. Once onFrame fails, the rest is
+// discarded so that the tool writing it isn't blocked.
+type rawFrameWriter struct {
+	cv      canvas.Canvas
+	onFrame func(cv *canvas.Canvas) error
+	pending []byte
+	count   int
+	err     error
+}
+
+func (w *rawFrameWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return len(p), nil
+	}
+
+	size := int(w.cv.Width()) * int(w.cv.Height()) * 4
+	w.pending = append(w.pending, p...)
+	for len(w.pending) >= size {
+		expand := func(v byte) uint16 { return uint16(v) * 0x101 }
+		i := 0
+		for y := uint32(0); y < w.cv.Height(); y++ {
+			for x := uint32(0); x < w.cv.Width(); x++ {
+				px := w.pending[i : i+4]
+				w.cv.WriteAt(x, y, canvas.MakeColorRgba(
+					expand(px[0]), expand(px[1]), expand(px[2]), expand(px[3])))
+				i += 4
+			}
+		}
+		w.pending = append(w.pending[:0], w.pending[size:]...)
+
+		if err := w.onFrame(&w.cv); err != nil {
+			w.err = err
+			return len(p), nil
+		}
+		w.count++
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,465 @@
+package webpfex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/canvas"
+)
+
+// Composite animation and collect a copy of every frame and its info.
+func compositeImported(t *testing.T, animation importedAnimation) ([]AWebpFrameInfo, []canvas.Canvas) {
+	t.Helper()
+	var infos []AWebpFrameInfo
+	var canvases []canvas.Canvas
+	err := animation.Composite(context.Background(),
+		func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error {
+			infos = append(infos, frameInfo)
+			canvases = append(canvases, cv.Clone())
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return infos, canvases
+}
+
+func encodeTestGif(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{0xFF, 0, 0, 0xFF},
+		color.RGBA{0, 0xFF, 0, 0xFF},
+	}
+	frame := func(rect image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(rect, palette)
+		for i := range img.Pix {
+			img.Pix[i] = index
+		}
+		return img
+	}
+
+	// A red background, a green square cleared after showing, and a green pixel
+	// whose area is restored afterwards over a transparent one.
+	transparent := frame(image.Rect(0, 0, 2, 1), 0)
+	transparent.Pix[0] = 2
+	g := gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 4), 1),
+			frame(image.Rect(2, 2, 4, 4), 2),
+			transparent,
+			frame(image.Rect(3, 0, 4, 1), 0),
+		},
+		Delay:     []int{10, 0, 4, 25},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, 0},
+		LoopCount: 2,
+		Config:    image.Config{ColorModel: palette, Width: 4, Height: 4},
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, &g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeGif(t *testing.T) {
+	animation, err := decodeGif(bytes.NewReader(encodeTestGif(t)), Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if animation.Info.LoopCount != 3 {
+		t.Errorf("Expecting a loop count of 3, got %d", animation.Info.LoopCount)
+	}
+
+	infos, canvases := compositeImported(t, animation)
+	var durations []time.Duration
+	for _, info := range infos {
+		durations = append(durations, info.Duration)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 0, 40 * time.Millisecond, 250 * time.Millisecond}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting durations %v, got %v", expected, durations)
+	}
+	if !infos[1].Dispose || infos[1].XOffset != 2 || infos[1].Width != 2 {
+		t.Errorf("Expecting the second frame disposed at 2, 2 of width 2, got %+v", infos[1])
+	}
+
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+	green := canvas.MakeColorRgba(0, 0xFFFF, 0, 0xFFFF)
+	transparent := canvas.MakeColor(0)
+	for _, c := range []struct {
+		frame int
+		x, y  uint32
+		color canvas.Color
+	}{
+		{0, 3, 3, red},
+		{1, 3, 3, green},
+		{1, 1, 1, red},
+		// Cleared by the previous frame, drawn green only here.
+		{2, 3, 3, transparent},
+		{2, 0, 0, green},
+		{2, 1, 0, red},
+		// Restored to before the green pixel, unchanged by transparency.
+		{3, 0, 0, red},
+		{3, 3, 0, red},
+	} {
+		if got := canvases[c.frame].At(c.x, c.y); got != c.color {
+			t.Errorf("Frame %d at %d, %d: expecting %v, got %v", c.frame, c.x, c.y, c.color, got)
+		}
+	}
+}
+
+func TestDecodeGifLimits(t *testing.T) {
+	data := encodeTestGif(t)
+	for _, c := range []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{MaxWidth: 3}, "width"},
+		{Limits{MaxFrameCount: 3}, "frame count"},
+		{Limits{MaxTotalPixels: 63}, "total pixels"},
+		// The canvas, then frames of 16, 4, 2 and 1 bytes.
+		{Limits{MaxMemory: 4*4*8 + 22}, "memory (bytes)"},
+	} {
+		_, err := decodeGif(bytes.NewReader(data), c.limits)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != c.limit {
+			t.Errorf("Expecting a LimitError on %s, got %v", c.limit, err)
+		}
+	}
+
+	if _, err := decodeGif(bytes.NewReader(data), Limits{MaxFrameCount: 4, MaxMemory: 4*4*8 + 23}); err != nil {
+		t.Errorf("Expecting a GIF within limits decoded, got %v", err)
+	}
+}
+
+func TestGifLoopCount(t *testing.T) {
+	for loopCount, expected := range map[int]uint16{-1: 1, 0: 0, 1: 2, 70000: 65535} {
+		if got := gifLoopCount(loopCount); got != expected {
+			t.Errorf("GIF loop count %d: expecting %d, got %d", loopCount, expected, got)
+		}
+	}
+}
+
+// Build an APNG of 4x4 pixels from frames, each given with its frame control
+// fields, the first one also being the default image.
+func encodeTestApng(t *testing.T, plays uint32, frames []struct {
+	img            image.Image
+	x, y           uint32
+	delayNum       uint16
+	delayDen       uint16
+	dispose, blend byte
+}) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	b.Write(pngSignature)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 4)
+	binary.BigEndian.PutUint32(ihdr[4:], 4)
+	ihdr[8], ihdr[9] = 8, 6 // 8-bit RGBA.
+	writePngChunk(&b, "IHDR", ihdr)
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:], plays)
+	writePngChunk(&b, "acTL", actl)
+
+	sequence := uint32(0)
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], sequence)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(f.img.Bounds().Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(f.img.Bounds().Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], f.x)
+		binary.BigEndian.PutUint32(fctl[16:], f.y)
+		binary.BigEndian.PutUint16(fctl[20:], f.delayNum)
+		binary.BigEndian.PutUint16(fctl[22:], f.delayDen)
+		fctl[24], fctl[25] = f.dispose, f.blend
+		writePngChunk(&b, "fcTL", fctl)
+		sequence++
+
+		// Unfiltered 8-bit RGBA rows.
+		var data bytes.Buffer
+		z := zlib.NewWriter(&data)
+		bounds := f.img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			z.Write([]byte{0})
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(f.img.At(x, y)).(color.NRGBA)
+				z.Write([]byte{c.R, c.G, c.B, c.A})
+			}
+		}
+		z.Close()
+
+		if i == 0 {
+			writePngChunk(&b, "IDAT", data.Bytes())
+		} else {
+			fdat := make([]byte, 4, 4+data.Len())
+			binary.BigEndian.PutUint32(fdat, sequence)
+			writePngChunk(&b, "fdAT", append(fdat, data.Bytes()...))
+			sequence++
+		}
+	}
+	writePngChunk(&b, "IEND", nil)
+
+	return b.Bytes()
+}
+
+func uniformImage(width, height int, c color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestDecodeApng(t *testing.T) {
+	data := encodeTestApng(t, 5, []struct {
+		img            image.Image
+		x, y           uint32
+		delayNum       uint16
+		delayDen       uint16
+		dispose, blend byte
+	}{
+		{uniformImage(4, 4, color.NRGBA{0xFF, 0, 0, 0xFF}), 0, 0, 1, 10, apngDisposeNone, apngBlendOver},
+		{uniformImage(2, 2, color.NRGBA{0, 0, 0xFF, 0x80}), 1, 1, 3, 0, apngDisposePrevious, apngBlendOver},
+		{uniformImage(1, 1, color.NRGBA{0, 0xFF, 0, 0}), 0, 0, 1, 1, apngDisposeBackground, apngBlendSource},
+		{uniformImage(1, 1, color.NRGBA{0, 0xFF, 0, 0xFF}), 3, 3, 1, 20, apngDisposeNone, apngBlendOver},
+	})
+	animation, err := decodeApng(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if animation.Info.LoopCount != 5 || animation.Info.FrameCount != 4 {
+		t.Errorf("Expecting 4 frames played 5 times, got %+v", animation.Info)
+	}
+
+	infos, canvases := compositeImported(t, animation)
+	var durations []time.Duration
+	for _, info := range infos {
+		durations = append(durations, info.Duration)
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond, 30 * time.Millisecond, time.Second, 50 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting durations %v, got %v", expected, durations)
+	}
+
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+	green := canvas.MakeColorRgba(0, 0xFFFF, 0, 0xFFFF)
+	transparent := canvas.MakeColorRgba(0, 0xFFFF, 0, 0)
+	if blended := canvases[1].At(1, 1); blended.B() == 0 || blended.R() == 0 {
+		t.Errorf("Expecting blue blended over red, got %v", blended)
+	}
+	for _, c := range []struct {
+		frame int
+		x, y  uint32
+		color canvas.Color
+	}{
+		{0, 2, 2, red},
+		{1, 0, 0, red},
+		// The blue square is gone, the source pixel replaces red.
+		{2, 1, 1, red},
+		{2, 0, 0, transparent},
+		// Then cleared to transparent black.
+		{3, 0, 0, canvas.MakeColor(0)},
+		{3, 3, 3, green},
+	} {
+		if got := canvases[c.frame].At(c.x, c.y); got != c.color {
+			t.Errorf("Frame %d at %d, %d: expecting %v, got %v", c.frame, c.x, c.y, c.color, got)
+		}
+	}
+
+	if _, err := decodeApng(bytes.NewReader(data[:len(data)-20])); err == nil {
+		t.Error("Expecting an error for a truncated APNG")
+	}
+	var still bytes.Buffer
+	png.Encode(&still, uniformImage(2, 2, color.NRGBA{0, 0, 0, 0xFF}))
+	if _, err := decodeApng(&still); err == nil {
+		t.Error("Expecting an error for a PNG that isn't animated")
+	}
+}
+
+func TestParseFramecrc(t *testing.T) {
+	info, err := parseFramecrc(`#software: Lavf60.3.100
+#tb 0: 1/1000
+#media_type 0: video
+#codec_id 0: rawvideo
+#dimensions 0: 320x240
+#sar 0: 1/1
+0,          0,          0,       33,   307200, 0x1f1b3b0e
+0,         33,         33,       33,   307200, 0x5d6f2e1a
+0,        100,        100,       40,   307200, 0x0a2b3c4d
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 320 || info.Height != 240 || info.FrameCount != 3 {
+		t.Errorf("Expecting 3 frames of 320x240, got %+v", info)
+	}
+	var durations []time.Duration
+	for _, frameInfo := range info.FrameInfos {
+		durations = append(durations, frameInfo.Duration)
+	}
+	expected := []time.Duration{33 * time.Millisecond, 67 * time.Millisecond, 40 * time.Millisecond}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting durations %v, got %v", expected, durations)
+	}
+
+	// Packets in decoding order, B-frames coming after the frame they precede.
+	info, err = parseFramecrc(`#tb 0: 1/1000
+#dimensions 0: 4x4
+0,          0,          0,       40,      100, 0x00000000
+0,         40,        120,       40,       80, 0x00000000
+0,         80,         40,       40,       20, 0x00000000
+0,        120,         80,       20,       20, 0x00000000
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durations = nil
+	for _, frameInfo := range info.FrameInfos {
+		durations = append(durations, frameInfo.Duration)
+	}
+	expected = []time.Duration{40 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond,
+		40 * time.Millisecond}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting durations %v in presentation order, got %v", expected, durations)
+	}
+
+	if _, err := parseFramecrc("#tb 0: 1/25\n"); err == nil {
+		t.Error("Expecting an error without dimensions")
+	}
+}
+
+func TestRawFrameWriter(t *testing.T) {
+	var frames []canvas.Canvas
+	w := rawFrameWriter{cv: canvas.MakeCanvas(2, 1)}
+	w.onFrame = func(cv *canvas.Canvas) error {
+		frames = append(frames, cv.Clone())
+		return nil
+	}
+	// Frames split across writes.
+	w.Write([]byte{0xFF, 0, 0, 0xFF, 0, 0})
+	w.Write([]byte{0xFF, 0x80, 1, 2, 3, 4, 5, 6})
+	w.Write([]byte{7, 8})
+	if len(frames) != 2 || w.count != 2 {
+		t.Fatalf("Expecting 2 frames, got %d", len(frames))
+	}
+	if got := frames[1].At(1, 0); got != canvas.MakeColorRgba(0x505, 0x606, 0x707, 0x808) {
+		t.Errorf("Expecting the second frame's pixel, got %v", got)
+	}
+}
+
+func TestImportAWebp(t *testing.T) {
+	dir := t.TempDir()
+	in := path.Join(dir, "in.gif")
+	if err := os.WriteFile(in, encodeTestGif(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := path.Join(dir, "out.webp")
+	if err := ImportAWebp(in, out); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := ReadWebpChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var durations []uint32
+	var loopCount uint16
+	for _, c := range chunks {
+		switch c.FourCC {
+		case "ANIM":
+			loopCount = binary.LittleEndian.Uint16(c.Payload[4:])
+		case "ANMF":
+			durations = append(durations,
+				uint32(c.Payload[12])|uint32(binary.LittleEndian.Uint16(c.Payload[13:]))<<8)
+		}
+	}
+	if expected := []uint32{100, 0, 40, 250}; !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting frames lasting %v, got %v", expected, durations)
+	}
+	if loopCount != 3 {
+		t.Errorf("Expecting a loop count of 3, got %d", loopCount)
+	}
+
+	opts := DefaultOptions()
+	opts.Overwrite = true
+	if err := ImportAWebpContext(context.Background(), in, out, 0, opts); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err = ReadWebpChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim, ok := findChunk(chunks, "ANIM"); !ok || binary.LittleEndian.Uint16(anim.Payload[4:]) != 0 {
+		t.Error("Expecting the loop count overridden to 0")
+	}
+
+	// Like browsers show it.
+	opts.TimingPolicy = TimingBrowser
+	if err := ImportAWebpContext(context.Background(), in, out, -1, opts); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err = ReadWebpChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durations = nil
+	for _, c := range chunks {
+		if c.FourCC == "ANMF" {
+			durations = append(durations,
+				uint32(c.Payload[12])|uint32(binary.LittleEndian.Uint16(c.Payload[13:]))<<8)
+		}
+	}
+	if expected := []uint32{100, 100, 40, 250}; !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting frames lasting %v, got %v", expected, durations)
+	}
+
+	if err := ImportAWebpContext(context.Background(), path.Join(dir, "in.bmp"), out, -1, opts); err == nil {
+		t.Error("Expecting an error for an unknown format")
+	}
+}
+
+func TestImportVideoLimits(t *testing.T) {
+	dir := t.TempDir()
+	decoded := path.Join(dir, "decoded")
+	opts := DefaultOptions()
+	opts.Limits.MaxWidth = 100
+	// Lists the packets of a 320x240 video when copying them, and records any
+	// decoding.
+	opts.Tools.Ffmpeg = writeFakeTool(t, dir, "ffmpeg", `
+case "$*" in
+*"-c copy"*)
+	printf '#tb 0: 1/1000\n#dimensions 0: 320x240\n0, 0, 0, 40, 100, 0x00000000\n'
+	;;
+*)
+	touch `+decoded+`
+	;;
+esac
+`)
+	in := path.Join(dir, "in.mp4")
+	os.WriteFile(in, nil, 0644)
+
+	err := ImportAWebpContext(context.Background(), in, path.Join(dir, "out.webp"), -1, opts)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Expecting a LimitError, got %v", err)
+	}
+	if _, err := os.Stat(decoded); err == nil {
+		t.Error("Expecting the limits checked before decoding")
+	}
+}
@@ -1,8 +1,8 @@
 package webpfex
 
 import (
+	"errors"
 	"fmt"
-	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,8 +14,10 @@ type AWebpInfo struct {
 	Width           uint32
 	Height          uint32
 	BackgroundColor canvas.Color
+	LoopCount       uint16 // Times the animation plays, 0 meaning forever.
 	FrameCount      uint32
 	FrameInfos      []AWebpFrameInfo
+	Metadata        Metadata // Not part of webpmux's info, read from the file.
 }
 
 func MakeAWebpInfo(
@@ -38,9 +40,29 @@ func MakeAWebpInfo(
 	}
 }
 
+// Check that every frame of info lies within its canvas, failing with a
+// *ParsingError otherwise, since compositing it would write past the canvas.
+func checkFrameRects(info AWebpInfo) error {
+	for _, f := range info.FrameInfos {
+		if uint64(f.XOffset)+uint64(f.Width) > uint64(info.Width) ||
+			uint64(f.YOffset)+uint64(f.Height) > uint64(info.Height) {
+			return makeParsingError("Frame outside the canvas", fmt.Sprintf(
+				"frame %d of %dx%d at %d, %d, the canvas is %dx%d", f.Number,
+				f.Width, f.Height, f.XOffset, f.YOffset, info.Width, info.Height))
+		}
+	}
+
+	return nil
+}
+
+// ErrNotAnimated is wrapped by errors about WEBPs without animation.
+var ErrNotAnimated = errors.New("not an animated WEBP")
+
 func ParseAWebpInfo(info string) (AWebpInfo, error) {
 	if strings.Contains(info, "No features present.") {
-		return AWebpInfo{}, makeParsingError("Not an animated WEBP", info)
+		e := makeParsingError("Not an animated WEBP", info)
+		e.subError = ErrNotAnimated
+		return AWebpInfo{}, e
 	}
 
 	width, height, err := parseAWebpInfoCanvasSize(info)
@@ -51,18 +73,25 @@ func ParseAWebpInfo(info string) (AWebpInfo, error) {
 	if err != nil {
 		return AWebpInfo{}, err
 	}
+	loopCount, err := parseAWebpInfoLoopCount(info)
+	if err != nil {
+		return AWebpInfo{}, err
+	}
 	frameCount, frameInfos, err := parseAWebpInfoFrames(info)
 	if err != nil {
 		return AWebpInfo{}, err
 	}
 
-	return MakeAWebpInfo(
+	parsed := MakeAWebpInfo(
 		width,
 		height,
 		backgroundColor,
 		frameCount,
 		frameInfos,
-	), nil
+	)
+	parsed.LoopCount = loopCount
+
+	return parsed, nil
 }
 
 func parseAWebpInfoCanvasSize(info string) (uint32, uint32, error) {
@@ -92,6 +121,20 @@ func parseAWebpInfoBackgroundColor(info string) (canvas.Color, error) {
 	return hex, nil
 }
 
+func parseAWebpInfoLoopCount(info string) (uint16, error) {
+	pattern := regexp.MustCompile(`(?m)Loop Count\s*:\s*(\d+)`)
+	matches := pattern.FindStringSubmatch(info)
+	if matches == nil {
+		return 0, makeParsingError("Failed finding loop count", info)
+	}
+	loopCount, err := strconv.ParseUint(matches[1], 10, 16)
+	if err != nil {
+		return 0, makeParsingError("Failed parsing loop count", info)
+	}
+
+	return uint16(loopCount), nil
+}
+
 func parseAWebpInfoFrames(info string) (uint32, []AWebpFrameInfo, error) {
 	pattern := regexp.MustCompile(`(?m)^Number of frames: (\d+)`)
 	matches := pattern.FindStringSubmatch(info)
@@ -116,17 +159,15 @@ func parseAWebpInfoFrames(info string) (uint32, []AWebpFrameInfo, error) {
 	return uint32(frameCount), frameInfos, nil
 }
 
+// Parse a color printed by webpmux as 0xAARRGGBB.
 func parseHexColor(s string) (canvas.Color, bool) {
-	hex, ok := new(big.Int).SetString(s, 0)
-	if !ok {
+	hex, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
 		return canvas.Color{}, false
 	}
 
-	if !hex.IsUint64() {
-		panic(fmt.Sprintf("Color %s is too big to convert to uint64.", s))
-	}
-
-	return canvas.MakeColor(hex.Uint64()), true
+	channel := func(shift uint) uint16 { return uint16(hex>>shift&0xFF) * 0x101 }
+	return canvas.MakeColorRgba(channel(16), channel(8), channel(0), channel(24)), true
 }
 
 type AWebpFrameInfo struct {
@@ -138,6 +179,7 @@ type AWebpFrameInfo struct {
 	YOffset  uint32
 	Duration time.Duration
 	Blend    bool
+	Dispose  bool // Clear the frame's area to transparent after displaying it.
 }
 
 func MakeAWebpFrameInfo(
@@ -206,6 +248,16 @@ func parseAWebpFrameInfo(line string) (AWebpFrameInfo, error) {
 	}
 	duration := time.Duration(durationMs * int64(time.Millisecond))
 
+	var dispose bool
+	switch fields[7] {
+	case "background":
+		dispose = true
+	case "none":
+		dispose = false
+	default:
+		return AWebpFrameInfo{}, makeParsingError("Failed parsing dispose", fields[7])
+	}
+
 	var blend bool
 	switch fields[8] {
 	case "yes":
@@ -216,14 +268,17 @@ func parseAWebpFrameInfo(line string) (AWebpFrameInfo, error) {
 		return AWebpFrameInfo{}, makeParsingError("Failed parsing blend", fields[8])
 	}
 
-	return MakeAWebpFrameInfo(
+	frameInfo := MakeAWebpFrameInfo(
 		uint32(number),
 		uint32(width), uint32(height),
 		alpha,
 		uint32(xOffset), uint32(yOffset),
 		duration,
 		blend,
-	), nil
+	)
+	frameInfo.Dispose = dispose
+
+	return frameInfo, nil
 }
 
 type ParsingError struct {
@@ -237,6 +292,10 @@ func makeParsingError(reason string, input string) *ParsingError {
 	return &e
 }
 
+func (e ParsingError) Unwrap() error {
+	return e.subError
+}
+
 func (e ParsingError) Error() string {
 	if e.subError != nil {
 		return fmt.Sprintf("ParsingError: %s: %q\n%s", e.reason, e.input, e.subError.Error())
@@ -1,9 +1,14 @@
 package webpfex
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 	"webpfex/canvas"
+	"webpfex/encode"
 )
 
 const AWEBP_INFO_DUMMY = `Canvas size: 640 x 640
@@ -40,7 +45,7 @@ func TestParseAWebpInfoCanvasSize(t *testing.T) {
 
 func TestParseAWebpInfoBackgroundColor(t *testing.T) {
 	backgroundColor, err := parseAWebpInfoBackgroundColor(AWEBP_INFO_DUMMY)
-	expectedBackgroundColor := canvas.MakeColor(0xFFFFFFFF)
+	expectedBackgroundColor := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF)
 
 	if backgroundColor != expectedBackgroundColor {
 		t.Errorf("Expecting %X got %X",
@@ -51,6 +56,30 @@ func TestParseAWebpInfoBackgroundColor(t *testing.T) {
 	}
 }
 
+func TestParseHexColor(t *testing.T) {
+	color, ok := parseHexColor("0x80FF4000")
+	if expected := canvas.MakeColorRgba(0xFFFF, 0x4040, 0, 0x8080); !ok || color != expected {
+		t.Errorf("Expecting %016X, got %016X", expected.Value(), color.Value())
+	}
+}
+
+func TestParseAWebpInfoLoopCount(t *testing.T) {
+	cases := map[string]uint16{
+		AWEBP_INFO_DUMMY: 0,
+		"Background color : 0x00000000  Loop Count : 3\n": 3,
+	}
+	for info, expected := range cases {
+		loopCount, err := parseAWebpInfoLoopCount(info)
+		if err != nil || loopCount != expected {
+			t.Errorf("Expecting %d, got %d %v", expected, loopCount, err)
+		}
+	}
+
+	if _, err := parseAWebpInfoLoopCount("Loop Count : 65536\n"); err == nil {
+		t.Error("Expecting an error for a loop count out of range")
+	}
+}
+
 func TestParseAWebpInfoFrames(t *testing.T) {
 	frameCount, frameInfo, err := parseAWebpInfoFrames(AWEBP_INFO_DUMMY)
 	var expectedFrameCount uint32 = 8
@@ -77,3 +106,52 @@ func TestParseAWebpInfoFrames(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 }
+
+func TestParseAWebpInfoNotAnimated(t *testing.T) {
+	_, err := ParseAWebpInfo("Canvas size: 16 x 16\nNo features present.\n")
+
+	if !errors.Is(err, ErrNotAnimated) {
+		t.Errorf("Expecting ErrNotAnimated, got %v", err)
+	}
+}
+
+func TestCheckFrameRects(t *testing.T) {
+	ms := time.Millisecond
+	data := encodeTestAWebp(t, encode.AnimationOptions{}, 40*ms, 40*ms)
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The halved x offset of the second frame, moving it 2 pixels right.
+	data[chunks[3].Offset+8] = 1
+	if chunks, err = ParseWebpChunks(data); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParseAWebpInfoChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsingErr *ParsingError
+	if err := checkFrameRects(info); !errors.As(err, &parsingErr) {
+		t.Errorf("Expecting a ParsingError for a frame past the canvas, got %v", err)
+	}
+
+	// As webpmux would report it.
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.webp")
+	os.WriteFile(in, data, 0644)
+	opts := DefaultOptions()
+	opts.Tools.Webpmux = writeFakeTool(t, dir, "webpmux", `cat <<EOF
+Canvas size: 4 x 4
+Features present: animation
+Background color : 0x00000000  Loop Count : 0
+Number of frames: 2
+No.: width height alpha x_offset y_offset duration   dispose blend image_size  compression
+  1:     4     4    no        0        0       40       none    no         30    lossless
+  2:     4     4    no        2        0       40       none    no         30    lossless
+EOF
+`)
+	if _, err := LoadAWebpInfo(context.Background(), in, opts); !errors.As(err, &parsingErr) {
+		t.Errorf("Expecting LoadAWebpInfo to fail with a ParsingError, got %v", err)
+	}
+}
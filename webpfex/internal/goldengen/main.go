@@ -0,0 +1,286 @@
+// Command goldengen generates the golden conformance corpus of the webpfex
+// package: small animated WEBPs and the frames libwebp's anim_dump renders
+// them to. It needs cwebp, webpmux and anim_dump from libwebp in PATH.
+//
+// Usage: go run ./internal/goldengen [-out testdata/golden]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A frame of a golden case, filled with pattern.
+type frame struct {
+	width, height int
+	x, y          int // Odd offsets are rounded down by webpmux.
+	duration      int // In milliseconds.
+	blend         bool
+	dispose       bool
+	pattern       func(x, y int) color.NRGBA
+}
+
+type goldenCase struct {
+	name      string
+	width     int
+	height    int
+	lossy     bool
+	tolerance uint8 // Per channel, libwebp and x/image/webp lossy decoding differ.
+	frames    []frame
+}
+
+// Entry of manifest.json, read by the golden test.
+type manifestEntry struct {
+	Name      string `json:"name"`
+	Frames    int    `json:"frames"`
+	Tolerance uint8  `json:"tolerance"`
+}
+
+func solid(c color.NRGBA) func(x, y int) color.NRGBA {
+	return func(x, y int) color.NRGBA { return c }
+}
+
+// Horizontal color ramp with alpha increasing downwards, covering every alpha
+// value at heights of 256 and more.
+func ramp(c color.NRGBA, height int) func(x, y int) color.NRGBA {
+	return func(x, y int) color.NRGBA {
+		return color.NRGBA{
+			R: uint8(int(c.R) * (x + 1) % 256),
+			G: uint8(int(c.G) * (x + 1) % 256),
+			B: c.B,
+			A: uint8(y * 255 / (height - 1)),
+		}
+	}
+}
+
+func checker(a, b color.NRGBA) func(x, y int) color.NRGBA {
+	return func(x, y int) color.NRGBA {
+		if (x/4+y/4)%2 == 0 {
+			return a
+		}
+		return b
+	}
+}
+
+var (
+	red         = color.NRGBA{0xFF, 0x00, 0x00, 0xFF}
+	blue        = color.NRGBA{0x00, 0x00, 0xFF, 0xFF}
+	halfGreen   = color.NRGBA{0x00, 0xFF, 0x00, 0x80}
+	quarterGray = color.NRGBA{0x80, 0x80, 0x80, 0x40}
+	transparent = color.NRGBA{0x12, 0x34, 0x56, 0x00}
+)
+
+var cases = []goldenCase{
+	{
+		name: "no_blend", width: 32, height: 32,
+		frames: []frame{
+			{width: 32, height: 32, duration: 100, pattern: solid(red)},
+			{width: 16, height: 16, x: 8, y: 8, duration: 100, pattern: solid(halfGreen)},
+			{width: 16, height: 16, x: 0, y: 16, duration: 100, pattern: checker(blue, transparent)},
+		},
+	},
+	{
+		name: "blend", width: 32, height: 32,
+		frames: []frame{
+			{width: 32, height: 32, duration: 100, blend: true, pattern: checker(red, blue)},
+			{width: 24, height: 24, x: 4, y: 4, duration: 100, blend: true, pattern: solid(halfGreen)},
+			{width: 16, height: 16, x: 8, y: 8, duration: 100, blend: true, pattern: solid(quarterGray)},
+		},
+	},
+	{
+		name: "blend_alpha_ramp", width: 64, height: 256,
+		frames: []frame{
+			{width: 64, height: 256, duration: 80, blend: true, pattern: ramp(color.NRGBA{7, 3, 0x40, 0}, 256)},
+			{width: 64, height: 256, duration: 80, blend: true, pattern: ramp(color.NRGBA{5, 11, 0xC0, 0}, 256)},
+		},
+	},
+	{
+		name: "dispose_background", width: 32, height: 32,
+		frames: []frame{
+			{width: 32, height: 32, duration: 100, blend: true, pattern: solid(blue)},
+			{width: 16, height: 16, x: 8, y: 8, duration: 100, blend: true, dispose: true, pattern: solid(red)},
+			{width: 24, height: 24, x: 4, y: 4, duration: 100, blend: true, pattern: solid(halfGreen)},
+			{width: 32, height: 32, duration: 100, dispose: true, pattern: checker(quarterGray, transparent)},
+			{width: 16, height: 16, x: 16, y: 0, duration: 100, blend: true, pattern: solid(halfGreen)},
+		},
+	},
+	{
+		name: "odd_offsets", width: 33, height: 31,
+		frames: []frame{
+			{width: 33, height: 31, duration: 50, blend: true, pattern: checker(red, blue)},
+			{width: 7, height: 9, x: 3, y: 5, duration: 50, blend: true, dispose: true, pattern: solid(halfGreen)},
+			{width: 11, height: 5, x: 21, y: 25, duration: 50, pattern: solid(quarterGray)},
+			{width: 1, height: 1, x: 32, y: 30, duration: 50, blend: true, pattern: solid(red)},
+		},
+	},
+	{
+		name: "lossy_alpha", width: 32, height: 32, lossy: true, tolerance: 8,
+		frames: []frame{
+			{width: 32, height: 32, duration: 100, blend: true, pattern: solid(blue)},
+			{width: 16, height: 16, x: 8, y: 8, duration: 100, blend: true, dispose: true, pattern: solid(halfGreen)},
+			{width: 32, height: 16, x: 0, y: 16, duration: 100, blend: true, pattern: solid(red)},
+		},
+	},
+}
+
+func main() {
+	out := flag.String("out", "testdata/golden", "output directory")
+	flag.Parse()
+
+	for _, tool := range []string{"cwebp", "webpmux", "anim_dump"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			fail(fmt.Errorf("%s from libwebp is required: %w", tool, err))
+		}
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fail(err)
+	}
+
+	var manifest []manifestEntry
+	for _, c := range cases {
+		if err := generate(c, *out); err != nil {
+			fail(fmt.Errorf("%s: %w", c.name, err))
+		}
+		manifest = append(manifest, manifestEntry{c.name, len(c.frames), c.tolerance})
+		fmt.Println(c.name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fail(err)
+	}
+	if err := os.WriteFile(filepath.Join(*out, "manifest.json"), append(data, '\n'), 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "goldengen:", err)
+	os.Exit(1)
+}
+
+// Write c's animated WEBP to out/<name>.webp and its anim_dump frames to
+// out/<name>/, named like ExtractWebpFramesAsPng names them.
+func generate(c goldenCase, out string) error {
+	tmp, err := os.MkdirTemp("", "goldengen")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	webp := filepath.Join(out, c.name+".webp")
+	args := []string{"-loop", "0", "-bgcolor", "255,255,255,255"}
+	for i, f := range c.frames {
+		framePng := filepath.Join(tmp, fmt.Sprintf("%d.png", i))
+		frameWebp := filepath.Join(tmp, fmt.Sprintf("%d.webp", i))
+		if err := writeFrame(f, framePng); err != nil {
+			return err
+		}
+
+		encoding := []string{"-lossless"}
+		if c.lossy {
+			encoding = []string{"-q", "90", "-alpha_q", "100"}
+		}
+		cwebp := append(encoding, "-exact", "-quiet", framePng, "-o", frameWebp)
+		if err := run("cwebp", cwebp...); err != nil {
+			return err
+		}
+
+		args = append(args, "-frame", frameWebp, frameOptions(f))
+	}
+	args = append(args, "-o", webp)
+	if err := run("webpmux", args...); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(out, c.name)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := run("anim_dump", "-folder", tmp, "-prefix", "dump_", webp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	dumps, err := filepath.Glob(filepath.Join(tmp, "dump_*.png"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(dumps)
+	if len(dumps) != len(c.frames) {
+		return fmt.Errorf("anim_dump wrote %d frames, expecting %d", len(dumps), len(c.frames))
+	}
+	for i, dump := range dumps {
+		data, err := os.ReadFile(dump)
+		if err != nil {
+			return err
+		}
+		golden := filepath.Join(dir, fmt.Sprintf("%09d.png", i+1))
+		if err := os.WriteFile(golden, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// webpmux's +d+x+y+m+b frame options.
+func frameOptions(f frame) string {
+	dispose := "0"
+	if f.dispose {
+		dispose = "1"
+	}
+	blend := "-b"
+	if f.blend {
+		blend = "+b"
+	}
+
+	return strings.Join([]string{
+		"",
+		strconv.Itoa(f.duration),
+		strconv.Itoa(f.x),
+		strconv.Itoa(f.y),
+		dispose,
+	}, "+") + blend
+}
+
+func writeFrame(f frame, path string) error {
+	img := image.NewNRGBA(image.Rect(0, 0, f.width, f.height))
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			img.SetNRGBA(x, y, f.pattern(x, y))
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, output)
+	}
+	return nil
+}
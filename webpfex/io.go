@@ -2,13 +2,18 @@ package webpfex
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
+	"io"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 	"webpfex/canvas"
+	"webpfex/encode"
 
 	png "image/png"
 
@@ -16,97 +21,296 @@ import (
 )
 
 func ExtractWebpFramesAsPng(webp string, outdir string) error {
-	err := os.Mkdir(outdir, 0755)
-	if err != nil && !os.IsExist(err) {
-		return err
-	}
+	return ExtractWebpFramesAsPngContext(
+		context.Background(), webp, outdir, DefaultOptions())
+}
+
+func ExtractWebpFramesAsPngWithOptions(webp string, outdir string, opts Options) error {
+	return ExtractWebpFramesAsPngContext(context.Background(), webp, outdir, opts)
+}
 
-	info, err := ExtractAWebpInfo(webp)
+// Like ExtractWebpFramesAsPng but stops between frames once ctx is done, in
+// which case the frames written so far are removed. The durations of the
+// frames are written to the timing file TimingSidecar in outdir.
+func ExtractWebpFramesAsPngContext(
+	ctx context.Context,
+	webp string,
+	outdir string,
+	opts Options,
+) (err error) {
+	info, err := LoadAWebpInfo(ctx, webp, opts)
 	if err != nil {
 		return err
 	}
+	if opts, err = trimOptions(ctx, webp, info, opts); err != nil {
+		return err
+	}
 
-	canvas := canvas.MakeCanvas(info.Width, info.Height)
-	ClearCanvas(&canvas, info.BackgroundColor)
+	err = os.Mkdir(outdir, 0755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	createdOutdir := err == nil
 
-	for _, frameInfo := range info.FrameInfos {
-		overlay, err := LoadAWebpFrame(webp, frameInfo.Number)
-		if err != nil {
-			panic(err)
+	var written []string
+	defer func() {
+		if err == nil {
+			return
 		}
-
-		if frameInfo.Blend {
-			OverlayBlendCanvas(&canvas, &overlay, frameInfo.XOffset, frameInfo.YOffset)
-		} else {
-			OverlayCanvas(&canvas, &overlay, frameInfo.XOffset, frameInfo.YOffset)
+		for _, p := range written {
+			os.Remove(p)
 		}
-
-		outpath := path.Join(outdir, fmt.Sprintf("%09d.png", frameInfo.Number))
-		err = SavePng(canvas, outpath)
-		if err != nil {
-			panic(err)
+		if createdOutdir {
+			os.Remove(outdir)
 		}
+	}()
+
+	// Fail before extracting every frame for nothing.
+	if err := checkOverwrite(filepath.Join(outdir, TimingSidecar), opts); err != nil {
+		return err
+	}
+	sidecars, err := WriteMetadataSidecars(info.Metadata, outdir, opts)
+	written = append(written, sidecars...)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	metadata := outputMetadata(info, opts)
+	var frames []sequenceFrame
+	if !opts.FrameRate.IsZero() || opts.editsTiming() {
+		// Numbered in output order.
+		sequence := &pngSequenceWriter{dir: outdir, metadata: metadata, opts: opts}
+		writer := timingWriter(retimingWriter(sequence, opts), opts)
+		err = compositeInto(ctx, webp, info, opts, writer)
+		written = append(written, sequence.Paths()...)
+		frames = sequence.frames
+	} else {
+		err = CompositeAWebp(ctx, webp, info, opts,
+			func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error {
+				name := fmt.Sprintf("%09d.png", frameInfo.Number)
+				outpath := filepath.Join(outdir, name)
+				if err := checkOverwrite(outpath, opts); err != nil {
+					return err
+				}
+				written = append(written, outpath)
+				frames = append(frames, sequenceFrame{name, frameInfo.Duration})
+				if err := SavePngWithMetadata(*cv, outpath, metadata); err != nil {
+					return err
+				}
+
+				opts.report(Progress{
+					Stage:      StageEncode,
+					Frame:      frameInfo.Number,
+					FrameCount: info.FrameCount,
+				})
+				return nil
+			})
+	}
+	if err != nil {
+		return err
+	}
+
+	timing, err := writeTimingSidecar(outdir, frames, opts)
+	if timing != "" {
+		written = append(written, timing)
+	}
+	return err
 }
 
 func ConvertWebpToMp4(webp string, out string) error {
+	return ConvertWebpToMp4Context(context.Background(), webp, out, DefaultOptions())
+}
+
+func ConvertWebpToMp4WithOptions(webp string, out string, opts Options) error {
+	return ConvertWebpToMp4Context(context.Background(), webp, out, opts)
+}
+
+// Like ConvertWebpToMp4 but gives up once ctx is done, killing ffmpeg and
+// removing the partial output.
+//
+// MP4 has no room for most WEBP metadata: ICC profile colors are tagged by
+// their primaries and transfer curve when recognized, and only the EXIF
+// description, artist and copyright are kept, as MP4 metadata. The XMP packet,
+// and profiles that can't be tagged, are written next to out with an .xmp and
+// .icc extension.
+func ConvertWebpToMp4Context(
+	ctx context.Context,
+	webp string,
+	out string,
+	opts Options,
+) (err error) {
+	if err := checkOverwrite(out, opts); err != nil {
+		return err
+	}
+	// Fail before compositing every frame for nothing.
+	if _, err := opts.Tools.Lookup(ToolFfmpeg); err != nil {
+		return err
+	}
+
 	frameDir, err := os.MkdirTemp("", "webpfex")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(frameDir)
 
-	info, err := ExtractAWebpInfo(webp)
+	info, err := LoadAWebpInfo(ctx, webp, opts)
 	if err != nil {
 		return err
 	}
+	if opts, err = trimOptions(ctx, webp, info, opts); err != nil {
+		return err
+	}
+	sidecars := mp4MetadataSidecars(info, opts, out)
+	for _, sidecar := range sidecars {
+		if err := checkOverwrite(sidecar.path, opts); err != nil {
+			return err
+		}
+	}
 
-	if err := ExtractWebpFramesAsPng(webp, frameDir); err != nil {
+	sequence := &pngSequenceWriter{dir: frameDir, opts: opts}
+	writer := timingWriter(loopingWriter(retimingWriter(sequence, opts), info, opts), opts)
+	if err := compositeInto(ctx, webp, info, opts, writer); err != nil {
+		return err
+	}
+	list, err := sequence.WriteConcatList()
+	if err != nil {
 		return err
 	}
 
-	frameDuration := info.FrameInfos[0].Duration
-	frameRate := int(1000 / frameDuration.Milliseconds())
+	// ffmpeg has already truncated out by the time it fails.
+	defer func() {
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-framerate", strconv.Itoa(frameRate),
-		"-pattern_type", "glob",
-		"-i", path.Join(frameDir, "*.png"),
-		"-vf", "scale=-2:1080",
+	// Transformed frames keep their size, only padded to the even one x264
+	// needs.
+	scaleFilter := "scale=-2:1080"
+	if len(opts.Transforms) > 0 {
+		scaleFilter = "pad=ceil(iw/2)*2:ceil(ih/2)*2,scale=iw:ih"
+	}
+	colorFilter, colorArgs := ffmpegColorArgs(info, opts)
+	args := []string{
+		"-y",
+		"-nostats",
+		"-progress", "pipe:1",
+		"-f", "concat",
+		"-i", list,
+		"-vf", scaleFilter + colorFilter,
 		"-c:v", "libx264",
 		"-pix_fmt", "yuv420p",
 		"-crf", "18",
 		"-b:v", "5M",
-		out,
+	}
+	if opts.FrameRate.IsZero() {
+		args = append(args, ffmpegFpsModeArgs(ctx, opts, "vfr")...)
+	} else {
+		args = append(args, "-r", opts.FrameRate.String())
+	}
+	args = append(args, colorArgs...)
+	args = append(args, ffmpegMetadataArgs(info.Metadata)...)
+	args = append(args, out)
+
+	progress := makeFfmpegProgressParser(uint32(len(sequence.frames)))
+	_, err = runCommandLines(
+		ctx,
+		opts,
+		func(line string) {
+			if p, ok := progress.Feed(line); ok {
+				opts.report(p)
+			}
+		},
+		ToolFfmpeg,
+		args...,
 	)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg: %s", stderr.String())
+	if err != nil {
+		return err
 	}
 
+	for i, sidecar := range sidecars {
+		if err = os.WriteFile(sidecar.path, sidecar.data, 0644); err != nil {
+			for _, written := range sidecars[:i] {
+				os.Remove(written.path)
+			}
+			return err
+		}
+	}
 	return nil
 }
 
+func ConvertWebpToAWebp(webp string, out string) error {
+	return ConvertWebpToAWebpContext(context.Background(), webp, out, DefaultOptions())
+}
+
+// Like ConvertWebpToAWebp but gives up once ctx is done, removing the partial
+// output.
+func ConvertWebpToAWebpContext(
+	ctx context.Context,
+	webp string,
+	out string,
+	opts Options,
+) (err error) {
+	if err := checkOverwrite(out, opts); err != nil {
+		return err
+	}
+
+	info, err := LoadAWebpInfo(ctx, webp, opts)
+	if err != nil {
+		return err
+	}
+	if opts, err = trimOptions(ctx, webp, info, opts); err != nil {
+		return err
+	}
+	width, height, err := TransformedSize(opts.Transforms, info.Width, info.Height)
+	if err != nil {
+		return err
+	}
+
+	metadata := outputMetadata(info, opts)
+	options := encode.AnimationOptions{
+		Width:           width,
+		Height:          height,
+		BackgroundColor: info.BackgroundColor,
+		LoopCount:       info.LoopCount,
+		ICC:             metadata.ICC,
+		EXIF:            metadata.EXIF,
+		XMP:             metadata.XMP,
+	}
+	return writeAWebp(ctx, out, options, opts, func(w encode.FrameWriter) error {
+		return compositeInto(ctx, webp, info, opts, w)
+	})
+}
+
 // Extract nth frame from an animated WEBP image; indexing starts at 1. Relies
 // on webpmux command.
 func LoadAWebpFrame(path string, n uint32) (canvas.Canvas, error) {
-	cmd := exec.Command(
-		"webpmux",
+	return LoadAWebpFrameContext(context.Background(), path, n)
+}
+
+// Like LoadAWebpFrame but kills webpmux once ctx is done.
+func LoadAWebpFrameContext(ctx context.Context, path string, n uint32) (canvas.Canvas, error) {
+	return loadAWebpFrame(ctx, path, n, DefaultOptions())
+}
+
+func loadAWebpFrame(
+	ctx context.Context,
+	path string,
+	n uint32,
+	opts Options,
+) (canvas.Canvas, error) {
+	stdout, err := runCommand(
+		ctx,
+		opts,
+		ToolWebpmux,
 		"-get", "frame", strconv.FormatUint(uint64(n), 10),
 		path,
 		"-o", "-")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return canvas.Canvas{}, fmt.Errorf("webpmux: %s", stderr.String())
+	if err != nil {
+		return canvas.Canvas{}, err
 	}
 
-	img, _, err := image.Decode(bytes.NewReader(stdout.Bytes()))
+	img, _, err := image.Decode(bytes.NewReader(stdout))
 	if err != nil {
 		return canvas.Canvas{}, err
 	}
@@ -116,19 +320,36 @@ func LoadAWebpFrame(path string, n uint32) (canvas.Canvas, error) {
 
 // Extract metadata from an animated WEBP image. Relies on webpmux command.
 func ExtractAWebpInfo(path string) (AWebpInfo, error) {
-	cmd := exec.Command("webpmux", "-info", path)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return AWebpInfo{}, fmt.Errorf("webpmux: %s", stderr.String())
+	return ExtractAWebpInfoContext(context.Background(), path)
+}
+
+// Like ExtractAWebpInfo but kills webpmux once ctx is done.
+func ExtractAWebpInfoContext(ctx context.Context, path string) (AWebpInfo, error) {
+	return extractAWebpInfo(ctx, path, DefaultOptions())
+}
+
+func extractAWebpInfo(
+	ctx context.Context,
+	path string,
+	opts Options,
+) (AWebpInfo, error) {
+	stdout, err := runCommand(ctx, opts, ToolWebpmux, "-info", path)
+	if err != nil {
+		return AWebpInfo{}, err
 	}
 
-	if info, err := ParseAWebpInfo(stdout.String()); err != nil {
+	info, err := ParseAWebpInfo(string(stdout))
+	if err != nil {
+		return AWebpInfo{}, err
+	}
+
+	chunks, err := ReadWebpChunks(path)
+	if err != nil {
 		return AWebpInfo{}, err
-	} else {
-		return info, nil
 	}
+	info.Metadata = MetadataFromChunks(chunks)
+
+	return info, nil
 }
 
 func LoadWebp(path string) (canvas.Canvas, error) {
@@ -147,15 +368,197 @@ func LoadWebp(path string) (canvas.Canvas, error) {
 }
 
 func SavePng(cv canvas.Canvas, path string) error {
-	writer, err := os.Create(path)
+	return SavePngWithMetadata(cv, path, Metadata{})
+}
+
+// Like SavePng but embeds m as iCCP, eXIf and iTXt chunks.
+func SavePngWithMetadata(cv canvas.Canvas, path string, m Metadata) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, CanvasToNrgba(cv)); err != nil {
+		return err
+	}
+
+	data, err := embedPngMetadata(encoded.Bytes(), m)
 	if err != nil {
 		return err
 	}
-	defer writer.Close()
 
-	if err := png.Encode(writer, CanvasToImage(cv)); err != nil {
+	return os.WriteFile(path, data, 0644)
+}
+
+// Extract metadata from an animated WEBP image, check that its frames lie
+// within the canvas and check it against the limits of opts.
+func LoadAWebpInfo(ctx context.Context, path string, opts Options) (AWebpInfo, error) {
+	// webpmux reports a missing file no differently than a malformed one.
+	if _, err := os.Stat(path); err != nil {
+		return AWebpInfo{}, err
+	}
+
+	info, err := extractAWebpInfo(ctx, path, opts)
+	if err != nil {
+		return AWebpInfo{}, err
+	}
+	if err := checkFrameRects(info); err != nil {
+		return AWebpInfo{}, err
+	}
+	if err := opts.Limits.Check(info); err != nil {
+		return AWebpInfo{}, err
+	}
+
+	return info, nil
+}
+
+// Run the tool called name, as resolved by the tools of opts, with args and
+// return its stdout. The process is killed once ctx is done or the timeout of
+// opts elapses.
+func runCommand(
+	ctx context.Context,
+	opts Options,
+	name string,
+	args ...string,
+) ([]byte, error) {
+	return runCommandLines(ctx, opts, nil, name, args...)
+}
+
+// Like runCommand but also passes each line of stdout to onLine, if not nil,
+// as soon as it is written.
+func runCommandLines(
+	ctx context.Context,
+	opts Options,
+	onLine func(line string),
+	name string,
+	args ...string,
+) ([]byte, error) {
+	var stdout bytes.Buffer
+	var w io.Writer = &stdout
+	if onLine != nil {
+		w = io.MultiWriter(&stdout, &lineWriter{onLine: onLine})
+	}
+	if err := runCommandTo(ctx, opts, w, name, args...); err != nil {
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Like runCommand but writes stdout to w as it comes instead of returning it.
+// w must keep accepting writes until the tool exits or ctx is done.
+func runCommandTo(
+	ctx context.Context,
+	opts Options,
+	w io.Writer,
+	name string,
+	args ...string,
+) error {
+	command, err := opts.Tools.Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.Limits.Timeout
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		if parentErr := parent.Err(); parentErr != nil {
+			return parentErr
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return makeTimeoutError(name, time.Since(start), timeout)
+		}
+
+		return makeCommandError(name, stderr.String(), err)
+	}
+
+	return nil
+}
+
+// Writer calling onLine for every complete line written to it.
+type lineWriter struct {
+	onLine  func(line string)
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.onLine(string(w.pending[:i]))
+		w.pending = w.pending[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Fail with an error wrapping os.ErrExist if path exists and opts forbids
+// overwriting it.
+func checkOverwrite(path string, opts Options) error {
+	if opts.Overwrite {
 		return nil
 	}
+	if _, err := os.Stat(path); err == nil {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+	}
 
 	return nil
 }
+
+// TimeoutError is returned when an external command runs longer than
+// Limits.Timeout allows. It wraps context.DeadlineExceeded.
+type TimeoutError struct {
+	Name    string
+	Elapsed time.Duration
+	Timeout time.Duration
+}
+
+func makeTimeoutError(name string, elapsed, timeout time.Duration) *TimeoutError {
+	e := TimeoutError{name, elapsed, timeout}
+	return &e
+}
+
+func (e TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timed out after %v, limit is %v",
+		e.Name, e.Elapsed.Round(time.Millisecond), e.Timeout)
+}
+
+// CommandError is returned when an external command fails to start or exits
+// unsuccessfully.
+type CommandError struct {
+	Name   string
+	Stderr string
+	Err    error
+}
+
+func makeCommandError(name string, stderr string, err error) *CommandError {
+	e := CommandError{name, stderr, err}
+	return &e
+}
+
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e CommandError) Error() string {
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return fmt.Sprintf("%s: %s", e.Name, stderr)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Name, e.Err)
+}
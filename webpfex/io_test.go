@@ -0,0 +1,47 @@
+package webpfex
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	_, err := runCommand(context.Background(),
+		Options{Limits: Limits{Timeout: 10 * time.Millisecond}}, "sleep", "5")
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expecting TimeoutError, got %v", err)
+	}
+	if timeoutErr.Name != "sleep" || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Errorf("Expecting sleep timing out after 10ms, got %+v", timeoutErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expecting the error to wrap context.DeadlineExceeded")
+	}
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		t.Error("Expecting a timeout not to be a LimitError")
+	}
+}
+
+func TestRunCommandCancelled(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := runCommand(ctx, Options{Limits: Limits{Timeout: time.Minute}}, "sleep", "5")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package webpfex
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits bounds the resources spent on a single animated WEBP. Zero fields
+// are not enforced.
+type Limits struct {
+	MaxWidth         uint32
+	MaxHeight        uint32
+	MaxFrameCount    uint32
+	MaxTotalPixels   uint64 // Canvas pixels summed over every frame.
+	MaxTotalDuration time.Duration
+	MaxMemory        uint64 // Estimated bytes held while compositing.
+	// Wall-clock limit of each webpmux/ffmpeg run, exceeding it fails with a
+	// *TimeoutError rather than a *LimitError.
+	Timeout time.Duration
+}
+
+// Check info against l, returning a *LimitError on the first violation.
+func (l Limits) Check(info AWebpInfo) error {
+	if l.MaxWidth != 0 && info.Width > l.MaxWidth {
+		return makeLimitError("width", uint64(info.Width), uint64(l.MaxWidth))
+	}
+	if l.MaxHeight != 0 && info.Height > l.MaxHeight {
+		return makeLimitError("height", uint64(info.Height), uint64(l.MaxHeight))
+	}
+	if l.MaxFrameCount != 0 && info.FrameCount > l.MaxFrameCount {
+		return makeLimitError(
+			"frame count", uint64(info.FrameCount), uint64(l.MaxFrameCount))
+	}
+	if pixels := TotalPixels(info); l.MaxTotalPixels != 0 && pixels > l.MaxTotalPixels {
+		return makeLimitError("total pixels", pixels, l.MaxTotalPixels)
+	}
+	if duration := TotalDuration(info); l.MaxTotalDuration != 0 &&
+		duration > l.MaxTotalDuration {
+		return makeLimitError("total duration (ms)",
+			uint64(duration.Milliseconds()), uint64(l.MaxTotalDuration.Milliseconds()))
+	}
+	if memory := EstimateMemory(info); l.MaxMemory != 0 && memory > l.MaxMemory {
+		return makeLimitError("memory (bytes)", memory, l.MaxMemory)
+	}
+
+	return nil
+}
+
+// Number of canvas pixels composited over all frames of info.
+func TotalPixels(info AWebpInfo) uint64 {
+	return uint64(info.Width) * uint64(info.Height) * uint64(info.FrameCount)
+}
+
+// Sum of all frame durations of info.
+func TotalDuration(info AWebpInfo) time.Duration {
+	var total time.Duration
+	for _, fi := range info.FrameInfos {
+		total += fi.Duration
+	}
+
+	return total
+}
+
+// Estimate the bytes held at once while compositing info: the canvas plus the
+// largest frame, both as decoded image and as canvas.
+func EstimateMemory(info AWebpInfo) uint64 {
+	const canvasPixelSize = 8 // canvas.Canvas stores a uint64 per pixel.
+	const imagePixelSize = 4  // Decoded WEBP frames are 8-bit per channel.
+
+	var largestFrame uint64
+	for _, fi := range info.FrameInfos {
+		if area := uint64(fi.Width) * uint64(fi.Height); area > largestFrame {
+			largestFrame = area
+		}
+	}
+
+	canvasArea := uint64(info.Width) * uint64(info.Height)
+	return canvasArea*canvasPixelSize + largestFrame*(canvasPixelSize+imagePixelSize)
+}
+
+// LimitError is returned when an input exceeds one of the configured Limits.
+type LimitError struct {
+	Limit string
+	Value uint64
+	Max   uint64
+}
+
+func makeLimitError(limit string, value, max uint64) *LimitError {
+	e := LimitError{limit, value, max}
+	return &e
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("LimitError: %s %d exceeds maximum %d", e.Limit, e.Value, e.Max)
+}
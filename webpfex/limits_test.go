@@ -0,0 +1,54 @@
+package webpfex
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	info, err := ParseAWebpInfo(AWEBP_INFO_DUMMY)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := (Limits{}).Check(info); err != nil {
+		t.Errorf("Expecting zero Limits to pass, got %v", err)
+	}
+
+	cases := []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{MaxWidth: 639}, "width"},
+		{Limits{MaxHeight: 639}, "height"},
+		{Limits{MaxFrameCount: 7}, "frame count"},
+		{Limits{MaxTotalPixels: 640*640*8 - 1}, "total pixels"},
+		{Limits{MaxTotalDuration: 399 * time.Millisecond}, "total duration (ms)"},
+		{Limits{MaxMemory: 1024}, "memory (bytes)"},
+	}
+	for _, c := range cases {
+		err := c.limits.Check(info)
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("Expecting LimitError for %s, got %v", c.limit, err)
+			continue
+		}
+		if limitErr.Limit != c.limit {
+			t.Errorf("Expecting limit %q got %q", c.limit, limitErr.Limit)
+		}
+	}
+
+	within := Limits{
+		MaxWidth:         640,
+		MaxHeight:        640,
+		MaxFrameCount:    8,
+		MaxTotalPixels:   640 * 640 * 8,
+		MaxTotalDuration: 400 * time.Millisecond,
+		MaxMemory:        EstimateMemory(info),
+	}
+	if err := within.Check(info); err != nil {
+		t.Errorf("Expecting limits at the boundary to pass, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package webpfex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"webpfex/encode"
+)
+
+// Severity of a LintFinding.
+type Severity int
+
+const (
+	// Worth knowing, harmless.
+	SeverityInfo Severity = iota
+	// Decoders cope, but may not show what was intended.
+	SeverityWarning
+	// Breaks the container spec, decoders may reject the file.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// LintFinding is a problem LintAWebp found.
+type LintFinding struct {
+	Severity Severity
+	Check    string // What was checked, like zero-duration.
+	Frame    uint32 // Number of the frame concerned from 1, 0 if none.
+	Offset   int64  // Of the chunk concerned within the file, -1 if none.
+	Message  string
+}
+
+// Metadata chunks and the VP8X flags saying they're present.
+var metadataFlags = []struct {
+	fourCC string
+	flag   byte
+}{
+	{"ICCP", Vp8xFlagIcc},
+	{"EXIF", Vp8xFlagExif},
+	{"XMP ", Vp8xFlagXmp},
+}
+
+// Chunks an animated WEBP may hold directly.
+var knownChunks = map[string]bool{
+	"VP8X": true, "ANIM": true, "ANMF": true, "ICCP": true, "EXIF": true, "XMP ": true,
+}
+
+func LintAWebpFile(path string) ([]LintFinding, error) {
+	return LintAWebpFileContext(context.Background(), path, DefaultOptions())
+}
+
+// Check the animated WEBP at path against the container spec, and against what
+// webpmux reports about it when it's available.
+func LintAWebpFileContext(ctx context.Context, path string, opts Options) ([]LintFinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []LintFinding
+	var info *AWebpInfo
+	if _, err := opts.Tools.Lookup(ToolWebpmux); err != nil {
+		findings = append(findings, LintFinding{SeverityInfo, "webpmux", 0, -1,
+			"webpmux is missing, not checking what it reports"})
+	} else if extracted, err := extractAWebpInfo(ctx, path, opts); err == nil {
+		info = &extracted
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else {
+		findings = append(findings, LintFinding{SeverityError, "webpmux", 0, -1,
+			fmt.Sprintf("webpmux can't read the file: %v", err)})
+	}
+
+	return append(findings, LintAWebp(data, info)...), nil
+}
+
+// Check the animated WEBP file data against the container spec. info is what
+// webpmux reports about it, checked against the chunks, or nil.
+func LintAWebp(data []byte, info *AWebpInfo) []LintFinding {
+	var findings []LintFinding
+	report := func(severity Severity, check string, frame uint32, offset int64,
+		format string, args ...interface{}) {
+		findings = append(findings,
+			LintFinding{severity, check, frame, offset, fmt.Sprintf(format, args...)})
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		report(SeverityError, "not-webp", 0, 0, "not a RIFF WEBP file")
+		return findings
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(data[4:8]))
+	if end > int64(len(data)) {
+		report(SeverityError, "truncated-file", 0, 0,
+			"the RIFF header declares %d bytes but the file has %d", end, len(data))
+		end = int64(len(data))
+	} else if end < int64(len(data)) {
+		report(SeverityWarning, "trailing-data", 0, end,
+			"%d bytes follow the RIFF chunk", int64(len(data))-end)
+	}
+
+	chunks, err := parseChunks(data, 12, end)
+	if err != nil {
+		offset := int64(12)
+		if len(chunks) > 0 {
+			last := chunks[len(chunks)-1]
+			offset = last.Offset + 8 + int64(last.Size) + int64(last.Size%2)
+		}
+		report(SeverityError, "truncated-chunk", 0, offset, "%v", err)
+	}
+
+	counts := map[string]int{}
+	var anmfs []Chunk
+	for _, c := range chunks {
+		counts[c.FourCC]++
+		switch {
+		case c.FourCC == "ANMF":
+			anmfs = append(anmfs, c)
+		case c.FourCC == "VP8 " || c.FourCC == "VP8L" || c.FourCC == "ALPH":
+			report(SeverityError, "misplaced-image", 0, c.Offset,
+				"%q chunk outside of a frame", c.FourCC)
+		case !knownChunks[c.FourCC]:
+			report(SeverityInfo, "unknown-chunk", 0, c.Offset,
+				"unknown %q chunk, ignored by decoders", c.FourCC)
+		case counts[c.FourCC] == 2:
+			report(SeverityWarning, "duplicate-chunk", 0, c.Offset,
+				"more than one %q chunk, only the first counts", c.FourCC)
+		}
+	}
+
+	vp8xChunk, ok := findChunk(chunks, "VP8X")
+	if !ok {
+		report(SeverityError, "missing-vp8x", 0, -1,
+			"no VP8X chunk, without which a WEBP can't be animated nor hold metadata")
+		return findings
+	}
+	if chunks[0].FourCC != "VP8X" {
+		report(SeverityError, "chunk-order", 0, vp8xChunk.Offset, "VP8X isn't the first chunk")
+	}
+	vp8x, err := ParseVp8xHeader(vp8xChunk.Payload)
+	if err != nil {
+		report(SeverityError, "truncated-chunk", 0, vp8xChunk.Offset, "%v", err)
+		return findings
+	}
+
+	animated := vp8x.Flags&Vp8xFlagAnimation != 0
+	switch {
+	case !animated && len(anmfs) > 0:
+		report(SeverityError, "missing-flag", 0, vp8xChunk.Offset,
+			"VP8X lacks the animation flag, yet there are %d frames", len(anmfs))
+	case animated && len(anmfs) == 0:
+		report(SeverityError, "no-frames", 0, vp8xChunk.Offset,
+			"VP8X has the animation flag, yet there are no ANMF chunks")
+	}
+	animChunk, hasAnim := findChunk(chunks, "ANIM")
+	var anim AnimHeader
+	if !hasAnim && len(anmfs) > 0 {
+		report(SeverityError, "missing-anim", 0, -1, "no ANIM chunk, yet there are frames")
+	} else if hasAnim {
+		if anim, err = ParseAnimHeader(animChunk.Payload); err != nil {
+			report(SeverityError, "truncated-chunk", 0, animChunk.Offset, "%v", err)
+			hasAnim = false
+		}
+	}
+	for _, c := range chunks {
+		if !hasAnim || c.FourCC != "ANIM" || c.Offset == animChunk.Offset {
+			continue
+		}
+		// Which one counts is left to decoders, which may loop differently.
+		if other, err := ParseAnimHeader(c.Payload); err == nil && other.LoopCount != anim.LoopCount {
+			report(SeverityWarning, "loop-count-mismatch", 0, c.Offset,
+				"another ANIM chunk says a loop count of %d, the first %d",
+				other.LoopCount, anim.LoopCount)
+		}
+	}
+	for _, m := range metadataFlags {
+		c, present := findChunk(chunks, m.fourCC)
+		flagged := vp8x.Flags&m.flag != 0
+		if present && !flagged {
+			report(SeverityWarning, "unused-metadata", 0, c.Offset,
+				"%q chunk ignored by decoders, VP8X lacks its flag", m.fourCC)
+		} else if flagged && !present {
+			report(SeverityWarning, "stale-flag", 0, vp8xChunk.Offset,
+				"VP8X flags a %q chunk that isn't there", m.fourCC)
+		}
+	}
+
+	frameInfos := make([]AWebpFrameInfo, 0, len(anmfs))
+	offsets := make([]int64, 0, len(anmfs))
+	alpha := false
+	for i, c := range anmfs {
+		number := uint32(i + 1)
+		frameInfo, err := parseAnmfFrameInfo(c, number)
+		if err != nil {
+			report(SeverityError, "truncated-chunk", number, c.Offset, "%v", err)
+			continue
+		}
+		frameChunks, _ := parseChunks(c.Payload, anmfHeaderSize, int64(len(c.Payload)))
+		width, height, frameAlpha, ok := bitstreamInfo(frameChunks)
+		if !ok {
+			report(SeverityError, "missing-image", number, c.Offset,
+				"no readable VP8 or VP8L bitstream")
+		} else if width != frameInfo.Width || height != frameInfo.Height {
+			report(SeverityError, "frame-size-mismatch", number, c.Offset,
+				"the bitstream is %dx%d, the ANMF header says %dx%d",
+				width, height, frameInfo.Width, frameInfo.Height)
+		}
+		alpha = alpha || frameAlpha
+		frameInfos = append(frameInfos, frameInfo)
+		offsets = append(offsets, c.Offset)
+	}
+	if alpha && vp8x.Flags&Vp8xFlagAlpha == 0 {
+		report(SeverityWarning, "missing-flag", 0, vp8xChunk.Offset,
+			"frames have alpha but VP8X lacks the alpha flag")
+	}
+	if info != nil {
+		if hasAnim && info.LoopCount != anim.LoopCount {
+			report(SeverityError, "loop-count-mismatch", 0, animChunk.Offset,
+				"webpmux reports a loop count of %d, the ANIM chunk says %d",
+				info.LoopCount, anim.LoopCount)
+		}
+		if int(info.FrameCount) != len(anmfs) {
+			report(SeverityError, "frame-count-mismatch", 0, -1,
+				"webpmux reports %d frames, there are %d ANMF chunks",
+				info.FrameCount, len(anmfs))
+		}
+		if info.Width != vp8x.Width || info.Height != vp8x.Height {
+			report(SeverityError, "canvas-mismatch", 0, vp8xChunk.Offset,
+				"webpmux reports a %dx%d canvas, VP8X says %dx%d",
+				info.Width, info.Height, vp8x.Width, vp8x.Height)
+		}
+		for i, f := range frameInfos {
+			if int(f.Number) > len(info.FrameInfos) {
+				break
+			}
+			reported := info.FrameInfos[f.Number-1]
+			// ANMF chunks store offsets halved, webpmux can't have read these.
+			if reported.XOffset%2 == 1 || reported.YOffset%2 == 1 {
+				report(SeverityError, "odd-offset", f.Number, offsets[i],
+					"webpmux reports an offset of %d, %d, the container only stores even ones",
+					reported.XOffset, reported.YOffset)
+			}
+			// Alpha is left out, webpmux tells it from the bitstream features.
+			reported.Alpha = f.Alpha
+			if reported != f {
+				report(SeverityError, "frame-mismatch", f.Number, offsets[i],
+					"webpmux reports %dx%d at %d, %d for %v, the ANMF header %dx%d at %d, %d for %v",
+					reported.Width, reported.Height, reported.XOffset, reported.YOffset,
+					reported.Duration, f.Width, f.Height, f.XOffset, f.YOffset, f.Duration)
+			}
+		}
+	}
+
+	for i, f := range frameInfos {
+		offset := offsets[i]
+		if f.Width > vp8x.Width || f.Height > vp8x.Height {
+			report(SeverityError, "oversized-frame", f.Number, offset,
+				"%dx%d, larger than the %dx%d canvas", f.Width, f.Height, vp8x.Width, vp8x.Height)
+		} else if uint64(f.XOffset)+uint64(f.Width) > uint64(vp8x.Width) ||
+			uint64(f.YOffset)+uint64(f.Height) > uint64(vp8x.Height) {
+			report(SeverityError, "frame-outside-canvas", f.Number, offset,
+				"%dx%d at %d, %d, past the %dx%d canvas",
+				f.Width, f.Height, f.XOffset, f.YOffset, vp8x.Width, vp8x.Height)
+		}
+		if f.Duration == 0 {
+			report(SeverityWarning, "zero-duration", f.Number, offset,
+				"lasts 0ms, which browsers show for %v", encode.BrowserClampDuration)
+		} else if f.Duration <= encode.BrowserClampMax {
+			report(SeverityWarning, "short-duration", f.Number, offset,
+				"lasts %v, which browsers show for %v", f.Duration, encode.BrowserClampDuration)
+		}
+	}
+
+	return findings
+}
+
+// Number of findings of severity.
+func CountFindings(findings []LintFinding, severity Severity) int {
+	n := 0
+	for _, f := range findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"webpfex/encode"
+)
+
+func findingChecks(findings []LintFinding) map[string]uint32 {
+	checks := map[string]uint32{}
+	for _, f := range findings {
+		checks[f.Check] = f.Frame
+	}
+	return checks
+}
+
+func TestLintAWebp(t *testing.T) {
+	ms := time.Millisecond
+	valid := encodeTestAWebp(t, encode.AnimationOptions{}, 100*ms, 100*ms, 100*ms)
+	if findings := LintAWebp(valid, nil); len(findings) != 0 {
+		t.Errorf("Expecting no findings for a valid animation, got %+v", findings)
+	}
+
+	chunks, err := ParseWebpChunks(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp8x := chunks[0]
+	var anmfs []Chunk
+	for _, c := range chunks {
+		if c.FourCC == "ANMF" {
+			anmfs = append(anmfs, c)
+		}
+	}
+	mutate := func(f func(data []byte)) []byte {
+		data := append([]byte(nil), valid...)
+		f(data)
+		return data
+	}
+
+	// What webpmux would report about other files.
+	parseInfo := func(data []byte) *AWebpInfo {
+		chunks, err := ParseWebpChunks(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ParseAWebpInfoChunks(chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &info
+	}
+	retimed := parseInfo(encodeTestAWebp(t, encode.AnimationOptions{}, 100*ms, 0, 100*ms))
+	shorter := parseInfo(encodeTestAWebp(t, encode.AnimationOptions{}, 100*ms, 100*ms))
+
+	looping := parseInfo(encodeTestAWebp(t, encode.AnimationOptions{LoopCount: 3},
+		100*ms, 100*ms, 100*ms))
+	oddOffset := parseInfo(valid)
+	oddOffset.FrameInfos[1].XOffset = 1
+
+	// Another ANIM chunk, looping 5 times, after the first.
+	var anim Chunk
+	for _, c := range chunks {
+		if c.FourCC == "ANIM" {
+			anim = c
+		}
+	}
+	animEnd := anim.Offset + 8 + int64(anim.Size)
+	secondAnim := append([]byte("ANIM\x06\x00\x00\x00"), 0, 0, 0, 0, 5, 0)
+	twoAnims := append(append(append([]byte(nil), valid[:animEnd]...), secondAnim...),
+		valid[animEnd:]...)
+	binary.LittleEndian.PutUint32(twoAnims[4:], uint32(len(twoAnims)-8))
+
+	withExif := encodeTestAWebp(t, encode.AnimationOptions{EXIF: []byte("exif")}, 100*ms)
+	withExif[vp8x.Offset+8] &^= Vp8xFlagExif
+
+	cases := []struct {
+		name     string
+		data     []byte
+		info     *AWebpInfo
+		expected map[string]uint32
+	}{
+		{"not webp", []byte("GIF89a"), nil, map[string]uint32{"not-webp": 0}},
+		{
+			"truncated",
+			valid[:len(valid)-5],
+			nil,
+			map[string]uint32{"truncated-file": 0, "truncated-chunk": 0},
+		},
+		{
+			"zero duration",
+			encodeTestAWebp(t, encode.AnimationOptions{}, 100*ms, 0, 5*ms),
+			nil,
+			map[string]uint32{"zero-duration": 2, "short-duration": 3},
+		},
+		{
+			"outside",
+			mutate(func(data []byte) { data[anmfs[1].Offset+8] = 1 }),
+			nil,
+			map[string]uint32{"frame-outside-canvas": 2},
+		},
+		{
+			"flags",
+			mutate(func(data []byte) { data[vp8x.Offset+8] = Vp8xFlagXmp }),
+			nil,
+			map[string]uint32{"missing-flag": 0, "stale-flag": 0},
+		},
+		{"unused metadata", withExif, nil, map[string]uint32{"unused-metadata": 0}},
+		{
+			"oversized",
+			// The width of the second frame.
+			mutate(func(data []byte) { data[anmfs[1].Offset+8+6] = 7 }),
+			nil,
+			map[string]uint32{"oversized-frame": 2, "frame-size-mismatch": 2},
+		},
+		// Frames are checked as stored, not as webpmux reports them.
+		{"webpmux frame", valid, retimed, map[string]uint32{"frame-mismatch": 2}},
+		{"webpmux count", valid, shorter, map[string]uint32{"frame-count-mismatch": 0}},
+		{"webpmux loops", valid, looping, map[string]uint32{"loop-count-mismatch": 0}},
+		{
+			"webpmux odd offset",
+			valid,
+			oddOffset,
+			map[string]uint32{"odd-offset": 2, "frame-mismatch": 2},
+		},
+		{
+			"anim loops",
+			twoAnims,
+			nil,
+			map[string]uint32{"duplicate-chunk": 0, "loop-count-mismatch": 0},
+		},
+	}
+	for _, c := range cases {
+		checks := findingChecks(LintAWebp(c.data, c.info))
+		for check, frame := range c.expected {
+			if got, ok := checks[check]; !ok || got != frame {
+				t.Errorf("%s: expecting %s for frame %d, got %v", c.name, check, frame, checks)
+			}
+		}
+		if len(checks) != len(c.expected) {
+			t.Errorf("%s: expecting only %v, got %v", c.name, c.expected, checks)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package webpfex
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Metadata holds the raw metadata chunks of a WEBP, nil when absent.
+type Metadata struct {
+	ICC  []byte // ICCP chunk: an ICC color profile.
+	EXIF []byte // EXIF chunk: a TIFF structure, possibly after "Exif\0\0".
+	XMP  []byte // XMP chunk: an XML packet.
+}
+
+func (m Metadata) Empty() bool {
+	return m.ICC == nil && m.EXIF == nil && m.XMP == nil
+}
+
+// Collect the metadata chunks among chunks.
+func MetadataFromChunks(chunks []Chunk) Metadata {
+	var m Metadata
+	if c, ok := findChunk(chunks, "ICCP"); ok {
+		m.ICC = c.Payload
+	}
+	if c, ok := findChunk(chunks, "EXIF"); ok {
+		m.EXIF = c.Payload
+	}
+	if c, ok := findChunk(chunks, "XMP "); ok {
+		m.XMP = c.Payload
+	}
+
+	return m
+}
+
+// Write each present metadata as metadata.icc, metadata.exif or metadata.xmp
+// in dir, returning the written paths.
+func WriteMetadataSidecars(m Metadata, dir string, opts Options) ([]string, error) {
+	var written []string
+	for _, sidecar := range []struct {
+		name string
+		data []byte
+	}{
+		{"metadata.icc", m.ICC},
+		{"metadata.exif", m.EXIF},
+		{"metadata.xmp", m.XMP},
+	} {
+		if sidecar.data == nil {
+			continue
+		}
+
+		p := path.Join(dir, sidecar.name)
+		if err := checkOverwrite(p, opts); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(p, sidecar.data, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, p)
+	}
+
+	return written, nil
+}
+
+// metadataSidecar is metadata written next to an output that can't hold it.
+type metadataSidecar struct {
+	path string
+	data []byte
+}
+
+// The sidecars carrying what an MP4 at out can't of info's metadata under
+// opts: the XMP packet as out with an .xmp extension, where XMP readers look
+// for it, and an ICC profile whose colors ffmpegColorArgs can't fully tag
+// as out with an .icc extension. The EXIF tags ffmpegMetadataArgs doesn't map
+// are lost.
+func mp4MetadataSidecars(info AWebpInfo, opts Options, out string) []metadataSidecar {
+	base := strings.TrimSuffix(out, filepath.Ext(out))
+	var sidecars []metadataSidecar
+	if icc := outputMetadata(info, opts).ICC; icc != nil {
+		_, args := ffmpegColorArgs(info, opts)
+		tagged := false
+		for _, arg := range args {
+			tagged = tagged || arg == "-color_trc"
+		}
+		if !tagged {
+			sidecars = append(sidecars, metadataSidecar{base + ".icc", icc})
+		}
+	}
+	if info.Metadata.XMP != nil {
+		sidecars = append(sidecars, metadataSidecar{base + ".xmp", info.Metadata.XMP})
+	}
+
+	return sidecars
+}
+
+// Insert m into the PNG stream png as iCCP, eXIf and iTXt chunks following
+// IHDR.
+func embedPngMetadata(png []byte, m Metadata) ([]byte, error) {
+	const signatureSize = 8
+	const ihdrSize = 8 + 13 + 4 // Length and type, data, CRC.
+	if m.Empty() {
+		return png, nil
+	}
+
+	var chunks bytes.Buffer
+	if m.ICC != nil {
+		var data bytes.Buffer
+		data.WriteString("ICC Profile\x00\x00") // Name and deflate method.
+		z := zlib.NewWriter(&data)
+		if _, err := z.Write(m.ICC); err != nil {
+			return nil, err
+		}
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+		writePngChunk(&chunks, "iCCP", data.Bytes())
+	}
+	if m.EXIF != nil {
+		writePngChunk(&chunks, "eXIf", bytes.TrimPrefix(m.EXIF, []byte("Exif\x00\x00")))
+	}
+	if m.XMP != nil {
+		// Keyword, then uncompressed with empty language and translated keyword.
+		data := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), m.XMP...)
+		writePngChunk(&chunks, "iTXt", data)
+	}
+
+	split := signatureSize + ihdrSize
+	out := make([]byte, 0, len(png)+chunks.Len())
+	out = append(out, png[:split]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, png[split:]...), nil
+}
+
+func writePngChunk(w *bytes.Buffer, chunkType string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], chunkType)
+	w.Write(header[:])
+	w.Write(data)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// Descriptive EXIF tags of IFD0 and the ffmpeg metadata keys they map to.
+var exifMetadataKeys = map[uint16]string{
+	0x010E: "description", // ImageDescription
+	0x013B: "artist",      // Artist
+	0x8298: "copyright",   // Copyright
+}
+
+// Parse the ASCII tags of IFD0 in exif that have an ffmpeg metadata key, keyed
+// by it. Malformed EXIF yields whatever was parsed before the damage.
+func ParseExifMetadata(exif []byte) map[string]string {
+	tags := make(map[string]string)
+	tiff := bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	if len(tiff) < 8 {
+		return tags
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return tags
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return tags
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		tag := order.Uint16(tiff[entry:])
+		valueType := order.Uint16(tiff[entry+2:])
+		length := int(order.Uint32(tiff[entry+4:]))
+		key, wanted := exifMetadataKeys[tag]
+		if !wanted || valueType != 2 { // 2 is ASCII.
+			continue
+		}
+
+		// Values of up to 4 bytes are stored in place of their offset.
+		value := entry + 8
+		if length > 4 {
+			value = int(order.Uint32(tiff[entry+8:]))
+		}
+		if length < 0 || value < 0 || value+length > len(tiff) {
+			continue
+		}
+
+		text := string(bytes.TrimRight(tiff[value:value+length], "\x00 "))
+		if text != "" {
+			tags[key] = text
+		}
+	}
+
+	return tags
+}
+
+// ffmpeg arguments carrying m into the output's metadata.
+func ffmpegMetadataArgs(m Metadata) []string {
+	tags := ParseExifMetadata(m.EXIF)
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags[key])
+	}
+
+	return args
+}
@@ -0,0 +1,101 @@
+package webpfex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"reflect"
+	"testing"
+	"webpfex/canvas"
+)
+
+// Little-endian TIFF with an IFD0 holding a long Artist and a short Copyright.
+func makeExifDummy() []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, le, uint32(8))
+	binary.Write(&tiff, le, uint16(2))
+
+	artist := "Jane Doe\x00"
+	artistOffset := uint32(8 + 2 + 2*12 + 4)
+	binary.Write(&tiff, le, []uint16{0x013B, 2})
+	binary.Write(&tiff, le, []uint32{uint32(len(artist)), artistOffset})
+	binary.Write(&tiff, le, []uint16{0x8298, 2})
+	binary.Write(&tiff, le, uint32(3))
+	tiff.WriteString("CC\x00\x00")
+	binary.Write(&tiff, le, uint32(0))
+	tiff.WriteString(artist)
+
+	return append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+}
+
+func TestParseExifMetadata(t *testing.T) {
+	tags := ParseExifMetadata(makeExifDummy())
+
+	if tags["artist"] != "Jane Doe" {
+		t.Errorf("Expecting artist %q got %q", "Jane Doe", tags["artist"])
+	}
+	if tags["copyright"] != "CC" {
+		t.Errorf("Expecting copyright %q got %q", "CC", tags["copyright"])
+	}
+
+	args := ffmpegMetadataArgs(Metadata{EXIF: makeExifDummy()})
+	expected := []string{"-metadata", "artist=Jane Doe", "-metadata", "copyright=CC"}
+	if len(args) != len(expected) {
+		t.Fatalf("Expecting %q got %q", expected, args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("Expecting %q got %q", expected, args)
+		}
+	}
+
+	if tags := ParseExifMetadata([]byte("II*\x00\xff\xff")); len(tags) != 0 {
+		t.Errorf("Expecting no tags from malformed EXIF, got %v", tags)
+	}
+}
+
+func TestEmbedPngMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	png.Encode(&encoded, CanvasToImage(canvas.MakeCanvas(2, 2)))
+	m := Metadata{ICC: []byte("profile"), EXIF: makeExifDummy(), XMP: []byte("<x:xmpmeta/>")}
+
+	data, err := embedPngMetadata(encoded.Bytes(), m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, chunkType := range []string{"iCCP", "eXIf", "iTXt"} {
+		if !bytes.Contains(data, []byte(chunkType)) {
+			t.Errorf("Expecting %s chunk", chunkType)
+		}
+	}
+	if bytes.Contains(data, []byte("Exif\x00\x00")) {
+		t.Errorf("Expecting eXIf without the Exif prefix")
+	}
+	// The decoder verifies the CRC of every chunk.
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("Expecting a valid PNG, got %v", err)
+	}
+}
+
+func TestMp4MetadataSidecars(t *testing.T) {
+	p3 := makeIccDummy("Display P3", knownPrimaries["smpte432"])
+	info := AWebpInfo{Metadata: Metadata{ICC: p3, XMP: []byte("<x/>")}}
+	sidecars := mp4MetadataSidecars(info, Options{}, "out/a.mp4")
+	expected := []metadataSidecar{{"out/a.xmp", []byte("<x/>")}}
+	if !reflect.DeepEqual(sidecars, expected) {
+		t.Errorf("Expecting only the XMP packet next to a tagged P3 video, got %v", sidecars)
+	}
+
+	odd := makeIccDummy("Odd", [3][3]float64{{0.5, 0.3, 0.1}, {0.3, 0.6, 0.1}, {0, 0.1, 0.7}})
+	info = AWebpInfo{Metadata: Metadata{ICC: odd}}
+	sidecars = mp4MetadataSidecars(info, Options{}, "a.mp4")
+	if len(sidecars) != 1 || sidecars[0].path != "a.icc" {
+		t.Errorf("Expecting an untaggable profile next to the video, got %v", sidecars)
+	}
+	if sidecars := mp4MetadataSidecars(info, Options{Color: ColorToSrgb}, "a.mp4"); sidecars != nil {
+		t.Errorf("Expecting no profile once colors are converted, got %v", sidecars)
+	}
+}
@@ -0,0 +1,59 @@
+package webpfex
+
+import (
+	"time"
+	"webpfex/encode"
+)
+
+// Options tunes how animated WEBPs are extracted and converted.
+type Options struct {
+	Limits    Limits
+	Tools     Tools
+	Progress  ProgressFunc
+	Savings   SavingsFunc
+	Overwrite bool // Replace existing outputs instead of failing.
+	Color     ColorMode
+	Blend     BlendMode
+	// Merge consecutive converted frames whose channels differ by at most
+	// CoalesceThreshold out of 255, summing their durations.
+	Coalesce          bool
+	CoalesceThreshold uint8
+	// Resample to a constant frame rate when not zero, cross-fading frames
+	// rather than duplicating or dropping them if CrossFade.
+	FrameRate encode.FrameRate
+	CrossFade bool
+	// How the durations of input frames are read, before anything else.
+	TimingPolicy TimingPolicy
+	// Then set the durations of frames Durations gives, by
+	// frame number from 1 rather than name, play them Speed times faster
+	// unless 0, and last to first if Reverse.
+	Durations *Timing
+	Speed     float64
+	Reverse   bool
+	// Play the animation Loops times when converting, or if 0 as many times as
+	// its loop count says when finite and otherwise once, then more until it
+	// lasts MinDuration, forward then backward each time if Boomerang.
+	Loops       int
+	MinDuration time.Duration
+	Boomerang   bool
+	// Applied in order to every composited frame.
+	Transforms []Transform
+	// Crop frames to the TrimBox of the animation, grown by TrimPadding, before
+	// the Transforms.
+	Trim        bool
+	TrimPadding uint32
+	// Flatten frames onto it, once transformed, when not nil.
+	Background Background
+	// When writing animated WEBPs, store only what changes between frames if
+	// Optimize, and encode frames lossily with ffmpeg's libwebp at Quality,
+	// from 0 to 100, if Lossy.
+	Optimize bool
+	Lossy    bool
+	Quality  int
+}
+
+// Options matching the behavior of the plain, option-less functions, which
+// replace existing outputs.
+func DefaultOptions() Options {
+	return Options{Overwrite: true}
+}
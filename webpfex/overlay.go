@@ -1,6 +1,11 @@
 package webpfex
 
-import "webpfex/canvas"
+import (
+	"fmt"
+	"image"
+	"math"
+	"webpfex/canvas"
+)
 
 // Clear canvas with color.
 func ClearCanvas(canvas *canvas.Canvas, color canvas.Color) {
@@ -20,27 +25,134 @@ func OverlayCanvas(canvas *canvas.Canvas, with *canvas.Canvas, xOffset, yOffset
 	}
 }
 
+// Clear the area of canvas within rect with color.
+func ClearCanvasRect(canvas *canvas.Canvas, rect image.Rectangle, color canvas.Color) {
+	rect = rect.Intersect(image.Rect(0, 0, int(canvas.Width()), int(canvas.Height())))
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			canvas.WriteAt(uint32(x), uint32(y), color)
+		}
+	}
+}
+
 // Overlay canvas with another by blending the overlay's canvas with the original.
 func OverlayBlendCanvas(canvas *canvas.Canvas, with *canvas.Canvas, xOffset, yOffset uint32) {
+	overlayBlendCanvas(canvas, with, xOffset, yOffset, image.Rectangle{}, OverlayColor)
+}
+
+// Blend with onto canvas using blend, except within copied where pixels are
+// replaced. libwebp replaces pixels of the area the previous frame disposed
+// instead of blending them with transparency, which rounds differently.
+func overlayBlendCanvas(
+	canvas *canvas.Canvas,
+	with *canvas.Canvas,
+	xOffset, yOffset uint32,
+	copied image.Rectangle,
+	blend func(color canvas.Color, with canvas.Color) canvas.Color,
+) {
 	for y := uint32(0); y < with.Height(); y++ {
 		for x := uint32(0); x < with.Width(); x++ {
 			dstX := x + xOffset
 			dstY := y + yOffset
 
 			overlay := with.At(x, y)
-			color := canvas.At(dstX, dstY)
-			blended := OverlayColor(color, overlay)
+			if (image.Point{int(dstX), int(dstY)}).In(copied) {
+				canvas.WriteAt(dstX, dstY, overlay)
+				continue
+			}
 
-			canvas.WriteAt(dstX, dstY, blended)
+			color := canvas.At(dstX, dstY)
+			canvas.WriteAt(dstX, dstY, blend(color, overlay))
 		}
 	}
 }
 
+// Composite with over color the way libwebp's WebPAnimDecoder does, bit for bit
+// at 8 bits per channel.
 func OverlayColor(color canvas.Color, with canvas.Color) canvas.Color {
+	srcA := uint32(with.A() >> 8)
+	if srcA == 0 {
+		return color
+	} else if srcA == 0xFF {
+		// Opaque pixels are copied, the arithmetic below would darken them.
+		return with
+	}
+
+	dstA := uint32(color.A() >> 8)
+	dstFactorA := (dstA * (256 - srcA)) >> 8
+	blendA := srcA + dstFactorA
+	scale := (uint32(1) << 24) / blendA
+
+	blend := func(src, dst uint16) uint16 {
+		unscaled := uint32(src>>8)*srcA + uint32(dst>>8)*dstFactorA
+		return uint16((uint64(unscaled)*uint64(scale))>>24) * 0x101
+	}
+
+	return canvas.MakeColorRgba(
+		blend(with.R(), color.R()),
+		blend(with.G(), color.G()),
+		blend(with.B(), color.B()),
+		uint16(blendA)*0x101,
+	)
+}
+
+// BlendMode chooses the space in which blended frames are mixed.
+type BlendMode int
+
+const (
+	// Mix sRGB encoded values directly, as browsers do.
+	BlendGamma BlendMode = iota
+	// Mix in linear light, avoiding dark fringes around anti-aliased edges at
+	// the cost of differing from what browsers show.
+	BlendLinear
+)
+
+func (m BlendMode) String() string {
+	switch m {
+	case BlendGamma:
+		return "gamma"
+	case BlendLinear:
+		return "linear"
+	default:
+		return fmt.Sprintf("BlendMode(%d)", int(m))
+	}
+}
+
+func ParseBlendMode(s string) (BlendMode, error) {
+	switch s {
+	case "gamma":
+		return BlendGamma, nil
+	case "linear":
+		return BlendLinear, nil
+	default:
+		return 0, fmt.Errorf("unknown blend mode %q", s)
+	}
+}
+
+// Like OverlayBlendCanvas but blends in linear light.
+func OverlayBlendCanvasLinear(canvas *canvas.Canvas, with *canvas.Canvas, xOffset, yOffset uint32) {
+	overlayBlendCanvas(canvas, with, xOffset, yOffset, image.Rectangle{}, OverlayColorLinear)
+}
+
+// Composite with over color in linear light.
+func OverlayColorLinear(color canvas.Color, with canvas.Color) canvas.Color {
 	wA := float64(with.A()) / 0xFFFF
-	bR := uint16((float64(with.R()) * wA) + (float64(color.R()) * (1 - wA)))
-	bG := uint16((float64(with.G()) * wA) + (float64(color.G()) * (1 - wA)))
-	bB := uint16((float64(with.B()) * wA) + (float64(color.B()) * (1 - wA)))
+	cA := float64(color.A()) / 0xFFFF
+	outA := wA + cA*(1-wA)
+	if outA == 0 {
+		return color
+	}
+
+	blend := func(c, w uint16) uint16 {
+		linear := (float64(canvas.SrgbToLinear(w))*wA +
+			float64(canvas.SrgbToLinear(c))*cA*(1-wA)) / outA
+		return canvas.LinearToSrgb(uint16(math.Round(linear)))
+	}
 
-	return canvas.MakeColorRgba(bR, bG, bB, 0xFFFF)
+	return canvas.MakeColorRgba(
+		blend(color.R(), with.R()),
+		blend(color.G(), with.G()),
+		blend(color.B(), with.B()),
+		uint16(math.Round(outA*0xFFFF)),
+	)
 }
@@ -0,0 +1,53 @@
+package webpfex
+
+import (
+	"testing"
+	"webpfex/canvas"
+)
+
+func TestOverlayColorLinear(t *testing.T) {
+	black := canvas.MakeColorRgba(0, 0, 0, 0xFFFF)
+	halfWhite := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0x8000)
+
+	// Half of white's light over black is 50% linear, about 73.5% sRGB.
+	blended := OverlayColorLinear(black, halfWhite)
+	if blended.A() != 0xFFFF {
+		t.Errorf("Expecting opaque result, got alpha %X", blended.A())
+	}
+	if r := blended.R(); r < 0xBB00 || r > 0xBD00 {
+		t.Errorf("Expecting about 0xBC44, got %X", r)
+	}
+	if gamma := OverlayColor(black, halfWhite); gamma.R() >= blended.R() {
+		t.Errorf("Expecting linear blend %X to be lighter than gamma blend %X",
+			blended.R(), gamma.R())
+	}
+
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+	if c := OverlayColorLinear(black, red); c != red {
+		t.Errorf("Expecting opaque overlay to replace, got %X", c.Value())
+	}
+	if c := OverlayColorLinear(red, canvas.MakeColorRgba(0, 0, 0, 0)); c != red {
+		t.Errorf("Expecting transparent overlay to keep, got %X", c.Value())
+	}
+}
+
+func TestOverlayColor(t *testing.T) {
+	black := canvas.MakeColorRgba(0, 0, 0, 0xFFFF)
+	halfWhite := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0x8080)
+	transparent := canvas.MakeColorRgba(0, 0, 0, 0)
+
+	// libwebp rounds 255*128/256 down.
+	if c := OverlayColor(black, halfWhite); c != canvas.MakeColorRgba(0x7F7F, 0x7F7F, 0x7F7F, 0xFFFF) {
+		t.Errorf("Expecting libwebp's rounding, got %X", c.Value())
+	}
+	if c := OverlayColor(transparent, halfWhite); c != halfWhite {
+		t.Errorf("Expecting overlay over transparency to be kept, got %X", c.Value())
+	}
+	if c := OverlayColor(black, transparent); c != black {
+		t.Errorf("Expecting transparent overlay to keep, got %X", c.Value())
+	}
+	white := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF)
+	if c := OverlayColor(halfWhite, white); c != white {
+		t.Errorf("Expecting opaque overlay to replace, got %X", c.Value())
+	}
+}
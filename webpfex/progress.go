@@ -0,0 +1,104 @@
+package webpfex
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"webpfex/encode"
+)
+
+// Stage of an extraction or conversion.
+type Stage int
+
+const (
+	StageComposite Stage = iota // Frames composited onto the canvas.
+	StageEncode                 // Composited frames written as images.
+	StageFfmpeg                 // Frames encoded by ffmpeg.
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageComposite:
+		return "composite"
+	case StageEncode:
+		return "encode"
+	case StageFfmpeg:
+		return "ffmpeg"
+	default:
+		return "Stage(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Progress of a single Stage.
+type Progress struct {
+	Stage      Stage
+	Frame      uint32 // Frames done so far within Stage.
+	FrameCount uint32
+	OutTime    time.Duration // Output timestamp reached, for StageFfmpeg only.
+}
+
+// ProgressFunc receives progress updates. It is called synchronously from the
+// working goroutine and should return quickly.
+type ProgressFunc func(Progress)
+
+func (opts Options) report(p Progress) {
+	if opts.Progress != nil {
+		opts.Progress(p)
+	}
+}
+
+// What Coalesce and Optimize saved writing an output.
+type Savings struct {
+	Merged int                   // Frames merged into the previous one.
+	Area   encode.DeltaAreaStats // Pixels stored, zero without Optimize.
+}
+
+// SavingsFunc receives the Savings of an output once its frames are written,
+// when Coalesce or Optimize is on.
+type SavingsFunc func(Savings)
+
+func (opts Options) reportSavings(s Savings) {
+	if opts.Savings != nil {
+		opts.Savings(s)
+	}
+}
+
+// Accumulates the key=value lines written by ffmpeg's -progress option.
+type ffmpegProgressParser struct {
+	frameCount uint32
+	current    Progress
+}
+
+func makeFfmpegProgressParser(frameCount uint32) ffmpegProgressParser {
+	return ffmpegProgressParser{
+		frameCount: frameCount,
+		current:    Progress{Stage: StageFfmpeg, FrameCount: frameCount},
+	}
+}
+
+// Feed a line of ffmpeg's progress output. Returns the progress and true once
+// a block of lines is complete.
+func (p *ffmpegProgressParser) Feed(line string) (Progress, bool) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return Progress{}, false
+	}
+
+	switch key {
+	case "frame":
+		if frame, err := strconv.ParseUint(value, 10, 32); err == nil {
+			p.current.Frame = uint32(frame)
+		}
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.current.OutTime = time.Duration(us) * time.Microsecond
+		}
+	case "progress":
+		if value == "end" {
+			p.current.Frame = p.frameCount
+		}
+		return p.current, true
+	}
+
+	return Progress{}, false
+}
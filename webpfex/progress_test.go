@@ -0,0 +1,43 @@
+package webpfex
+
+import (
+	"testing"
+	"time"
+)
+
+const FFMPEG_PROGRESS_DUMMY = `frame=3
+fps=0.00
+out_time_us=120000
+out_time=00:00:00.120000
+progress=continue
+frame=8
+out_time_us=320000
+progress=end
+`
+
+func TestFfmpegProgressParser(t *testing.T) {
+	parser := makeFfmpegProgressParser(8)
+	var reports []Progress
+	w := lineWriter{onLine: func(line string) {
+		if p, ok := parser.Feed(line); ok {
+			reports = append(reports, p)
+		}
+	}}
+
+	// Split mid-line like a pipe would.
+	w.Write([]byte(FFMPEG_PROGRESS_DUMMY[:20]))
+	w.Write([]byte(FFMPEG_PROGRESS_DUMMY[20:]))
+
+	expected := []Progress{
+		{Stage: StageFfmpeg, Frame: 3, FrameCount: 8, OutTime: 120 * time.Millisecond},
+		{Stage: StageFfmpeg, Frame: 8, FrameCount: 8, OutTime: 320 * time.Millisecond},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("Expecting %d reports got %d", len(expected), len(reports))
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Errorf("Expecting %v got %v", expected[i], reports[i])
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package webpfex
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DroppedChunk is a chunk RecoverAWebp left out.
+type DroppedChunk struct {
+	FourCC string // Empty if cut off before its header ends.
+	Offset int64  // Of the chunk within the file.
+	Frame  uint32 // Number of the frame it held from 1, 0 if none.
+	Reason string
+}
+
+// Recovery is what RecoverAWebp salvaged from an animated WEBP.
+type Recovery struct {
+	Chunks     []Chunk // Kept, to be written with EncodeWebpChunks.
+	FrameCount uint32  // Frames kept.
+	Dropped    []DroppedChunk
+	Fixes      []string // What was fixed in what was kept.
+}
+
+// Whether anything was dropped or fixed.
+func (r Recovery) Damaged() bool {
+	return len(r.Dropped) > 0 || len(r.Fixes) > 0
+}
+
+// Recover every complete frame of the animated WEBP file data, truncated or
+// slightly corrupt. Chunks cut off, and frames whose header or bitstream
+// can't be read, are dropped, as are bytes past the RIFF chunk. The VP8X flags
+// are set after what was kept. Fails if no frame is left.
+func RecoverAWebp(data []byte) (Recovery, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return Recovery{}, makeParsingError("Not a RIFF WEBP file",
+			fmt.Sprintf("%d bytes", len(data)))
+	}
+
+	var recovery Recovery
+	declared := int64(binary.LittleEndian.Uint32(data[4:8]))
+	end := 8 + declared
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	} else if end < int64(len(data)) {
+		recovery.Fixes = append(recovery.Fixes,
+			fmt.Sprintf("dropped %d bytes past the RIFF chunk", int64(len(data))-end))
+	}
+
+	chunks, err := parseChunks(data, 12, end)
+	frames := uint32(0)
+	for _, c := range chunks {
+		if c.FourCC != "ANMF" {
+			recovery.Chunks = append(recovery.Chunks, c)
+			continue
+		}
+
+		frames++
+		if reason := checkAnmfChunk(c); reason != "" {
+			recovery.Dropped = append(recovery.Dropped, DroppedChunk{c.FourCC, c.Offset, frames, reason})
+			continue
+		}
+		recovery.Chunks = append(recovery.Chunks, c)
+		recovery.FrameCount++
+	}
+	if err != nil {
+		recovery.Dropped = append(recovery.Dropped, cutOffChunk(data, chunks, end, frames))
+	}
+
+	vp8x := findChunkIndex(recovery.Chunks, "VP8X")
+	if vp8x >= 0 {
+		if _, err := ParseVp8xHeader(recovery.Chunks[vp8x].Payload); err != nil {
+			return Recovery{}, err
+		}
+	}
+	if vp8x < 0 || findChunkIndex(recovery.Chunks, "ANIM") < 0 {
+		e := makeParsingError("Not an animated WEBP", "no VP8X or ANIM chunk to recover")
+		e.subError = ErrNotAnimated
+		return Recovery{}, e
+	}
+	if recovery.FrameCount == 0 {
+		return Recovery{}, makeParsingError("No frame to recover",
+			fmt.Sprintf("%d frames dropped", len(recovery.Dropped)))
+	}
+
+	flags := recovery.Chunks[vp8x].Payload[0]
+	recovery.Chunks = syncVp8xFlags(recovery.Chunks)
+	recovery.Chunks[vp8x].Payload[0] |= Vp8xFlagAnimation
+	if fixed := recovery.Chunks[vp8x].Payload[0]; fixed != flags {
+		recovery.Fixes = append(recovery.Fixes,
+			fmt.Sprintf("set the VP8X flags to %#02x instead of %#02x", fixed, flags))
+	}
+	size := int64(len(EncodeWebpChunks(recovery.Chunks))) - 8
+	if size != declared {
+		recovery.Fixes = append(recovery.Fixes,
+			fmt.Sprintf("set the RIFF size to %d bytes instead of %d", size, declared))
+	}
+
+	return recovery, nil
+}
+
+// Why the frame in the ANMF chunk c can't be recovered, or "" if it can.
+func checkAnmfChunk(c Chunk) string {
+	header, err := ParseAnmfHeader(c.Payload)
+	if err != nil {
+		return "truncated header"
+	}
+	frameChunks, err := parseChunks(c.Payload, anmfHeaderSize, int64(len(c.Payload)))
+	if err != nil {
+		return fmt.Sprintf("unreadable frame chunks: %v", err)
+	}
+	width, height, _, ok := bitstreamInfo(frameChunks)
+	switch {
+	case !ok:
+		return "no readable VP8 or VP8L bitstream"
+	case width != header.Width || height != header.Height:
+		return fmt.Sprintf("the bitstream is %dx%d, the ANMF header says %dx%d",
+			width, height, header.Width, header.Height)
+	}
+
+	return ""
+}
+
+// Describe the chunk cut off by end, following chunks in data. frames is the
+// number of frames before it.
+func cutOffChunk(data []byte, chunks []Chunk, end int64, frames uint32) DroppedChunk {
+	offset := int64(12)
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		offset = last.Offset + 8 + int64(last.Size) + int64(last.Size%2)
+	}
+	if end-offset < 8 {
+		return DroppedChunk{"", offset, 0,
+			fmt.Sprintf("cut off within the chunk header, %d of 8 bytes", end-offset)}
+	}
+
+	fourCC := string(data[offset : offset+4])
+	size := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+	frame := uint32(0)
+	if fourCC == "ANMF" {
+		frame = frames + 1
+	}
+	return DroppedChunk{fourCC, offset, frame,
+		fmt.Sprintf("cut off, %d of %d bytes", end-offset-8, size)}
+}
+
+// Recover the animated WEBP in, like RecoverAWebp, and write what was
+// recovered to out, which may be in.
+func RepairAWebp(in, out string, opts Options) (Recovery, error) {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return Recovery{}, err
+	}
+	recovery, err := RecoverAWebp(data)
+	if err != nil {
+		return Recovery{}, err
+	}
+
+	return recovery, WriteWebpChunks(out, recovery.Chunks, opts)
+}
+
+// Recover the animated WEBP in, like RecoverAWebp, and extract the frames
+// recovered as PNGs into outdir, like ExtractWebpFramesAsPngContext.
+func ExtractRepairedAWebpContext(
+	ctx context.Context,
+	in string,
+	outdir string,
+	opts Options,
+) (Recovery, error) {
+	dir, err := os.MkdirTemp("", "webpfex")
+	if err != nil {
+		return Recovery{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	repaired := filepath.Join(dir, "repaired.webp")
+	recovery, err := RepairAWebp(in, repaired, opts)
+	if err != nil {
+		return Recovery{}, err
+	}
+
+	return recovery, ExtractWebpFramesAsPngContext(ctx, repaired, outdir, opts)
+}
@@ -0,0 +1,108 @@
+package webpfex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/encode"
+)
+
+func TestRecoverAWebp(t *testing.T) {
+	ms := time.Millisecond
+	data := encodeTestAWebp(t, encode.AnimationOptions{EXIF: []byte("exif")}, 40*ms, 60*ms, 80*ms)
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// VP8X, ANIM, 3 ANMF then EXIF.
+	third, exif := chunks[4], chunks[5]
+
+	recovery, err := RecoverAWebp(data)
+	if err != nil || recovery.Damaged() || recovery.FrameCount != 3 {
+		t.Errorf("Expecting 3 frames and nothing to repair, got %+v: %v", recovery, err)
+	}
+	if !bytes.Equal(EncodeWebpChunks(recovery.Chunks), data) {
+		t.Error("Expecting an intact file unchanged")
+	}
+
+	// Cut off within the last frame.
+	truncated := data[:third.Offset+20]
+	recovery, err = RecoverAWebp(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DroppedChunk{{"ANMF", third.Offset, 3, "cut off, 12 of 40 bytes"}}
+	if recovery.FrameCount != 2 || !reflect.DeepEqual(recovery.Dropped, expected) {
+		t.Errorf("Expecting 2 frames and %v dropped, got %d and %v",
+			expected, recovery.FrameCount, recovery.Dropped)
+	}
+	repaired := EncodeWebpChunks(recovery.Chunks)
+	if _, err := ParseWebpChunks(repaired); err != nil {
+		t.Errorf("Expecting a readable repaired file: %v", err)
+	}
+	if len(recovery.Fixes) != 2 || repaired[20] != Vp8xFlagAnimation {
+		t.Errorf("Expecting the EXIF flag and RIFF size fixed, got %v", recovery.Fixes)
+	}
+
+	// Cut off within a chunk header, followed by garbage past the RIFF chunk.
+	truncated = append([]byte(nil), data[:exif.Offset+4]...)
+	binary.LittleEndian.PutUint32(truncated[4:], uint32(len(truncated)-8))
+	truncated = append(truncated, "garbage"...)
+	recovery, err = RecoverAWebp(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []DroppedChunk{{"", exif.Offset, 0, "cut off within the chunk header, 4 of 8 bytes"}}
+	if recovery.FrameCount != 3 || !reflect.DeepEqual(recovery.Dropped, expected) {
+		t.Errorf("Expecting 3 frames and %v dropped, got %d and %v",
+			expected, recovery.FrameCount, recovery.Dropped)
+	}
+
+	// A frame whose bitstream is corrupt.
+	corrupt := append([]byte(nil), data...)
+	corrupt[chunks[2].Offset+8+anmfHeaderSize+8] = 0
+	recovery, err = RecoverAWebp(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovery.FrameCount != 2 || len(recovery.Dropped) != 1 || recovery.Dropped[0].Frame != 1 {
+		t.Errorf("Expecting the first frame dropped, got %v", recovery.Dropped)
+	}
+
+	if _, err := RecoverAWebp(data[:chunks[2].Offset+10]); err == nil {
+		t.Error("Expecting an error without a complete frame")
+	}
+	if _, err := RecoverAWebp(data[:chunks[1].Offset]); !errors.Is(err, ErrNotAnimated) {
+		t.Errorf("Expecting ErrNotAnimated without ANIM, got %v", err)
+	}
+	if _, err := RecoverAWebp([]byte("GIF89a")); err == nil {
+		t.Error("Expecting an error for a GIF")
+	}
+}
+
+func TestRepairAWebp(t *testing.T) {
+	data := encodeTestAWebp(t, encode.AnimationOptions{}, 40*time.Millisecond, 60*time.Millisecond)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.webp")
+	os.WriteFile(in, data[:len(data)-4], 0644)
+
+	opts := DefaultOptions()
+	opts.Overwrite = false
+	if _, err := RepairAWebp(in, in, opts); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expecting an error overwriting the input, got %v", err)
+	}
+	opts.Overwrite = true
+	recovery, err := RepairAWebp(in, in, opts)
+	if err != nil || recovery.FrameCount != 1 {
+		t.Fatalf("Expecting a frame recovered, got %+v: %v", recovery, err)
+	}
+	chunks, err := ReadWebpChunks(in)
+	if err != nil || len(chunks) != 3 {
+		t.Errorf("Expecting the input repaired in place, got %d chunks: %v", len(chunks), err)
+	}
+}
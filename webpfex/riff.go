@@ -0,0 +1,85 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Chunk is a RIFF chunk directly within a WEBP file.
+type Chunk struct {
+	FourCC  string
+	Offset  int64  // Of the chunk header within the file.
+	Size    uint32 // Of the payload, excluding the padding byte.
+	Payload []byte
+}
+
+// Read the chunks of the WEBP file at path.
+func ReadWebpChunks(path string) ([]Chunk, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseWebpChunks(data)
+}
+
+// Parse the chunks of the WEBP file data.
+func ParseWebpChunks(data []byte) ([]Chunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		header := data
+		if len(header) > 12 {
+			header = header[:12]
+		}
+		return nil, makeParsingError("Not a RIFF WEBP file", string(header))
+	}
+
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(data[4:8]))
+	if riffEnd > int64(len(data)) {
+		return nil, makeParsingError("Truncated RIFF",
+			fmt.Sprintf("declares %d bytes, file has %d", riffEnd, len(data)))
+	}
+
+	return parseChunks(data, 12, riffEnd)
+}
+
+// Parse the chunks in data[start:end], offsets being relative to data.
+func parseChunks(data []byte, start, end int64) ([]Chunk, error) {
+	var chunks []Chunk
+	for offset := start; offset < end; {
+		if end-offset < 8 {
+			return chunks, makeParsingError("Truncated chunk header",
+				fmt.Sprintf("at offset %d", offset))
+		}
+
+		fourCC := string(data[offset : offset+4])
+		size := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		payloadEnd := offset + 8 + int64(size)
+		if payloadEnd > end {
+			return chunks, makeParsingError("Truncated chunk",
+				fmt.Sprintf("%s at offset %d declares %d bytes", fourCC, offset, size))
+		}
+
+		chunks = append(chunks, Chunk{
+			FourCC:  fourCC,
+			Offset:  offset,
+			Size:    size,
+			Payload: data[offset+8 : payloadEnd],
+		})
+
+		offset = payloadEnd + int64(size%2)
+	}
+
+	return chunks, nil
+}
+
+// Find the first chunk with fourCC.
+func findChunk(chunks []Chunk, fourCC string) (Chunk, bool) {
+	for _, c := range chunks {
+		if c.FourCC == fourCC {
+			return c, true
+		}
+	}
+
+	return Chunk{}, false
+}
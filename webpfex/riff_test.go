@@ -0,0 +1,77 @@
+package webpfex
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Assemble a WEBP file from fourCC and payload pairs.
+func makeWebpData(chunks ...string) []byte {
+	var body []byte
+	for i := 0; i+1 < len(chunks); i += 2 {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(chunks[i+1])))
+		body = append(body, chunks[i]...)
+		body = append(body, size[:]...)
+		body = append(body, chunks[i+1]...)
+		if len(chunks[i+1])%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(4+len(body)))
+	data := append([]byte("RIFF"), size[:]...)
+	data = append(data, "WEBP"...)
+	return append(data, body...)
+}
+
+func TestParseWebpChunks(t *testing.T) {
+	data := makeWebpData("VP8X", "0123456789", "EXIF", "odd", "XMP ", "<x/>")
+
+	chunks, err := ParseWebpChunks(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		fourCC  string
+		offset  int64
+		payload string
+	}{
+		{"VP8X", 12, "0123456789"},
+		{"EXIF", 30, "odd"},
+		{"XMP ", 42, "<x/>"},
+	}
+	if len(chunks) != len(expected) {
+		t.Fatalf("Expecting %d chunks got %d", len(expected), len(chunks))
+	}
+	for i, e := range expected {
+		c := chunks[i]
+		if c.FourCC != e.fourCC || c.Offset != e.offset || string(c.Payload) != e.payload {
+			t.Errorf("Expecting %s at %d with %q, got %s at %d with %q",
+				e.fourCC, e.offset, e.payload, c.FourCC, c.Offset, c.Payload)
+		}
+	}
+}
+
+func TestParseWebpChunksTruncated(t *testing.T) {
+	data := makeWebpData("VP8X", "0123456789", "EXIF", "exif")
+	// Claim a longer EXIF chunk while keeping the RIFF size consistent.
+	binary.LittleEndian.PutUint32(data[34:38], 100)
+
+	chunks, err := ParseWebpChunks(data)
+	if err == nil {
+		t.Errorf("Expecting error for truncated chunk")
+	}
+	if len(chunks) != 1 || chunks[0].FourCC != "VP8X" {
+		t.Errorf("Expecting chunks before the damage, got %v", chunks)
+	}
+
+	if _, err := ParseWebpChunks(data[:len(data)-2]); err == nil {
+		t.Errorf("Expecting error for truncated RIFF")
+	}
+	if _, err := ParseWebpChunks([]byte("GIF89a")); err == nil {
+		t.Errorf("Expecting error for non-WEBP data")
+	}
+}
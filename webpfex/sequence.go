@@ -0,0 +1,181 @@
+package webpfex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+// A frame of a PNG sequence, shown for Duration.
+type sequenceFrame struct {
+	Name     string // Relative to the sequence's directory.
+	Duration time.Duration
+}
+
+// pngSequenceWriter writes whole frames as numbered PNGs in dir, remembering
+// their durations for ffmpeg.
+type pngSequenceWriter struct {
+	dir      string
+	metadata Metadata
+	opts     Options
+	frames   []sequenceFrame
+}
+
+func (w *pngSequenceWriter) WriteFrame(frame encode.Frame) error {
+	name := fmt.Sprintf("%09d.png", len(w.frames)+1)
+	p := path.Join(w.dir, name)
+	if err := checkOverwrite(p, w.opts); err != nil {
+		return err
+	}
+	// Written from here on, as far as cleaning up goes.
+	w.frames = append(w.frames, sequenceFrame{name, frame.Duration})
+
+	return SavePngWithMetadata(frame.Canvas, p, w.metadata)
+}
+
+func (w *pngSequenceWriter) Close() error {
+	return nil
+}
+
+// Paths of the written frames.
+func (w *pngSequenceWriter) Paths() []string {
+	paths := make([]string, len(w.frames))
+	for i, f := range w.frames {
+		paths[i] = path.Join(w.dir, f.Name)
+	}
+	return paths
+}
+
+// Write the sequence as a list for ffmpeg's concat demuxer in the sequence's
+// directory, returning its path.
+func (w *pngSequenceWriter) WriteConcatList() (string, error) {
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	for _, f := range w.frames {
+		fmt.Fprintf(&list, "file %s\nduration %s\n",
+			f.Name, strconv.FormatFloat(f.Duration.Seconds(), 'f', -1, 64))
+	}
+	// The last duration only counts when a file follows.
+	if len(w.frames) > 0 {
+		fmt.Fprintf(&list, "file %s\n", w.frames[len(w.frames)-1].Name)
+	}
+
+	p := path.Join(w.dir, "frames.ffconcat")
+	return p, os.WriteFile(p, []byte(list.String()), 0644)
+}
+
+// Wrap w with the frame rate and coalescing stages opts asks for. What they
+// save, and what w saves when it's a DeltaWriter, is reported once closed.
+func retimingWriter(w encode.FrameWriter, opts Options) encode.FrameWriter {
+	delta, _ := w.(*encode.DeltaWriter)
+	var coalesce *encode.CoalescingWriter
+	if opts.Coalesce {
+		coalesce = encode.MakeCoalescingWriter(w, opts.CoalesceThreshold)
+		w = coalesce
+	}
+	if !opts.FrameRate.IsZero() {
+		w = encode.MakeResamplingWriter(w, opts.FrameRate, opts.CrossFade)
+	}
+	if coalesce == nil && delta == nil {
+		return w
+	}
+
+	return &savingsWriter{FrameWriter: w, coalesce: coalesce, delta: delta, opts: opts}
+}
+
+// Reports the Savings of its coalescing and delta stages once closed.
+type savingsWriter struct {
+	encode.FrameWriter
+	coalesce *encode.CoalescingWriter
+	delta    *encode.DeltaWriter
+	opts     Options
+}
+
+func (w *savingsWriter) Close() error {
+	if err := w.FrameWriter.Close(); err != nil {
+		return err
+	}
+
+	var savings Savings
+	if w.coalesce != nil {
+		savings.Merged = w.coalesce.Merged()
+	}
+	if w.delta != nil {
+		savings.Area = w.delta.AreaStats()
+	}
+	w.opts.reportSavings(savings)
+	return nil
+}
+
+// Whether opts changes the timing or order of frames with TimingPolicy,
+// Durations, Speed or Reverse.
+func (opts Options) editsTiming() bool {
+	return opts.TimingPolicy != TimingExact || opts.Durations != nil ||
+		opts.Speed != 0 && opts.Speed != 1 || opts.Reverse
+}
+
+// Wrap w with the timing changes opts asks for.
+func timingWriter(w encode.FrameWriter, opts Options) encode.FrameWriter {
+	if !opts.editsTiming() {
+		return w
+	}
+
+	policy := opts.TimingPolicy
+	timing := opts.Durations
+	return encode.MakeTimingWriter(w, encode.TimingOptions{
+		Durations: func(i int, duration time.Duration) time.Duration {
+			if timing != nil {
+				if d, ok := timing.Lookup(i, strconv.Itoa(i+1)); ok {
+					return d
+				}
+			}
+			return policy.Apply(duration)
+		},
+		Speed:   opts.Speed,
+		Reverse: opts.Reverse,
+	})
+}
+
+// Wrap w with the looping stage opts asks for, the loop count of info being
+// the default.
+func loopingWriter(w encode.FrameWriter, info AWebpInfo, opts Options) encode.FrameWriter {
+	loops := opts.Loops
+	if loops == 0 {
+		loops = int(info.LoopCount)
+	}
+	if loops <= 1 && opts.MinDuration == 0 && !opts.Boomerang {
+		return w
+	}
+
+	return encode.MakeLoopingWriter(w, encode.LoopOptions{
+		Loops:       loops,
+		MinDuration: opts.MinDuration,
+		Boomerang:   opts.Boomerang,
+	})
+}
+
+// Write the composited frames of the animated WEBP at path, described by info,
+// to w and close it.
+func compositeInto(
+	ctx context.Context,
+	path string,
+	info AWebpInfo,
+	opts Options,
+	w encode.FrameWriter,
+) error {
+	err := CompositeAWebp(ctx, path, info, opts,
+		func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error {
+			return w.WriteFrame(encode.Frame{Canvas: *cv, Duration: frameInfo.Duration})
+		})
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
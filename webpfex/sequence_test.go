@@ -0,0 +1,136 @@
+package webpfex
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+	"webpfex/canvas"
+	"webpfex/encode"
+)
+
+func TestPngSequenceWriter(t *testing.T) {
+	w := &pngSequenceWriter{dir: t.TempDir()}
+	for _, d := range []time.Duration{100 * time.Millisecond, 1500 * time.Millisecond} {
+		if err := w.WriteFrame(encode.Frame{Canvas: canvas.MakeCanvas(2, 2), Duration: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := w.WriteConcatList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `ffconcat version 1.0
+file 000000001.png
+duration 0.1
+file 000000002.png
+duration 1.5
+file 000000002.png
+`
+	if string(data) != expected {
+		t.Errorf("Expecting %q, got %q", expected, data)
+	}
+	if _, err := LoadWebp(path.Join(w.dir, "000000002.png")); err != nil {
+		t.Errorf("Expecting frames written as PNG: %v", err)
+	}
+}
+
+func TestPngSequenceWriterOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "000000002.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &pngSequenceWriter{dir: dir}
+	frame := encode.Frame{Canvas: canvas.MakeCanvas(2, 2), Duration: time.Second}
+	if err := w.WriteFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrame(frame); err == nil {
+		t.Error("Expecting an error overwriting a frame")
+	}
+	if paths := w.Paths(); len(paths) != 1 || paths[0] != path.Join(dir, "000000001.png") {
+		t.Errorf("Expecting only the first frame written, got %v", paths)
+	}
+}
+
+func TestLoopingWriterDefaults(t *testing.T) {
+	var info AWebpInfo
+	var w encode.FrameWriter = &pngSequenceWriter{}
+	if loopingWriter(w, info, Options{}) != w {
+		t.Error("Expecting an infinite animation played once")
+	}
+
+	info.LoopCount = 3
+	looping, ok := loopingWriter(w, info, Options{}).(*encode.LoopingWriter)
+	if !ok || looping.Passes() != 3 {
+		t.Error("Expecting a finite animation played its loop count")
+	}
+	looping, ok = loopingWriter(w, info, Options{Loops: 2}).(*encode.LoopingWriter)
+	if !ok || looping.Passes() != 2 {
+		t.Error("Expecting Loops to override the loop count")
+	}
+}
+
+func TestRetimingWriterSavings(t *testing.T) {
+	var savings []Savings
+	opts := Options{Coalesce: true, Savings: func(s Savings) { savings = append(savings, s) }}
+	w := retimingWriter(&pngSequenceWriter{dir: t.TempDir()}, opts)
+	frame := encode.Frame{Canvas: canvas.MakeCanvas(2, 2), Duration: time.Second}
+	for i := 0; i < 3; i++ {
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(savings) != 0 {
+		t.Error("Expecting savings reported once closed")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(savings) != 1 || savings[0].Merged != 2 || savings[0].Area.Frames != 0 {
+		t.Errorf("Expecting 2 frames merged without delta frames, got %+v", savings)
+	}
+
+	var plain encode.FrameWriter = &pngSequenceWriter{}
+	if retimingWriter(plain, Options{}) != plain {
+		t.Error("Expecting nothing reported without Coalesce or Optimize")
+	}
+}
+
+func TestTimingWriterDurations(t *testing.T) {
+	var w encode.FrameWriter = &pngSequenceWriter{}
+	if timingWriter(w, Options{Speed: 1}) != w {
+		t.Error("Expecting frames kept as they are at a speed of 1")
+	}
+
+	// By frame number, then in order.
+	timing := Timing{
+		Durations: []time.Duration{10 * time.Millisecond},
+		Named:     map[string]time.Duration{"2": time.Second},
+	}
+	sequence := &pngSequenceWriter{dir: t.TempDir()}
+	w = timingWriter(sequence, Options{Durations: &timing})
+	for i := 0; i < 3; i++ {
+		if err := w.WriteFrame(encode.Frame{Canvas: canvas.MakeCanvas(1, 1), Duration: 40 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	var durations []time.Duration
+	for _, f := range sequence.frames {
+		durations = append(durations, f.Duration)
+	}
+	expected := []time.Duration{10 * time.Millisecond, time.Second, 40 * time.Millisecond}
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting durations %v, got %v", expected, durations)
+	}
+}
@@ -0,0 +1,65 @@
+package webpfex
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"webpfex/encode"
+)
+
+// TimingPolicy chooses how the durations stored for frames are read. The zero
+// value keeps them exact.
+type TimingPolicy struct {
+	// Show frames lasting at most encode.BrowserClampMax for
+	// encode.BrowserClampDuration, like browsers do.
+	Browser bool
+	// Show frames lasting less for Min.
+	Min time.Duration
+}
+
+var (
+	TimingExact   = TimingPolicy{}
+	TimingBrowser = TimingPolicy{Browser: true}
+)
+
+func (p TimingPolicy) String() string {
+	switch {
+	case p.Browser:
+		return "browser"
+	case p.Min > 0:
+		return "min=" + p.Min.String()
+	default:
+		return "exact"
+	}
+}
+
+// Parse a policy given as exact, browser or min=D, with D like 20ms or a
+// number of milliseconds.
+func ParseTimingPolicy(s string) (TimingPolicy, error) {
+	switch s {
+	case "exact":
+		return TimingExact, nil
+	case "browser":
+		return TimingBrowser, nil
+	}
+	if !strings.HasPrefix(s, "min=") {
+		return TimingPolicy{}, fmt.Errorf("unknown timing policy %q", s)
+	}
+
+	min, err := ParseFrameDuration(strings.TrimPrefix(s, "min="))
+	if err != nil {
+		return TimingPolicy{}, err
+	}
+	return TimingPolicy{Min: min}, nil
+}
+
+// How long a frame stored as lasting duration is shown.
+func (p TimingPolicy) Apply(duration time.Duration) time.Duration {
+	if p.Browser && duration <= encode.BrowserClampMax {
+		return encode.BrowserClampDuration
+	}
+	if duration < p.Min {
+		return p.Min
+	}
+	return duration
+}
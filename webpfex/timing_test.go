@@ -0,0 +1,48 @@
+package webpfex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimingPolicy(t *testing.T) {
+	for s, expected := range map[string]TimingPolicy{
+		"exact":    TimingExact,
+		"browser":  TimingBrowser,
+		"min=20ms": {Min: 20 * time.Millisecond},
+		"min=50":   {Min: 50 * time.Millisecond},
+		"min=0.1s": {Min: 100 * time.Millisecond},
+	} {
+		if policy, err := ParseTimingPolicy(s); err != nil || policy != expected {
+			t.Errorf("%s: expecting %v, got %v, %v", s, expected, policy, err)
+		}
+	}
+
+	for _, s := range []string{"fast", "min=-20ms", "browser=10", ""} {
+		if policy, err := ParseTimingPolicy(s); err == nil {
+			t.Errorf("%s: expecting an error, got %v", s, policy)
+		}
+	}
+}
+
+func TestTimingPolicyApply(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		policy   TimingPolicy
+		duration time.Duration
+		expected time.Duration
+	}{
+		{TimingExact, 0, 0},
+		{TimingExact, 5 * ms, 5 * ms},
+		{TimingBrowser, 0, 100 * ms},
+		{TimingBrowser, 10 * ms, 100 * ms},
+		{TimingBrowser, 11 * ms, 11 * ms},
+		{TimingPolicy{Min: 20 * ms}, 0, 20 * ms},
+		{TimingPolicy{Min: 20 * ms}, 30 * ms, 30 * ms},
+	}
+	for _, c := range cases {
+		if got := c.policy.Apply(c.duration); got != c.expected {
+			t.Errorf("%v of %v: expecting %v, got %v", c.policy, c.duration, c.expected, got)
+		}
+	}
+}
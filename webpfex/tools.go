@@ -0,0 +1,229 @@
+package webpfex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	ToolWebpmux = "webpmux"
+	ToolFfmpeg  = "ffmpeg"
+)
+
+// Encoders ffmpeg must provide: libx264 for MP4 outputs and libwebp for lossy
+// WEBP outputs.
+var RequiredFfmpegEncoders = []string{"libx264", "libwebp"}
+
+// Tools locates the external commands webpfex relies on. An empty field falls
+// back to the WEBPFEX_<NAME> environment variable, e.g. WEBPFEX_FFMPEG, then to
+// looking the command up in PATH.
+type Tools struct {
+	Webpmux string
+	Ffmpeg  string
+}
+
+// Resolve the executable of the tool called name.
+func (t Tools) Lookup(name string) (string, error) {
+	configured, source := t.configured(name)
+	path, err := exec.LookPath(configured)
+	if err != nil {
+		return "", makeToolError(name, configured, source, err)
+	}
+
+	return path, nil
+}
+
+// The command configured for the tool called name and where it was configured:
+// "option", the environment variable or "PATH".
+func (t Tools) configured(name string) (string, string) {
+	var option string
+	switch name {
+	case ToolWebpmux:
+		option = t.Webpmux
+	case ToolFfmpeg:
+		option = t.Ffmpeg
+	}
+	if option != "" {
+		return option, "option"
+	}
+
+	env := toolEnv(name)
+	if value := os.Getenv(env); value != "" {
+		return value, env
+	}
+
+	return name, "PATH"
+}
+
+func toolEnv(name string) string {
+	return "WEBPFEX_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ToolReport describes how a tool was resolved and what it provides.
+type ToolReport struct {
+	Name     string
+	Path     string
+	Source   string // "option", the environment variable or "PATH".
+	Version  string
+	Encoders map[string]bool // Required ffmpeg encoders and their presence.
+	Err      error
+}
+
+// Whether the tool was found with everything required of it.
+func (r ToolReport) Ok() bool {
+	if r.Err != nil {
+		return false
+	}
+	for _, present := range r.Encoders {
+		if !present {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Resolve and probe every tool webpfex relies on.
+func CheckTools(ctx context.Context, tools Tools) []ToolReport {
+	opts := DefaultOptions()
+	opts.Tools = tools
+
+	var reports []ToolReport
+	for _, name := range []string{ToolWebpmux, ToolFfmpeg} {
+		_, source := tools.configured(name)
+		report := ToolReport{Name: name, Source: source}
+		report.Path, report.Err = tools.Lookup(name)
+		if report.Err != nil {
+			reports = append(reports, report)
+			continue
+		}
+
+		switch name {
+		case ToolWebpmux:
+			var out []byte
+			if out, report.Err = runCommand(ctx, opts, name, "-version"); report.Err == nil {
+				report.Version = firstLine(string(out))
+			}
+		case ToolFfmpeg:
+			var out []byte
+			if out, report.Err = runCommand(ctx, opts, name, "-version"); report.Err != nil {
+				break
+			}
+			report.Version = parseFfmpegVersion(string(out))
+			if out, report.Err = runCommand(ctx, opts, name,
+				"-hide_banner", "-encoders"); report.Err == nil {
+				available := parseFfmpegEncoders(string(out))
+				report.Encoders = make(map[string]bool)
+				for _, encoder := range RequiredFfmpegEncoders {
+					report.Encoders[encoder] = available[encoder]
+				}
+			}
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Parse the version out of the first line of `ffmpeg -version`, e.g.
+// "ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers".
+func parseFfmpegVersion(out string) string {
+	fields := strings.Fields(firstLine(out))
+	if len(fields) >= 3 && fields[1] == "version" {
+		return fields[2]
+	}
+
+	return firstLine(out)
+}
+
+// Whether ffmpeg of version, as parseFfmpegVersion gives it, has the
+// -fps_mode option replacing -vsync, that is 5.1 or later. Versions that don't
+// start with a release number, like those of git builds, are assumed recent.
+func ffmpegHasFpsMode(version string) bool {
+	release := strings.TrimPrefix(version, "n")
+	end := strings.IndexFunc(release, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end >= 0 {
+		release = release[:end]
+	}
+	major, minor, _ := strings.Cut(release, ".")
+	minor, _, _ = strings.Cut(minor, ".")
+	majorNumber, err := strconv.Atoi(major)
+	if err != nil {
+		return true
+	}
+	minorNumber, _ := strconv.Atoi(minor)
+
+	return majorNumber > 5 || majorNumber == 5 && minorNumber >= 1
+}
+
+// The ffmpeg arguments syncing output frames by mode, e.g. "vfr": -fps_mode,
+// or the deprecated -vsync for ffmpeg older than 5.1. Assumes a recent ffmpeg
+// if its version can't be read.
+func ffmpegFpsModeArgs(ctx context.Context, opts Options, mode string) []string {
+	if out, err := runCommand(ctx, opts, ToolFfmpeg, "-version"); err == nil &&
+		!ffmpegHasFpsMode(parseFfmpegVersion(string(out))) {
+		return []string{"-vsync", mode}
+	}
+
+	return []string{"-fps_mode", mode}
+}
+
+// Parse the encoder names listed by `ffmpeg -encoders`, whose lines look like
+// " V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC".
+func parseFfmpegEncoders(out string) map[string]bool {
+	encoders := make(map[string]bool)
+	listing := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if !listing {
+			// The legend above the listing ends with a " ------" line.
+			listing = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+
+		encoders[fields[1]] = true
+	}
+
+	return encoders
+}
+
+// ToolError is returned when a tool can't be resolved to an executable. It
+// wraps exec.ErrNotFound when the tool isn't installed.
+type ToolError struct {
+	Name    string
+	Command string
+	Source  string
+	Err     error
+}
+
+func makeToolError(name string, command string, source string, err error) *ToolError {
+	e := ToolError{name, command, source, err}
+	return &e
+}
+
+func (e ToolError) Unwrap() error {
+	return e.Err
+}
+
+func (e ToolError) Error() string {
+	if e.Source == "PATH" {
+		return fmt.Sprintf("%s not found in PATH; install it or set %s", e.Name, toolEnv(e.Name))
+	}
+
+	return fmt.Sprintf("%s %q from %s is unusable: %v", e.Name, e.Command, e.Source, e.Err)
+}
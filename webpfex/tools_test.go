@@ -0,0 +1,142 @@
+package webpfex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const FFMPEG_ENCODERS_DUMMY = `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)
+ V....D png                  PNG (Portable Network Graphics) image
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+
+// Write an executable shell script called name into dir.
+func writeFakeTool(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestToolsLookup(t *testing.T) {
+	dir := t.TempDir()
+	fake := writeFakeTool(t, dir, "my-ffmpeg", "exit 0\n")
+
+	t.Setenv("PATH", "")
+	t.Setenv("WEBPFEX_FFMPEG", "")
+	_, err := Tools{}.Lookup(ToolFfmpeg)
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("Expecting exec.ErrNotFound, got %v", err)
+	}
+
+	t.Setenv("WEBPFEX_FFMPEG", fake)
+	if path, err := (Tools{}).Lookup(ToolFfmpeg); err != nil || path != fake {
+		t.Errorf("Expecting %s from environment, got %s %v", fake, path, err)
+	}
+
+	if _, err := (Tools{Ffmpeg: filepath.Join(dir, "nope")}).Lookup(ToolFfmpeg); err == nil {
+		t.Errorf("Expecting the option to override the environment")
+	}
+}
+
+func TestParseFfmpegOutput(t *testing.T) {
+	version := parseFfmpegVersion(
+		"ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc")
+	if version != "6.0" {
+		t.Errorf("Expecting version 6.0 got %q", version)
+	}
+
+	for version, expected := range map[string]bool{
+		"6.0": true, "5.1.2": true, "n5.1": true, "5.0.1": false,
+		"4.4.2-0ubuntu0.22.04.1": false, "N-109421-g0bc4a5e0c3": true,
+	} {
+		if ffmpegHasFpsMode(version) != expected {
+			t.Errorf("Expecting -fps_mode %v for %s", expected, version)
+		}
+	}
+
+	encoders := parseFfmpegEncoders(FFMPEG_ENCODERS_DUMMY)
+	for _, name := range []string{"libx264", "png", "aac"} {
+		if !encoders[name] {
+			t.Errorf("Expecting encoder %s in %v", name, encoders)
+		}
+	}
+	if encoders["="] || encoders["libvpx-vp9"] {
+		t.Errorf("Unexpected encoders in %v", encoders)
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	dir := t.TempDir()
+	tools := Tools{
+		Webpmux: writeFakeTool(t, dir, "webpmux", "echo 1.3.2\n"),
+		Ffmpeg: writeFakeTool(t, dir, "ffmpeg", `
+if [ "$1" = "-version" ]; then
+	echo "ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers"
+else
+	cat <<EOF
+`+FFMPEG_ENCODERS_DUMMY+`EOF
+fi
+`),
+	}
+
+	reports := CheckTools(context.Background(), tools)
+	if len(reports) != 2 {
+		t.Fatalf("Expecting 2 reports got %v", reports)
+	}
+
+	webpmux, ffmpeg := reports[0], reports[1]
+	if !webpmux.Ok() || webpmux.Version != "1.3.2" || webpmux.Source != "option" {
+		t.Errorf("Unexpected webpmux report %+v", webpmux)
+	}
+	if ffmpeg.Ok() || ffmpeg.Version != "6.0" {
+		t.Errorf("Expecting ffmpeg 6.0 lacking an encoder, got %+v", ffmpeg)
+	}
+	if !ffmpeg.Encoders["libx264"] || ffmpeg.Encoders["libwebp"] {
+		t.Errorf("Unexpected encoders %v", ffmpeg.Encoders)
+	}
+	// A broken -version isn't hidden by -encoders succeeding.
+	tools.Ffmpeg = writeFakeTool(t, dir, "broken-ffmpeg", `
+if [ "$1" = "-version" ]; then
+	exit 1
+fi
+cat <<EOF
+`+FFMPEG_ENCODERS_DUMMY+`EOF
+`)
+	ffmpeg = CheckTools(context.Background(), tools)[1]
+	if ffmpeg.Ok() || ffmpeg.Err == nil {
+		t.Errorf("Expecting ffmpeg failing -version to be reported, got %+v", ffmpeg)
+	}
+}
+
+func TestFfmpegFpsModeArgs(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.Tools.Ffmpeg = writeFakeTool(t, dir, "ffmpeg-4", "echo 'ffmpeg version 4.4.2'\n")
+	args := ffmpegFpsModeArgs(context.Background(), opts, "vfr")
+	if !reflect.DeepEqual(args, []string{"-vsync", "vfr"}) {
+		t.Errorf("Expecting -vsync for ffmpeg 4.4, got %v", args)
+	}
+
+	opts.Tools.Ffmpeg = writeFakeTool(t, dir, "ffmpeg-6", "echo 'ffmpeg version 6.0'\n")
+	args = ffmpegFpsModeArgs(context.Background(), opts, "vfr")
+	if !reflect.DeepEqual(args, []string{"-fps_mode", "vfr"}) {
+		t.Errorf("Expecting -fps_mode for ffmpeg 6.0, got %v", args)
+	}
+}
@@ -0,0 +1,336 @@
+package webpfex
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"regexp"
+	"strconv"
+	"webpfex/canvas"
+
+	"golang.org/x/image/draw"
+)
+
+// Transform changes the geometry of composited frames.
+type Transform interface {
+	// Size of frames of width x height once transformed, or an error if they
+	// can't be.
+	Size(width, height uint32) (uint32, uint32, error)
+	// Transform cv, whose size Size accepts.
+	Apply(cv canvas.Canvas) canvas.Canvas
+}
+
+// Size of frames of width x height once transformed by every transform in
+// order.
+func TransformedSize(transforms []Transform, width, height uint32) (uint32, uint32, error) {
+	for _, t := range transforms {
+		var err error
+		if width, height, err = t.Size(width, height); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return width, height, nil
+}
+
+func applyTransforms(transforms []Transform, cv canvas.Canvas) canvas.Canvas {
+	for _, t := range transforms {
+		cv = t.Apply(cv)
+	}
+
+	return cv
+}
+
+// ResizeKernel is the filter resizing interpolates with.
+type ResizeKernel int
+
+const (
+	// Sharpest, with a support of 3 pixels.
+	ResizeLanczos ResizeKernel = iota
+	ResizeCatmullRom
+	ResizeBilinear
+	// Nearest neighbor, keeping pixel art crisp.
+	ResizeNearest
+)
+
+func (k ResizeKernel) String() string {
+	switch k {
+	case ResizeLanczos:
+		return "lanczos"
+	case ResizeCatmullRom:
+		return "catmullrom"
+	case ResizeBilinear:
+		return "bilinear"
+	case ResizeNearest:
+		return "nearest"
+	default:
+		return fmt.Sprintf("ResizeKernel(%d)", int(k))
+	}
+}
+
+func ParseResizeKernel(s string) (ResizeKernel, error) {
+	switch s {
+	case "lanczos":
+		return ResizeLanczos, nil
+	case "catmullrom":
+		return ResizeCatmullRom, nil
+	case "bilinear":
+		return ResizeBilinear, nil
+	case "nearest":
+		return ResizeNearest, nil
+	default:
+		return 0, fmt.Errorf("unknown resize filter %q", s)
+	}
+}
+
+// Lanczos windowed sinc with a = 3.
+var lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+func (k ResizeKernel) interpolator() draw.Interpolator {
+	switch k {
+	case ResizeCatmullRom:
+		return draw.CatmullRom
+	case ResizeBilinear:
+		return draw.BiLinear
+	case ResizeNearest:
+		return draw.NearestNeighbor
+	default:
+		return lanczos3
+	}
+}
+
+// Resize scales frames to Width x Height. Either may be 0 to keep the aspect
+// ratio.
+type Resize struct {
+	Width  uint32
+	Height uint32
+	Kernel ResizeKernel
+}
+
+// Parse a size like 512x512, or 512x or x512 to keep the aspect ratio.
+func ParseResize(s string, kernel ResizeKernel) (Resize, error) {
+	matches := regexp.MustCompile(`^(\d*)x(\d*)$`).FindStringSubmatch(s)
+	if matches == nil || matches[1] == "" && matches[2] == "" {
+		return Resize{}, fmt.Errorf("invalid size %q, expecting WIDTHxHEIGHT", s)
+	}
+
+	var size [2]uint32
+	for i, m := range matches[1:] {
+		if m == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(m, 10, 32)
+		if err != nil || v == 0 {
+			return Resize{}, fmt.Errorf("invalid size %q, expecting WIDTHxHEIGHT", s)
+		}
+		size[i] = uint32(v)
+	}
+
+	return Resize{size[0], size[1], kernel}, nil
+}
+
+func (r Resize) Size(width, height uint32) (uint32, uint32, error) {
+	scale := func(v, to, from uint32) uint32 {
+		scaled := uint32(math.Round(float64(v) * float64(to) / float64(from)))
+		if scaled == 0 {
+			scaled = 1
+		}
+		return scaled
+	}
+
+	switch {
+	case r.Width == 0 && r.Height == 0:
+		return 0, 0, fmt.Errorf("resize needs a width or a height")
+	case r.Width == 0:
+		return scale(width, r.Height, height), r.Height, nil
+	case r.Height == 0:
+		return r.Width, scale(height, r.Width, width), nil
+	default:
+		return r.Width, r.Height, nil
+	}
+}
+
+func (r Resize) Apply(cv canvas.Canvas) canvas.Canvas {
+	width, height, _ := r.Size(cv.Width(), cv.Height())
+	return ResizeCanvas(cv, width, height, r.Kernel)
+}
+
+// Scale cv to width x height, interpolating premultiplied colors so that those
+// of transparent pixels don't bleed.
+func ResizeCanvas(cv canvas.Canvas, width, height uint32, kernel ResizeKernel) canvas.Canvas {
+	src := image.NewNRGBA64(image.Rect(0, 0, int(cv.Width()), int(cv.Height())))
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			r, g, b, a := cv.At(x, y).Rgba()
+			src.SetNRGBA64(int(x), int(y), color.NRGBA64{R: r, G: g, B: b, A: a})
+		}
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, int(width), int(height)))
+	kernel.interpolator().Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return ImageToCanvas(dst)
+}
+
+// Crop keeps the part of frames within Rect.
+type Crop struct {
+	Rect image.Rectangle
+}
+
+// Parse a rectangle like 400x300+10+20, of 400x300 pixels offset by 10, 20
+// from the top left corner.
+func ParseCrop(s string) (Crop, error) {
+	matches := regexp.MustCompile(`^(\d+)x(\d+)\+(\d+)\+(\d+)$`).FindStringSubmatch(s)
+	if matches == nil {
+		return Crop{}, fmt.Errorf("invalid crop %q, expecting WIDTHxHEIGHT+X+Y", s)
+	}
+
+	var v [4]int
+	for i, m := range matches[1:] {
+		n, err := strconv.ParseUint(m, 10, 31)
+		if err != nil {
+			return Crop{}, fmt.Errorf("invalid crop %q, expecting WIDTHxHEIGHT+X+Y", s)
+		}
+		v[i] = int(n)
+	}
+	if v[0] == 0 || v[1] == 0 {
+		return Crop{}, fmt.Errorf("invalid crop %q, the size can't be 0", s)
+	}
+
+	return Crop{image.Rect(v[2], v[3], v[2]+v[0], v[3]+v[1])}, nil
+}
+
+func (c Crop) Size(width, height uint32) (uint32, uint32, error) {
+	bounds := image.Rect(0, 0, int(width), int(height))
+	if c.Rect.Empty() || !c.Rect.In(bounds) {
+		return 0, 0, fmt.Errorf("crop %dx%d+%d+%d isn't within frames of %dx%d",
+			c.Rect.Dx(), c.Rect.Dy(), c.Rect.Min.X, c.Rect.Min.Y, width, height)
+	}
+
+	return uint32(c.Rect.Dx()), uint32(c.Rect.Dy()), nil
+}
+
+func (c Crop) Apply(cv canvas.Canvas) canvas.Canvas {
+	return CropCanvas(cv, c.Rect)
+}
+
+// The part of cv within rect, which must be within cv.
+func CropCanvas(cv canvas.Canvas, rect image.Rectangle) canvas.Canvas {
+	cropped := canvas.MakeCanvas(uint32(rect.Dx()), uint32(rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			cropped.WriteAt(uint32(x), uint32(y),
+				cv.At(uint32(rect.Min.X+x), uint32(rect.Min.Y+y)))
+		}
+	}
+
+	return cropped
+}
+
+// Rotate turns frames clockwise by Degrees, a multiple of 90.
+type Rotate struct {
+	Degrees int
+}
+
+func ParseRotate(s string) (Rotate, error) {
+	degrees, err := strconv.Atoi(s)
+	if err != nil || degrees%90 != 0 {
+		return Rotate{}, fmt.Errorf("invalid rotation %q, expecting 90, 180 or 270", s)
+	}
+
+	return Rotate{degrees}, nil
+}
+
+func (r Rotate) quarterTurns() int {
+	return (r.Degrees/90%4 + 4) % 4
+}
+
+func (r Rotate) Size(width, height uint32) (uint32, uint32, error) {
+	if r.Degrees%90 != 0 {
+		return 0, 0, fmt.Errorf("can't rotate by %d degrees, only multiples of 90", r.Degrees)
+	}
+	if r.quarterTurns()%2 == 1 {
+		return height, width, nil
+	}
+	return width, height, nil
+}
+
+func (r Rotate) Apply(cv canvas.Canvas) canvas.Canvas {
+	return RotateCanvas(cv, r.quarterTurns())
+}
+
+// Turn cv clockwise by quarterTurns times 90 degrees.
+func RotateCanvas(cv canvas.Canvas, quarterTurns int) canvas.Canvas {
+	w, h := cv.Width(), cv.Height()
+	turns := (quarterTurns%4 + 4) % 4
+	if turns%2 == 1 {
+		w, h = h, w
+	}
+
+	rotated := canvas.MakeCanvas(w, h)
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			toX, toY := x, y
+			switch turns {
+			case 1:
+				toX, toY = cv.Height()-1-y, x
+			case 2:
+				toX, toY = cv.Width()-1-x, cv.Height()-1-y
+			case 3:
+				toX, toY = y, cv.Width()-1-x
+			}
+			rotated.WriteAt(toX, toY, cv.At(x, y))
+		}
+	}
+
+	return rotated
+}
+
+// Flip mirrors frames left to right, or top to bottom if Vertical.
+type Flip struct {
+	Vertical bool
+}
+
+func ParseFlip(s string) (Flip, error) {
+	switch s {
+	case "horizontal", "h":
+		return Flip{false}, nil
+	case "vertical", "v":
+		return Flip{true}, nil
+	default:
+		return Flip{}, fmt.Errorf("invalid flip %q, expecting horizontal or vertical", s)
+	}
+}
+
+func (f Flip) Size(width, height uint32) (uint32, uint32, error) {
+	return width, height, nil
+}
+
+func (f Flip) Apply(cv canvas.Canvas) canvas.Canvas {
+	return FlipCanvas(cv, f.Vertical)
+}
+
+// Mirror cv left to right, or top to bottom if vertical.
+func FlipCanvas(cv canvas.Canvas, vertical bool) canvas.Canvas {
+	flipped := canvas.MakeCanvas(cv.Width(), cv.Height())
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			toX, toY := cv.Width()-1-x, y
+			if vertical {
+				toX, toY = x, cv.Height()-1-y
+			}
+			flipped.WriteAt(toX, toY, cv.At(x, y))
+		}
+	}
+
+	return flipped
+}
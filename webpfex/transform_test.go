@@ -0,0 +1,154 @@
+package webpfex
+
+import (
+	"image"
+	"testing"
+	"webpfex/canvas"
+)
+
+// A canvas whose pixels are all different, numbered from the top left.
+func makeNumberedCanvas(width, height uint32) canvas.Canvas {
+	cv := canvas.MakeCanvas(width, height)
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			cv.WriteAt(x, y, canvas.MakeColorRgba(uint16(y*width+x), 0, 0, 0xFFFF))
+		}
+	}
+
+	return cv
+}
+
+func numberAt(cv canvas.Canvas, x, y uint32) uint16 {
+	return cv.At(x, y).R()
+}
+
+func TestRotateCanvas(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	cv := makeNumberedCanvas(3, 2)
+	cases := map[int][]uint16{
+		0:  {0, 1, 2, 3, 4, 5},
+		1:  {3, 0, 4, 1, 5, 2},
+		2:  {5, 4, 3, 2, 1, 0},
+		3:  {2, 5, 1, 4, 0, 3},
+		-1: {2, 5, 1, 4, 0, 3},
+	}
+	for turns, expected := range cases {
+		rotated := RotateCanvas(cv, turns)
+		var got []uint16
+		for y := uint32(0); y < rotated.Height(); y++ {
+			for x := uint32(0); x < rotated.Width(); x++ {
+				got = append(got, numberAt(rotated, x, y))
+			}
+		}
+		if len(got) != len(expected) || (turns%2 != 0) != (rotated.Width() == 2) {
+			t.Fatalf("%d turns: unexpected size %dx%d", turns, rotated.Width(), rotated.Height())
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("%d turns: expecting %v, got %v", turns, expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestFlipCanvas(t *testing.T) {
+	cv := makeNumberedCanvas(3, 2)
+	if h := FlipCanvas(cv, false); numberAt(h, 0, 0) != 2 || numberAt(h, 2, 1) != 3 {
+		t.Error("Expecting a horizontal flip to mirror columns")
+	}
+	if v := FlipCanvas(cv, true); numberAt(v, 0, 0) != 3 || numberAt(v, 2, 1) != 2 {
+		t.Error("Expecting a vertical flip to mirror rows")
+	}
+}
+
+func TestCropCanvas(t *testing.T) {
+	cropped := CropCanvas(makeNumberedCanvas(4, 4), image.Rect(1, 2, 3, 4))
+	if cropped.Width() != 2 || cropped.Height() != 2 ||
+		numberAt(cropped, 0, 0) != 9 || numberAt(cropped, 1, 1) != 14 {
+		t.Errorf("Unexpected crop %dx%d", cropped.Width(), cropped.Height())
+	}
+}
+
+func TestResizeCanvas(t *testing.T) {
+	// Opaque red next to transparent green: resizing mustn't bleed the green.
+	cv := canvas.MakeCanvas(8, 8)
+	for y := uint32(0); y < 8; y++ {
+		for x := uint32(0); x < 8; x++ {
+			color := canvas.MakeColorRgba(0, 0xFFFF, 0, 0)
+			if x < 4 {
+				color = canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+			}
+			cv.WriteAt(x, y, color)
+		}
+	}
+
+	for _, kernel := range []ResizeKernel{ResizeLanczos, ResizeCatmullRom, ResizeBilinear, ResizeNearest} {
+		resized := ResizeCanvas(cv, 3, 5, kernel)
+		if resized.Width() != 3 || resized.Height() != 5 {
+			t.Fatalf("%s: unexpected size %dx%d", kernel, resized.Width(), resized.Height())
+		}
+		for y := uint32(0); y < 5; y++ {
+			for x := uint32(0); x < 3; x++ {
+				c := resized.At(x, y)
+				if c.A() > 0x0100 && (c.G() > 0x0100 || c.R() < 0xFE00) {
+					t.Errorf("%s: %d,%d is %016X, expecting red or transparent", kernel, x, y, c.Value())
+				}
+			}
+		}
+		if c := resized.At(0, 2); c.A() != 0xFFFF {
+			t.Errorf("%s: expecting the left edge opaque, got %016X", kernel, c.Value())
+		}
+	}
+}
+
+func TestTransformedSize(t *testing.T) {
+	resize, err := ParseResize("x50", ResizeLanczos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crop, err := ParseCrop("20x10+5+0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transforms := []Transform{resize, Rotate{90}, crop, Flip{}}
+
+	width, height, err := TransformedSize(transforms, 200, 100)
+	if err != nil || width != 20 || height != 10 {
+		t.Errorf("Expecting 20x10, got %dx%d %v", width, height, err)
+	}
+	if _, _, err := TransformedSize(transforms, 20, 400); err == nil {
+		t.Error("Expecting an error cropping outside of the frames")
+	}
+}
+
+func TestParseTransforms(t *testing.T) {
+	if r, err := ParseResize("512x256", ResizeNearest); err != nil || r != (Resize{512, 256, ResizeNearest}) {
+		t.Errorf("Unexpected resize %+v %v", r, err)
+	}
+	for _, s := range []string{"", "x", "0x10", "10", "10x10x10", "-1x5"} {
+		if _, err := ParseResize(s, ResizeLanczos); err == nil {
+			t.Errorf("%q: expecting a resize error", s)
+		}
+	}
+
+	if c, err := ParseCrop("4x3+2+1"); err != nil || c.Rect != image.Rect(2, 1, 6, 4) {
+		t.Errorf("Unexpected crop %+v %v", c, err)
+	}
+	for _, s := range []string{"4x3", "0x3+1+1", "4x3-1+1"} {
+		if _, err := ParseCrop(s); err == nil {
+			t.Errorf("%q: expecting a crop error", s)
+		}
+	}
+
+	if _, err := ParseRotate("45"); err == nil {
+		t.Error("Expecting an error rotating by 45 degrees")
+	}
+	if _, err := ParseFlip("diagonal"); err == nil {
+		t.Error("Expecting an error flipping diagonally")
+	}
+	if _, err := ParseResizeKernel("bicubic"); err == nil {
+		t.Error("Expecting an unknown filter error")
+	}
+}
@@ -0,0 +1,99 @@
+package webpfex
+
+import (
+	"context"
+	"image"
+	"webpfex/canvas"
+)
+
+// Union of the areas of every composited frame of the animated WEBP at path
+// that differ from the background, grown by padding within the canvas. The
+// background is the color of the corners of the first frame when they agree,
+// transparent otherwise. The whole canvas is returned when every frame is
+// background.
+func TrimBox(
+	ctx context.Context,
+	path string,
+	info AWebpInfo,
+	opts Options,
+	padding uint32,
+) (image.Rectangle, error) {
+	// Reported as the frames are composited again.
+	opts.Progress = nil
+	opts.Transforms = nil
+	var background *canvas.Color
+	var box image.Rectangle
+	err := CompositeAWebp(ctx, path, info, opts,
+		func(frameInfo AWebpFrameInfo, cv *canvas.Canvas) error {
+			if background == nil {
+				color := borderColor(cv)
+				background = &color
+			}
+			box = box.Union(foregroundRect(cv, *background))
+			return nil
+		})
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	bounds := image.Rect(0, 0, int(info.Width), int(info.Height))
+	if box.Empty() {
+		return bounds, nil
+	}
+	return box.Inset(-int(padding)).Intersect(bounds), nil
+}
+
+// Color of the corners of cv if they agree, transparent otherwise.
+func borderColor(cv *canvas.Canvas) canvas.Color {
+	right, bottom := cv.Width()-1, cv.Height()-1
+	color := cv.At(0, 0)
+	for _, c := range []canvas.Color{cv.At(right, 0), cv.At(0, bottom), cv.At(right, bottom)} {
+		if !sameColor8(c, color) {
+			return canvas.MakeColor(0)
+		}
+	}
+
+	return color
+}
+
+// Smallest rectangle of cv holding every pixel not of background.
+func foregroundRect(cv *canvas.Canvas, background canvas.Color) image.Rectangle {
+	var rect image.Rectangle
+	for y := uint32(0); y < cv.Height(); y++ {
+		for x := uint32(0); x < cv.Width(); x++ {
+			if sameColor8(cv.At(x, y), background) {
+				continue
+			}
+			p := image.Point{int(x), int(y)}
+			rect = rect.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+		}
+	}
+
+	return rect
+}
+
+// Whether a and b are the same 8-bit color, transparent colors all being the
+// same.
+func sameColor8(a, b canvas.Color) bool {
+	if a.A()>>8 == 0 && b.A()>>8 == 0 {
+		return true
+	}
+	return a.R()>>8 == b.R()>>8 && a.G()>>8 == b.G()>>8 &&
+		a.B()>>8 == b.B()>>8 && a.A()>>8 == b.A()>>8
+}
+
+// opts cropping frames to their trim box first if opts.Trim.
+func trimOptions(ctx context.Context, path string, info AWebpInfo, opts Options) (Options, error) {
+	if !opts.Trim {
+		return opts, nil
+	}
+
+	box, err := TrimBox(ctx, path, info, opts, opts.TrimPadding)
+	if err != nil {
+		return Options{}, err
+	}
+
+	opts.Trim = false
+	opts.Transforms = append([]Transform{Crop{box}}, opts.Transforms...)
+	return opts, nil
+}
@@ -0,0 +1,46 @@
+package webpfex
+
+import (
+	"image"
+	"testing"
+	"webpfex/canvas"
+)
+
+func TestForegroundRect(t *testing.T) {
+	white := canvas.MakeColorRgba(0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF)
+	red := canvas.MakeColorRgba(0xFFFF, 0, 0, 0xFFFF)
+
+	// Transparent margins, whatever their color.
+	cv := canvas.MakeCanvas(10, 8)
+	cv.WriteAt(9, 0, canvas.MakeColorRgba(0xFFFF, 0, 0, 0))
+	cv.WriteAt(3, 2, red)
+	cv.WriteAt(5, 6, red)
+	background := borderColor(&cv)
+	if background.A() != 0 {
+		t.Errorf("Expecting a transparent background, got %016X", background.Value())
+	}
+	if rect := foregroundRect(&cv, background); rect != image.Rect(3, 2, 6, 7) {
+		t.Errorf("Expecting 3,2-6,7, got %v", rect)
+	}
+
+	// Uniform margins.
+	for y := uint32(0); y < 8; y++ {
+		for x := uint32(0); x < 10; x++ {
+			cv.WriteAt(x, y, white)
+		}
+	}
+	cv.WriteAt(7, 1, red)
+	background = borderColor(&cv)
+	if background != white {
+		t.Errorf("Expecting a white background, got %016X", background.Value())
+	}
+	if rect := foregroundRect(&cv, background); rect != image.Rect(7, 1, 8, 2) {
+		t.Errorf("Expecting 7,1-8,2, got %v", rect)
+	}
+
+	// Corners that disagree aren't background.
+	cv.WriteAt(0, 0, red)
+	if borderColor(&cv).A() != 0 {
+		t.Error("Expecting disagreeing corners to fall back to transparent")
+	}
+}